@@ -0,0 +1,174 @@
+// Command keyctl manages the JWT signing key directory (JWT_KEYS_DIR).
+//
+// Rotating keys:
+//
+//	keyctl generate -kid 2025-06        # add a new key, not yet used for signing
+//	                                    # (-alg EdDSA for a key published at /.well-known/jwks.json)
+//	keyctl promote 2025-06              # sign new tokens with it; then send SIGHUP to the server
+//	keyctl remove 2025-01               # drop a retired key once its tokens have expired
+package main
+
+import (
+	"backend/pswd/internal/keystore"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// errUsage is returned by run for a missing or unknown command
+var errUsage = errors.New("usage")
+
+func main() {
+	log.SetFlags(0)
+
+	err := run(os.Args[1:], os.Stdout)
+	if err == errUsage {
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run executes one keyctl command, printing its results to out
+func run(args []string, out io.Writer) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+
+	cmd, args := args[0], args[1:]
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	dir := fs.String("dir", getEnv("JWT_KEYS_DIR", "./keys"), "key directory")
+
+	switch cmd {
+	case "generate":
+		kid := fs.String("kid", time.Now().UTC().Format("20060102-150405"), "ID of the new key")
+		alg := fs.String("alg", "HS256", "signing algorithm: HS256 or EdDSA")
+		activate := fs.Bool("activate", false, "promote the new key immediately")
+		if err := fs.Parse(args); err != nil {
+			return errUsage
+		}
+
+		var material []byte
+		var err error
+		switch *alg {
+		case "HS256":
+			material, err = generateSecret()
+		case "EdDSA":
+			material, err = generateEd25519Key()
+		default:
+			return fmt.Errorf("unsupported algorithm %q", *alg)
+		}
+		if err != nil {
+			return err
+		}
+		if err := keystore.Add(*dir, *kid, material); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "✓ generated %s key %s\n", *alg, *kid)
+
+		// The first key in a directory has to be active for the server to start
+		_, active, err := keystore.List(*dir)
+		if err != nil {
+			return err
+		}
+		if *activate || active == "" {
+			return promote(out, *dir, *kid)
+		}
+
+	case "promote":
+		if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+			return errUsage
+		}
+		return promote(out, *dir, fs.Arg(0))
+
+	case "remove":
+		if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+			return errUsage
+		}
+		if err := keystore.Remove(*dir, fs.Arg(0)); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "✓ removed key %s\n", fs.Arg(0))
+
+	case "list":
+		if err := fs.Parse(args); err != nil {
+			return errUsage
+		}
+		kids, active, err := keystore.List(*dir)
+		if err != nil {
+			return err
+		}
+		for _, kid := range kids {
+			marker := " "
+			if kid == active {
+				marker = "*"
+			}
+			fmt.Fprintf(out, "%s %s\n", marker, kid)
+		}
+
+	default:
+		return errUsage
+	}
+	return nil
+}
+
+func promote(out io.Writer, dir, kid string) error {
+	if err := keystore.Promote(dir, kid); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "✓ key %s is now active (send SIGHUP to running servers to reload)\n", kid)
+	return nil
+}
+
+// generateSecret creates a random HMAC secret, encoded so it can live in a text file
+func generateSecret() ([]byte, error) {
+	buf := make([]byte, 48)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return []byte(base64.StdEncoding.EncodeToString(buf) + "\n"), nil
+}
+
+// generateEd25519Key creates an Ed25519 private key as PKCS#8 PEM
+func generateEd25519Key() ([]byte, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: keyctl <command> [-dir DIR] [args]
+
+commands:
+  generate [-kid KID] [-alg HS256|EdDSA] [-activate]
+                                    add a new signing key
+  promote KID                       sign new tokens with KID
+  remove KID                        delete a retired key
+  list                              list keys (* marks the active key)`)
+	os.Exit(2)
+}
+
+// getEnv gets an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
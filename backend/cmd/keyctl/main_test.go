@@ -0,0 +1,128 @@
+package main
+
+import (
+	"backend/pswd/internal/keystore"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// keyctl runs a command against dir and returns what it printed
+func keyctl(t *testing.T, dir string, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	err := run(append(args[:1:1], append([]string{"-dir", dir}, args[1:]...)...), &out)
+	return out.String(), err
+}
+
+func TestKeyctlRotation(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+
+	// The first key is activated so the server can start
+	if _, err := keyctl(t, dir, "generate", "-kid", "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, active, err := keystore.List(dir); err != nil || active != "k1" {
+		t.Fatalf("first key is not active: %q, %v", active, err)
+	}
+
+	// Later ones are not, unless asked
+	if _, err := keyctl(t, dir, "generate", "-kid", "k2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, active, _ := keystore.List(dir); active != "k1" {
+		t.Errorf("generating a second key activated it (%q)", active)
+	}
+	if _, err := keyctl(t, dir, "generate", "-kid", "k3", "-activate"); err != nil {
+		t.Fatal(err)
+	}
+	if _, active, _ := keystore.List(dir); active != "k3" {
+		t.Errorf("-activate did not activate the key (%q)", active)
+	}
+
+	out, err := keyctl(t, dir, "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "  k1\n  k2\n* k3\n" {
+		t.Errorf("list printed %q", out)
+	}
+
+	if _, err := keyctl(t, dir, "remove", "k3"); err == nil {
+		t.Error("the active key was removed")
+	}
+	if _, err := keyctl(t, dir, "promote", "k2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyctl(t, dir, "remove", "k3"); err != nil {
+		t.Errorf("retired key was not removed: %v", err)
+	}
+	if _, err := keyctl(t, dir, "remove", "k1"); err != nil {
+		t.Errorf("retired key was not removed: %v", err)
+	}
+
+	keys, active, err := keystore.Load(dir)
+	if err != nil || active != "k2" || len(keys) != 1 {
+		t.Errorf("after rotation: %d keys, active %q, %v", len(keys), active, err)
+	}
+}
+
+func TestKeyctlGenerate(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := keyctl(t, dir, "generate", "-kid", "hmac"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyctl(t, dir, "generate", "-kid", "ed", "-alg", "EdDSA"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyctl(t, dir, "generate", "-kid", "rsa", "-alg", "RS256"); err == nil {
+		t.Error("unsupported algorithm was accepted")
+	}
+	if _, err := keyctl(t, dir, "generate", "-kid", "hmac"); err == nil {
+		t.Error("existing key was overwritten")
+	}
+
+	keys, _, err := keystore.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys["hmac"]) != 64 {
+		t.Errorf("HMAC secret has %d characters, want 64 (48 bytes, base64)", len(keys["hmac"]))
+	}
+
+	block, _ := pem.Decode(keys["ed"])
+	if block == nil {
+		t.Fatal("EdDSA key is not PEM")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := key.(ed25519.PrivateKey); !ok {
+		t.Errorf("EdDSA key is a %T", key)
+	}
+}
+
+func TestKeyctlUsage(t *testing.T) {
+	dir := t.TempDir()
+	for _, args := range [][]string{{}, {"rotate"}, {"promote"}, {"remove", "a", "b"}} {
+		if err := run(args, &bytes.Buffer{}); err != errUsage {
+			t.Errorf("%q: got %v, want the usage", args, err)
+		}
+	}
+	if _, err := keyctl(t, dir, "promote", "missing"); err == nil || err == errUsage {
+		t.Errorf("promoting a missing key: got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("failed commands left %d files", len(entries))
+	}
+	if _, err := keyctl(t, dir, "generate", "-kid", "bad/kid"); err == nil || !strings.Contains(err.Error(), "invalid key id") {
+		t.Errorf("invalid key ID: got %v", err)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"backend/pswd/internal/auth"
+	"backend/pswd/internal/events"
 	"backend/pswd/internal/handlers"
 	"backend/pswd/internal/middleware"
 	"context"
@@ -9,6 +11,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -22,6 +27,10 @@ var db *sql.DB
 func main() {
 	var err error
 
+	if err := auth.Init(); err != nil {
+		log.Fatal(err)
+	}
+
 	// Read from environment variables with defaults
 	dbHost := getEnv("DB_HOST", "localhost")
 	dbPort := getEnv("DB_PORT", "5432")
@@ -43,16 +52,62 @@ func main() {
 		log.Fatal(err)
 	}
 
+	recoveryDelay, err := time.ParseDuration(getEnv("MASTER_RECOVERY_DELAY", "72h"))
+	if err != nil || recoveryDelay < 0 {
+		log.Fatalf("invalid MASTER_RECOVERY_DELAY: %q", os.Getenv("MASTER_RECOVERY_DELAY"))
+	}
+
+	revisionRetention, err := strconv.Atoi(getEnv("ENTRY_REVISION_RETENTION", "20"))
+	if err != nil || revisionRetention < 0 {
+		log.Fatalf("invalid ENTRY_REVISION_RETENTION: %q", os.Getenv("ENTRY_REVISION_RETENTION"))
+	}
+
+	trashDays, err := strconv.Atoi(getEnv("TRASH_RETENTION_DAYS", "30"))
+	if err != nil || trashDays < 0 {
+		log.Fatalf("invalid TRASH_RETENTION_DAYS: %q", os.Getenv("TRASH_RETENTION_DAYS"))
+	}
+
+	tombstoneDays, err := strconv.Atoi(getEnv("TOMBSTONE_RETENTION_DAYS", "90"))
+	if err != nil || tombstoneDays < 1 {
+		log.Fatalf("invalid TOMBSTONE_RETENTION_DAYS: %q", os.Getenv("TOMBSTONE_RETENTION_DAYS"))
+	}
+
 	// Initialize handlers
-	h := &handlers.Handler{DB: db}
+	h := &handlers.Handler{
+		DB:                     db,
+		Require2FA:             getEnv("REQUIRE_2FA", "false") == "true",
+		MasterRecoveryDelay:    recoveryDelay,
+		EntryRevisionRetention: revisionRetention,
+		TrashRetention:         time.Duration(trashDays) * 24 * time.Hour,
+		TombstoneRetention:     time.Duration(tombstoneDays) * 24 * time.Hour,
+		Events:                 events.NewHub(),
+	}
+	h.StartTrashPurger(time.Hour)
+	h.StartDevicePurger(time.Hour)
 
 	// Initialize rate limiter
 	rps, burst := middleware.GetRateLimitConfig()
 	rateLimiter := middleware.NewIPRateLimiter(rps, burst)
 	rateLimiter.CleanupOldIPs(30 * time.Minute)
 
+	// Reload JWT signing keys on SIGHUP (e.g. after `keyctl promote`)
+	reloadKeys := make(chan os.Signal, 1)
+	signal.Notify(reloadKeys, syscall.SIGHUP)
+	go func() {
+		for range reloadKeys {
+			if err := auth.LoadKeys(); err != nil {
+				log.Println("❌ Failed to reload JWT keys:", err)
+				continue
+			}
+			log.Printf("🔑 JWT keys reloaded (active kid: %s)\n", auth.ActiveKID())
+		}
+	}()
+
 	env := getEnv("ENV", "development")
 	log.Printf("🔒 Rate limiting: %v req/s, burst: %d (ENV: %s)\n", rps, burst, env)
+	if h.Require2FA {
+		log.Println("🔐 Two-factor authentication required for all accounts")
+	}
 
 	r := chi.NewRouter()
 
@@ -61,9 +116,9 @@ func main() {
 	r.Use(chiMiddleware.Recoverer)
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:5173", "http://localhost:3000"}, // Frontend dev servers
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Pairing-Token", "If-Match"},
+		ExposedHeaders:   []string{"Link", "ETag"},
 		AllowCredentials: true,
 		MaxAge:           300, // Cache preflight for 5 minutes
 	}))
@@ -73,7 +128,26 @@ func main() {
 		r.Use(middleware.RateLimitMiddleware(rateLimiter))
 		r.Post("/api/auth/register", h.RegisterHandler)
 		r.Post("/api/auth/login", h.LoginHandler)
+		r.Post("/api/auth/challenge", h.ChallengeHandler)
+		r.Post("/api/auth/login/signature", h.SignatureLoginHandler)
+		r.Post("/api/auth/srp/init", h.SRPInitHandler)
+		r.Post("/api/auth/srp/verify", h.SRPVerifyHandler)
+		r.Post("/api/auth/login/2fa", h.TwoFactorLoginHandler)
+		r.Post("/api/auth/login/2fa/webauthn", h.WebAuthnMFABeginHandler)
+		r.Post("/api/auth/webauthn/login/begin", h.WebAuthnLoginBeginHandler)
+		r.Post("/api/auth/webauthn/login/finish", h.WebAuthnLoginFinishHandler)
+		r.Post("/api/devices/enroll", h.EnrollDeviceHandler)
+		r.Post("/api/master-recovery", h.StartMasterRecoveryHandler)
+		r.Post("/api/master-recovery/{recoveryID}/complete", h.CompleteMasterRecoveryHandler)
+		r.Post("/api/pairing/join", h.JoinPairingHandler)
+		r.Post("/api/pairing/{pairingID}/messages", h.PostPairingMessageHandler)
+		r.Get("/api/pairing/{pairingID}/messages", h.GetPairingMessagesHandler)
+		r.Post("/api/pairing/{pairingID}/login", h.PairingLoginHandler)
 		r.Post("/api/auth/logout", h.LogoutHandler)
+		r.Post("/api/auth/refresh", h.RefreshHandler)
+
+		// Public keys for services verifying EdDSA-signed tokens
+		r.Get("/.well-known/jwks.json", h.JWKSHandler)
 	})
 
 	// Protected routes
@@ -83,11 +157,153 @@ func main() {
 		// User info
 		r.Get("/api/user/me", h.GetUserInfoHandler)
 
-		// Vault entries
-		r.Post("/api/vault/entries", h.CreateVaultEntryHandler)
-		r.Get("/api/vault/entries", h.GetVaultEntriesHandler)
-		r.Put("/api/vault/entries/{entryID}", h.UpdateVaultEntryHandler)
-		r.Delete("/api/vault/entries/{entryID}", h.DeleteVaultEntryHandler)
+		// Sessions
+		r.Post("/api/auth/logout-all", h.LogoutAllHandler)
+		r.Get("/api/sessions", h.ListSessionsHandler)
+		r.Delete("/api/sessions/{sessionID}", h.RevokeSessionHandler)
+
+		// Security notifications, and cancelling a recovery the user didn't start
+		r.Get("/api/notifications", h.ListNotificationsHandler)
+		r.Delete("/api/master-recovery/{recoveryID}", h.CancelMasterRecoveryHandler)
+
+		// Move a password account to SRP
+		r.Post("/api/auth/srp/enroll", h.SRPEnrollHandler)
+
+		// Change the password, re-encrypting the private keys and entries with it
+		r.Post("/api/auth/password", h.ChangePasswordHandler)
+
+		// Two-factor enrollment (reachable before enrolling, even when 2FA is required)
+		r.Get("/api/auth/2fa", h.GetTwoFactorStatusHandler)
+		r.Post("/api/auth/2fa/totp/setup", h.SetupTOTPHandler)
+		r.Post("/api/auth/2fa/totp/confirm", h.ConfirmTOTPHandler)
+		r.Post("/api/auth/2fa/disable", h.DisableTOTPHandler)
+		r.Post("/api/auth/2fa/recovery-codes", h.RegenerateRecoveryCodesHandler)
+
+		// WebAuthn credentials (also count as a second factor)
+		r.Post("/api/webauthn/register/begin", h.WebAuthnRegisterBeginHandler)
+		r.Post("/api/webauthn/register/finish", h.WebAuthnRegisterFinishHandler)
+		r.Post("/api/webauthn/assert/begin", h.WebAuthnAssertBeginHandler)
+		r.Get("/api/webauthn/credentials", h.ListWebAuthnCredentialsHandler)
+		r.Put("/api/webauthn/credentials/{credentialID}/prf", h.SetWebAuthnPRFKeyHandler)
+		r.Delete("/api/webauthn/credentials/{credentialID}", h.DeleteWebAuthnCredentialHandler)
+
+		// Routes below need an enrolled second factor when REQUIRE_2FA is set
+		r.Group(func(r chi.Router) {
+			r.Use(h.RequireTwoFactor)
+
+			// Devices
+			r.Get("/api/devices", h.ListDevicesHandler)
+			r.Patch("/api/devices/{deviceID}", h.RenameDeviceHandler)
+			r.Delete("/api/devices/{deviceID}", h.RevokeDeviceHandler)
+
+			// Device enrollment (master device only)
+			r.Get("/api/devices/pending", h.ListPendingDevicesHandler)
+			r.Post("/api/devices/{deviceID}/approve", h.ApproveDeviceHandler)
+			r.Post("/api/devices/{deviceID}/reject", h.RejectDeviceHandler)
+
+			// Device pairing (master device only)
+			r.Post("/api/pairing", h.CreatePairingHandler)
+			r.Post("/api/pairing/{pairingID}/complete", h.CompletePairingHandler)
+			r.Delete("/api/pairing/{pairingID}", h.CancelPairingHandler)
+
+			// Master role
+			r.Post("/api/master-transfers", h.CreateMasterTransferHandler)
+			r.Get("/api/master-transfers", h.ListMasterTransfersHandler)
+			r.Post("/api/master-transfers/{transferID}/accept", h.AcceptMasterTransferHandler)
+			r.Delete("/api/master-transfers/{transferID}", h.CancelMasterTransferHandler)
+			r.Put("/api/account/recovery-key", h.SetRecoveryKeyHandler)
+
+			// Vault entries and key envelopes, mounted per vault and, under /api/vault,
+			// for the default vault
+			vaultRoutes := func(r chi.Router) {
+				r.Use(h.VaultAccess)
+
+				r.Get("/entries", h.GetVaultEntriesHandler)
+				r.Get("/entries/{entryID}", h.GetVaultEntryHandler)
+				r.Get("/entries/{entryID}/revisions", h.ListEntryRevisionsHandler)
+				r.Get("/trash", h.ListTrashHandler)
+				r.Get("/key", h.GetVaultKeyHandler)
+				r.Get("/members", h.ListVaultMembersHandler)
+				r.Delete("/members/{userID}", h.RemoveVaultMemberHandler)
+				r.Get("/teams", h.ListVaultTeamsHandler)
+
+				r.Group(func(r chi.Router) {
+					r.Use(h.RequireVaultWrite)
+					r.Post("/entries", h.CreateVaultEntryHandler)
+					r.Put("/entries/{entryID}", h.UpdateVaultEntryHandler)
+					r.Delete("/entries/{entryID}", h.DeleteVaultEntryHandler)
+					r.Post("/batch", h.BatchEntriesHandler)
+					r.Post("/entries/{entryID}/revisions/{revisionID}/restore", h.RestoreEntryRevisionHandler)
+					r.Post("/trash/{entryID}/restore", h.RestoreTrashEntryHandler)
+					r.Delete("/trash/{entryID}", h.PurgeTrashEntryHandler)
+					r.Delete("/trash", h.EmptyTrashHandler)
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(h.RequireVaultOwner)
+					r.Get("/keys", h.ListVaultKeysHandler)
+					r.Put("/keys/{deviceID}", h.PutVaultKeyHandler)
+					r.Delete("/keys/{deviceID}", h.DeleteVaultKeyHandler)
+					r.Post("/keys/rotate", h.RotateVaultKeyHandler)
+					r.Post("/members", h.ShareVaultHandler)
+					r.Patch("/members/{userID}", h.UpdateVaultMemberHandler)
+					r.Post("/teams", h.AssignTeamVaultHandler)
+					r.Delete("/teams/{teamID}", h.UnassignTeamVaultHandler)
+				})
+			}
+
+			// Vaults
+			r.Get("/api/vaults", h.ListVaultsHandler)
+			r.Post("/api/vaults", h.CreateVaultHandler)
+			r.Route("/api/vaults/{vaultID}", func(r chi.Router) {
+				vaultRoutes(r)
+				r.Get("/", h.GetVaultHandler)
+				r.With(h.RequireVaultOwner).Patch("/", h.RenameVaultHandler)
+				r.With(h.RequireVaultOwner).Delete("/", h.DeleteVaultHandler)
+			})
+			r.Route("/api/vault", vaultRoutes)
+
+			// Sharing
+			r.Get("/api/users/{username}/keys", h.GetUserKeysHandler)
+			r.Get("/api/vault-invites", h.ListVaultInvitesHandler)
+			r.Post("/api/vault-invites/{vaultID}/accept", h.AcceptVaultInviteHandler)
+			r.Post("/api/vault-invites/{vaultID}/decline", h.DeclineVaultInviteHandler)
+
+			// Entries across every vault the user can reach
+			r.Get("/api/entries", h.ListEntriesHandler)
+
+			// Delta sync across every vault the user can reach
+			r.Get("/api/sync", h.SyncHandler)
+
+			// Live change notifications (Server-Sent Events)
+			r.Get("/api/events", h.EventsHandler)
+
+			// Organizations and teams
+			r.Get("/api/orgs", h.ListOrgsHandler)
+			r.Post("/api/orgs", h.CreateOrgHandler)
+			r.Route("/api/orgs/{orgID}", func(r chi.Router) {
+				r.Use(h.OrgAccess)
+
+				r.Get("/", h.GetOrgHandler)
+				r.Delete("/", h.DeleteOrgHandler)
+				r.Get("/members", h.ListOrgMembersHandler)
+				r.Delete("/members/{userID}", h.RemoveOrgMemberHandler)
+				r.Get("/teams", h.ListTeamsHandler)
+				r.Get("/teams/{teamID}/members", h.ListTeamMembersHandler)
+				r.Get("/teams/{teamID}/vaults", h.ListTeamVaultsHandler)
+
+				r.Group(func(r chi.Router) {
+					r.Use(h.RequireOrgAdmin)
+					r.Patch("/", h.RenameOrgHandler)
+					r.Post("/members", h.AddOrgMemberHandler)
+					r.Patch("/members/{userID}", h.UpdateOrgMemberHandler)
+					r.Post("/teams", h.CreateTeamHandler)
+					r.Delete("/teams/{teamID}", h.DeleteTeamHandler)
+					r.Put("/teams/{teamID}/members/{userID}", h.AddTeamMemberHandler)
+					r.Delete("/teams/{teamID}/members/{userID}", h.RemoveTeamMemberHandler)
+				})
+			})
+		})
 	})
 
 	// Erase DB data
@@ -146,7 +362,7 @@ func initSchema(ctx context.Context, db *sql.DB) error {
 
 		if !hasUserID {
 			log.Println("❌ Existing users table is missing user_id column!")
-			log.Println("   Please run: DROP TABLE IF EXISTS vault_entries, vaults, devices, users CASCADE;")
+			log.Println("   Please run: DROP VIEW IF EXISTS vault_access; DROP TABLE IF EXISTS vault_entry_tombstones, vault_entry_revisions, team_vaults, team_members, teams, org_members, organizations, vault_members, vault_keys, pairing_messages, pairing_sessions, account_notifications, master_recoveries, master_transfers, webauthn_challenges, webauthn_credentials, mfa_challenges, recovery_codes, srp_handshakes, auth_challenges, refresh_tokens, sessions, vault_entries, vaults, devices, users CASCADE;")
 			return fmt.Errorf("schema mismatch: users table exists but missing user_id column")
 		}
 		log.Println("✓ Schema verification passed")
@@ -208,6 +424,485 @@ func initSchema(ctx context.Context, db *sql.DB) error {
 				updated_at TIMESTAMP DEFAULT now()
 			)`,
 		},
+		{
+			name: "sessions table",
+			sql: `CREATE TABLE IF NOT EXISTS sessions (
+				session_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+				user_id UUID REFERENCES users(user_id) ON DELETE CASCADE,
+				device_id UUID REFERENCES devices(device_id) ON DELETE CASCADE,
+				user_agent TEXT,
+				ip_address TEXT,
+				created_at TIMESTAMP DEFAULT now(),
+				last_used_at TIMESTAMP DEFAULT now(),
+				expires_at TIMESTAMP NOT NULL,
+				revoked_at TIMESTAMP
+			)`,
+		},
+		{
+			name: "sessions user index",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id)`,
+		},
+		{
+			name: "refresh_tokens table",
+			sql: `CREATE TABLE IF NOT EXISTS refresh_tokens (
+				token_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+				session_id UUID REFERENCES sessions(session_id) ON DELETE CASCADE,
+				token_hash TEXT UNIQUE NOT NULL,
+				created_at TIMESTAMP DEFAULT now(),
+				expires_at TIMESTAMP NOT NULL,
+				used_at TIMESTAMP
+			)`,
+		},
+		{
+			name: "devices pk_device_sign column",
+			sql:  `ALTER TABLE devices ADD COLUMN IF NOT EXISTS pk_device_sign TEXT`,
+		},
+		{
+			name: "auth_challenges table",
+			sql: `CREATE TABLE IF NOT EXISTS auth_challenges (
+				challenge_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+				user_id UUID REFERENCES users(user_id) ON DELETE CASCADE,
+				device_id UUID REFERENCES devices(device_id) ON DELETE CASCADE,
+				nonce TEXT NOT NULL,
+				created_at TIMESTAMP DEFAULT now(),
+				expires_at TIMESTAMP NOT NULL,
+				used_at TIMESTAMP
+			)`,
+		},
+		{
+			name: "users srp columns",
+			sql: `ALTER TABLE users
+				ADD COLUMN IF NOT EXISTS srp_salt TEXT,
+				ADD COLUMN IF NOT EXISTS srp_verifier TEXT`,
+		},
+		{
+			name: "srp_handshakes table",
+			sql: `CREATE TABLE IF NOT EXISTS srp_handshakes (
+				handshake_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+				user_id UUID REFERENCES users(user_id) ON DELETE CASCADE,
+				device_id UUID REFERENCES devices(device_id) ON DELETE CASCADE,
+				a_pub TEXT NOT NULL,
+				b_pub TEXT NOT NULL,
+				b_secret TEXT NOT NULL,
+				created_at TIMESTAMP DEFAULT now(),
+				expires_at TIMESTAMP NOT NULL,
+				used_at TIMESTAMP
+			)`,
+		},
+		{
+			name: "users totp columns",
+			sql: `ALTER TABLE users
+				ADD COLUMN IF NOT EXISTS totp_secret TEXT,
+				ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT false,
+				ADD COLUMN IF NOT EXISTS totp_last_step BIGINT`,
+		},
+		{
+			name: "recovery_codes table",
+			sql: `CREATE TABLE IF NOT EXISTS recovery_codes (
+				code_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+				user_id UUID REFERENCES users(user_id) ON DELETE CASCADE,
+				code_hash TEXT NOT NULL,
+				created_at TIMESTAMP DEFAULT now(),
+				used_at TIMESTAMP
+			)`,
+		},
+		{
+			name: "mfa_challenges table",
+			sql: `CREATE TABLE IF NOT EXISTS mfa_challenges (
+				challenge_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+				token_hash TEXT UNIQUE NOT NULL,
+				user_id UUID REFERENCES users(user_id) ON DELETE CASCADE,
+				device_id UUID REFERENCES devices(device_id) ON DELETE CASCADE,
+				attempts INT NOT NULL DEFAULT 0,
+				created_at TIMESTAMP DEFAULT now(),
+				expires_at TIMESTAMP NOT NULL,
+				used_at TIMESTAMP
+			)`,
+		},
+		{
+			name: "webauthn_credentials table",
+			sql: `CREATE TABLE IF NOT EXISTS webauthn_credentials (
+				credential_id TEXT PRIMARY KEY,
+				user_id UUID REFERENCES users(user_id) ON DELETE CASCADE,
+				device_id UUID REFERENCES devices(device_id) ON DELETE CASCADE,
+				name TEXT NOT NULL,
+				public_key TEXT NOT NULL,
+				alg INT NOT NULL,
+				sign_count BIGINT NOT NULL DEFAULT 0,
+				prf_salt TEXT NOT NULL,
+				prf_enabled BOOLEAN NOT NULL DEFAULT false,
+				wrapped_vault_key TEXT,
+				created_at TIMESTAMP DEFAULT now(),
+				last_used_at TIMESTAMP
+			)`,
+		},
+		{
+			name: "webauthn_credentials user index",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_id ON webauthn_credentials(user_id)`,
+		},
+		{
+			name: "webauthn_challenges table",
+			sql: `CREATE TABLE IF NOT EXISTS webauthn_challenges (
+				challenge_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+				user_id UUID REFERENCES users(user_id) ON DELETE CASCADE,
+				device_id UUID REFERENCES devices(device_id) ON DELETE CASCADE,
+				purpose TEXT NOT NULL,
+				challenge TEXT NOT NULL,
+				prf_salt TEXT,
+				created_at TIMESTAMP DEFAULT now(),
+				expires_at TIMESTAMP NOT NULL,
+				used_at TIMESTAMP
+			)`,
+		},
+		{
+			// wrapped_vault_key is superseded by vault_keys (see the migration below)
+			name: "devices enrollment columns",
+			sql: `ALTER TABLE devices
+				ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active',
+				ADD COLUMN IF NOT EXISTS wrapped_vault_key TEXT`,
+		},
+		{
+			name: "users recovery key columns",
+			sql: `ALTER TABLE users
+				ADD COLUMN IF NOT EXISTS pk_recovery TEXT,
+				ADD COLUMN IF NOT EXISTS recovery_wrapped_vault_key TEXT`,
+		},
+		{
+			name: "master_transfers table",
+			sql: `CREATE TABLE IF NOT EXISTS master_transfers (
+				transfer_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+				user_id UUID REFERENCES users(user_id) ON DELETE CASCADE,
+				from_device_id UUID REFERENCES devices(device_id) ON DELETE CASCADE,
+				to_device_id UUID REFERENCES devices(device_id) ON DELETE CASCADE,
+				nonce TEXT UNIQUE NOT NULL,
+				from_signature TEXT NOT NULL,
+				to_signature TEXT,
+				created_at TIMESTAMP DEFAULT now(),
+				expires_at TIMESTAMP NOT NULL,
+				completed_at TIMESTAMP,
+				cancelled_at TIMESTAMP
+			)`,
+		},
+		{
+			name: "master_recoveries table",
+			sql: `CREATE TABLE IF NOT EXISTS master_recoveries (
+				recovery_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+				user_id UUID REFERENCES users(user_id) ON DELETE CASCADE,
+				device_id UUID REFERENCES devices(device_id) ON DELETE CASCADE,
+				nonce TEXT UNIQUE NOT NULL,
+				signature TEXT NOT NULL,
+				created_at TIMESTAMP DEFAULT now(),
+				effective_at TIMESTAMP NOT NULL,
+				completed_at TIMESTAMP,
+				cancelled_at TIMESTAMP
+			)`,
+		},
+		{
+			name: "account_notifications table",
+			sql: `CREATE TABLE IF NOT EXISTS account_notifications (
+				notification_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+				user_id UUID REFERENCES users(user_id) ON DELETE CASCADE,
+				kind TEXT NOT NULL,
+				device_id UUID REFERENCES devices(device_id) ON DELETE SET NULL,
+				related_id UUID,
+				created_at TIMESTAMP DEFAULT now()
+			)`,
+		},
+		{
+			name: "account_notifications user index",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_account_notifications_user_id ON account_notifications(user_id, created_at)`,
+		},
+		{
+			name: "pairing_sessions table",
+			sql: `CREATE TABLE IF NOT EXISTS pairing_sessions (
+				pairing_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+				user_id UUID REFERENCES users(user_id) ON DELETE CASCADE,
+				initiator_device_id UUID REFERENCES devices(device_id) ON DELETE CASCADE,
+				joiner_device_id UUID REFERENCES devices(device_id) ON DELETE SET NULL,
+				code TEXT NOT NULL,
+				initiator_token_hash TEXT NOT NULL,
+				joiner_token_hash TEXT,
+				status TEXT NOT NULL DEFAULT 'open',
+				expires_at TIMESTAMP NOT NULL,
+				completed_at TIMESTAMP,
+				session_issued_at TIMESTAMP,
+				created_at TIMESTAMP DEFAULT now()
+			)`,
+		},
+		{
+			// Codes are short, so they are only unique among sessions still waiting for a device
+			name: "pairing_sessions open code index",
+			sql:  `CREATE UNIQUE INDEX IF NOT EXISTS idx_pairing_sessions_open_code ON pairing_sessions(code) WHERE status = 'open'`,
+		},
+		{
+			name: "pairing_messages table",
+			sql: `CREATE TABLE IF NOT EXISTS pairing_messages (
+				message_id BIGSERIAL PRIMARY KEY,
+				pairing_id UUID REFERENCES pairing_sessions(pairing_id) ON DELETE CASCADE,
+				sender TEXT NOT NULL,
+				payload TEXT NOT NULL,
+				created_at TIMESTAMP DEFAULT now()
+			)`,
+		},
+		{
+			name: "vaults key_version column",
+			sql:  `ALTER TABLE vaults ADD COLUMN IF NOT EXISTS key_version INT NOT NULL DEFAULT 1`,
+		},
+		{
+			name: "vault_keys table",
+			sql: `CREATE TABLE IF NOT EXISTS vault_keys (
+				vault_id UUID REFERENCES vaults(vault_id) ON DELETE CASCADE,
+				device_id UUID REFERENCES devices(device_id) ON DELETE CASCADE,
+				wrapped_key TEXT NOT NULL,
+				key_version INT NOT NULL,
+				created_at TIMESTAMP DEFAULT now(),
+				PRIMARY KEY (vault_id, device_id)
+			)`,
+		},
+		{
+			name: "vault_keys device index",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_vault_keys_device_id ON vault_keys(device_id)`,
+		},
+		{
+			// Give every user a vault row and move keys approved before vault_keys existed
+			// into envelopes. devices.wrapped_vault_key is cleared so this only runs once.
+			name: "vault_keys migration",
+			sql: `INSERT INTO vaults (user_id)
+				SELECT u.user_id FROM users u
+				WHERE NOT EXISTS (SELECT 1 FROM vaults v WHERE v.user_id = u.user_id);
+				INSERT INTO vault_keys (vault_id, device_id, wrapped_key, key_version)
+				SELECT DISTINCT ON (d.device_id) v.vault_id, d.device_id, d.wrapped_vault_key, v.key_version
+				FROM devices d
+				JOIN vaults v ON v.user_id = d.user_id
+				WHERE d.wrapped_vault_key IS NOT NULL
+				ORDER BY d.device_id, v.created_at
+				ON CONFLICT DO NOTHING;
+				UPDATE devices SET wrapped_vault_key = NULL WHERE wrapped_vault_key IS NOT NULL`,
+		},
+		{
+			name: "vaults name columns",
+			sql: `ALTER TABLE vaults
+				ADD COLUMN IF NOT EXISTS name TEXT NOT NULL DEFAULT 'Personal',
+				ADD COLUMN IF NOT EXISTS is_default BOOLEAN NOT NULL DEFAULT false`,
+		},
+		{
+			name: "vault_entries vault_id column",
+			sql:  `ALTER TABLE vault_entries ADD COLUMN IF NOT EXISTS vault_id UUID REFERENCES vaults(vault_id) ON DELETE CASCADE`,
+		},
+		{
+			// Mark each user's oldest vault as the default and move entries that predate
+			// vault_id into it
+			name: "default vaults migration",
+			sql: `UPDATE vaults SET is_default = true
+				WHERE vault_id IN (SELECT DISTINCT ON (user_id) vault_id FROM vaults ORDER BY user_id, created_at)
+				AND NOT EXISTS (SELECT 1 FROM vaults v WHERE v.user_id = vaults.user_id AND v.is_default);
+				UPDATE vault_entries e SET vault_id = v.vault_id
+				FROM vaults v
+				WHERE v.user_id = e.user_id AND v.is_default AND e.vault_id IS NULL`,
+		},
+		{
+			name: "vaults default index",
+			sql:  `CREATE UNIQUE INDEX IF NOT EXISTS idx_vaults_default ON vaults(user_id) WHERE is_default`,
+		},
+		{
+			name: "vault_members table",
+			sql: `CREATE TABLE IF NOT EXISTS vault_members (
+				vault_id UUID REFERENCES vaults(vault_id) ON DELETE CASCADE,
+				user_id UUID REFERENCES users(user_id) ON DELETE CASCADE,
+				role TEXT NOT NULL,
+				status TEXT NOT NULL DEFAULT 'pending',
+				invited_by UUID REFERENCES users(user_id) ON DELETE SET NULL,
+				wrapped_key TEXT NOT NULL,
+				key_version INT NOT NULL,
+				signature TEXT NOT NULL,
+				created_at TIMESTAMP DEFAULT now(),
+				accepted_at TIMESTAMP,
+				PRIMARY KEY (vault_id, user_id)
+			)`,
+		},
+		{
+			name: "vault_members user index",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_vault_members_user_id ON vault_members(user_id)`,
+		},
+		{
+			name: "vault_entries vault index",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_vault_entries_vault_id ON vault_entries(vault_id)`,
+		},
+		{
+			name: "organizations table",
+			sql: `CREATE TABLE IF NOT EXISTS organizations (
+				org_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+				name TEXT NOT NULL,
+				created_by UUID REFERENCES users(user_id) ON DELETE SET NULL,
+				created_at TIMESTAMP DEFAULT now()
+			)`,
+		},
+		{
+			// wrapped_org_key is the organization key sealed to the member's pk_encrypt
+			name: "org_members table",
+			sql: `CREATE TABLE IF NOT EXISTS org_members (
+				org_id UUID REFERENCES organizations(org_id) ON DELETE CASCADE,
+				user_id UUID REFERENCES users(user_id) ON DELETE CASCADE,
+				role TEXT NOT NULL,
+				wrapped_org_key TEXT NOT NULL,
+				created_at TIMESTAMP DEFAULT now(),
+				PRIMARY KEY (org_id, user_id)
+			)`,
+		},
+		{
+			name: "org_members user index",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_org_members_user_id ON org_members(user_id)`,
+		},
+		{
+			name: "teams table",
+			sql: `CREATE TABLE IF NOT EXISTS teams (
+				team_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+				org_id UUID REFERENCES organizations(org_id) ON DELETE CASCADE,
+				name TEXT NOT NULL,
+				created_at TIMESTAMP DEFAULT now()
+			)`,
+		},
+		{
+			name: "team_members table",
+			sql: `CREATE TABLE IF NOT EXISTS team_members (
+				team_id UUID REFERENCES teams(team_id) ON DELETE CASCADE,
+				user_id UUID REFERENCES users(user_id) ON DELETE CASCADE,
+				created_at TIMESTAMP DEFAULT now(),
+				PRIMARY KEY (team_id, user_id)
+			)`,
+		},
+		{
+			name: "team_members user index",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_team_members_user_id ON team_members(user_id)`,
+		},
+		{
+			// wrapped_key is the vault key encrypted with the organization key
+			name: "team_vaults table",
+			sql: `CREATE TABLE IF NOT EXISTS team_vaults (
+				team_id UUID REFERENCES teams(team_id) ON DELETE CASCADE,
+				vault_id UUID REFERENCES vaults(vault_id) ON DELETE CASCADE,
+				role TEXT NOT NULL,
+				wrapped_key TEXT NOT NULL,
+				key_version INT NOT NULL,
+				created_at TIMESTAMP DEFAULT now(),
+				PRIMARY KEY (team_id, vault_id)
+			)`,
+		},
+		{
+			name: "team_vaults vault index",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_team_vaults_vault_id ON team_vaults(vault_id)`,
+		},
+		{
+			// Previous versions of an entry, encrypted with the vault key of key_version
+			name: "vault_entry_revisions table",
+			sql: `CREATE TABLE IF NOT EXISTS vault_entry_revisions (
+				revision_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+				entry_id UUID REFERENCES vault_entries(entry_id) ON DELETE CASCADE,
+				vault_id UUID REFERENCES vaults(vault_id) ON DELETE CASCADE,
+				title TEXT NOT NULL,
+				encrypted_data TEXT NOT NULL,
+				entry_type TEXT,
+				key_version INT NOT NULL,
+				replaced_by UUID REFERENCES devices(device_id) ON DELETE SET NULL,
+				created_at TIMESTAMP DEFAULT now()
+			)`,
+		},
+		{
+			name: "vault_entry_revisions entry index",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_vault_entry_revisions_entry_id ON vault_entry_revisions(entry_id, created_at)`,
+		},
+		{
+			// Orders revisions even when several are saved in one transaction (same now())
+			name: "vault_entry_revisions seq column",
+			sql:  `ALTER TABLE vault_entry_revisions ADD COLUMN IF NOT EXISTS seq BIGSERIAL`,
+		},
+		{
+			name: "vault_entry_revisions entry seq index",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_vault_entry_revisions_entry_seq ON vault_entry_revisions(entry_id, seq)`,
+		},
+		{
+			// Set when an entry is moved to the trash; the trash purger deletes it for good later
+			name: "vault_entries deleted_at column",
+			sql:  `ALTER TABLE vault_entries ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+		},
+		{
+			name: "vault_entries trash index",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_vault_entries_deleted_at ON vault_entries(deleted_at) WHERE deleted_at IS NOT NULL`,
+		},
+		{
+			// Bumped on every change to an entry; writes must send it back in If-Match
+			name: "vault_entries revision column",
+			sql:  `ALTER TABLE vault_entries ADD COLUMN IF NOT EXISTS revision INT NOT NULL DEFAULT 1`,
+		},
+		{
+			// Change numbers for sync: vaults.change_seq is the last one handed out, and
+			// each entry or tombstone carries the number of its latest change
+			name: "vaults change_seq column",
+			sql:  `ALTER TABLE vaults ADD COLUMN IF NOT EXISTS change_seq BIGINT NOT NULL DEFAULT 0`,
+		},
+		{
+			name: "vault_entries change_seq column",
+			sql:  `ALTER TABLE vault_entries ADD COLUMN IF NOT EXISTS change_seq BIGINT NOT NULL DEFAULT 0`,
+		},
+		{
+			name: "vault_entries change_seq index",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_vault_entries_change_seq ON vault_entries(vault_id, change_seq)`,
+		},
+		{
+			name: "vault_entry_tombstones table",
+			sql: `CREATE TABLE IF NOT EXISTS vault_entry_tombstones (
+				entry_id UUID PRIMARY KEY,
+				vault_id UUID REFERENCES vaults(vault_id) ON DELETE CASCADE,
+				change_seq BIGINT NOT NULL,
+				created_at TIMESTAMP DEFAULT now()
+			)`,
+		},
+		{
+			name: "vault_entry_tombstones vault index",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_vault_entry_tombstones_vault_id ON vault_entry_tombstones(vault_id, change_seq)`,
+		},
+		{
+			// Entries from before change numbers get one, so a sync picks them up
+			name: "vault_entries change_seq backfill",
+			sql: `WITH stale AS (
+					SELECT DISTINCT vault_id FROM vault_entries WHERE change_seq = 0
+				), bumped AS (
+					UPDATE vaults v SET change_seq = v.change_seq + 1
+					FROM stale WHERE v.vault_id = stale.vault_id
+					RETURNING v.vault_id, v.change_seq
+				)
+				UPDATE vault_entries e SET change_seq = b.change_seq
+				FROM bumped b
+				WHERE e.vault_id = b.vault_id AND e.change_seq = 0`,
+		},
+		{
+			// The newest change number whose tombstone was purged; cursors before it are stale
+			name: "vaults tombstones_purged_seq column",
+			sql:  `ALTER TABLE vaults ADD COLUMN IF NOT EXISTS tombstones_purged_seq BIGINT NOT NULL DEFAULT 0`,
+		},
+		{
+			name: "vault_entry_tombstones created_at index",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_vault_entry_tombstones_created_at ON vault_entry_tombstones(created_at)`,
+		},
+		{
+			// The private keys encrypted with a key derived from the password, replaced
+			// together with the password so they never get out of step
+			name: "users encrypted_private_keys column",
+			sql:  `ALTER TABLE users ADD COLUMN IF NOT EXISTS encrypted_private_keys TEXT`,
+		},
+		{
+			// Every way a user can reach a vault, ranked so the strongest grant wins
+			name: "vault_access view",
+			sql: `CREATE OR REPLACE VIEW vault_access AS
+				SELECT vault_id, user_id, 'owner' AS role, 3 AS rank FROM vaults
+				UNION ALL
+				SELECT vault_id, user_id, role, CASE role WHEN 'write' THEN 2 ELSE 1 END
+				FROM vault_members WHERE status = 'accepted'
+				UNION ALL
+				SELECT tv.vault_id, tm.user_id, tv.role, CASE tv.role WHEN 'write' THEN 2 ELSE 1 END
+				FROM team_vaults tv
+				JOIN team_members tm ON tm.team_id = tv.team_id`,
+		},
 	}
 
 	for _, stmt := range statements {
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"backend/pswd/internal/keystore"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"os"
+)
+
+// decoySecret keys the fake salts and credentials returned for unknown accounts. It is
+// kept apart from the signing keys: those rotate, and fake values that changed with
+// them while real ones stayed put would tell the two apart.
+var decoySecret []byte
+
+// loadDecoySecret reads the decoy secret from DECOY_SECRET or, failing that, from the
+// JWT_KEYS_DIR key directory (creating it there once). A lone JWT_SECRET never rotates,
+// so it can stand in; JWT_KEYS deployments have to set DECOY_SECRET.
+func loadDecoySecret() error {
+	switch {
+	case os.Getenv("DECOY_SECRET") != "":
+		if len(os.Getenv("DECOY_SECRET")) < 32 {
+			return errors.New("DECOY_SECRET must be at least 32 characters")
+		}
+		decoySecret = []byte(os.Getenv("DECOY_SECRET"))
+
+	case os.Getenv("JWT_KEYS_DIR") != "":
+		secret, err := keystore.DecoySecret(os.Getenv("JWT_KEYS_DIR"))
+		if err != nil {
+			return err
+		}
+		decoySecret = secret
+
+	case os.Getenv("JWT_KEYS") == "" && os.Getenv("JWT_SECRET") != "":
+		h := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+		h.Write([]byte("pswd-decoy-secret"))
+		decoySecret = h.Sum(nil)
+
+	default:
+		return errors.New("DECOY_SECRET is required when signing keys come from JWT_KEYS")
+	}
+	return nil
+}
+
+// decoyMAC returns an HMAC keyed with the decoy secret, bound to purpose
+func decoyMAC(purpose string) hash.Hash {
+	key := hmac.New(sha256.New, decoySecret)
+	key.Write([]byte(purpose))
+	return hmac.New(sha256.New, key.Sum(nil))
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"sort"
+)
+
+// JSONWebKey is the public half of an EdDSA signing key in JWK form (RFC 8037)
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+}
+
+// JSONWebKeySet is the document served at /.well-known/jwks.json
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns the public keys of every EdDSA key in the keyring, including retired
+// ones, so other services can verify tokens without sharing a secret.
+// HS256 keys are symmetric and are never published.
+func JWKS() JSONWebKeySet {
+	kr := currentKeyring()
+
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for kid, key := range kr.keys {
+		if key.alg != AlgEdDSA {
+			continue
+		}
+
+		publicKey := key.privateKey.Public().(ed25519.PublicKey)
+		set.Keys = append(set.Keys, JSONWebKey{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(publicKey),
+			Kid: kid,
+			Alg: AlgEdDSA,
+			Use: "sig",
+		})
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
@@ -1,13 +1,10 @@
 package auth
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"strings"
@@ -19,38 +16,129 @@ import (
 // NOTE: In a production app, use a proper JWT library like github.com/golang-jwt/jwt
 // This is a minimal implementation for demonstration purposes
 
-var jwtSecret []byte
+// Init loads the signing keys and the token, decoy and WebAuthn settings from the
+// environment. Call it once at startup, before issuing or validating anything.
+func Init() error {
+	if err := LoadKeys(); err != nil {
+		return err
+	}
+	if err := loadDecoySecret(); err != nil {
+		return err
+	}
+	if err := loadTokenConfig(); err != nil {
+		return err
+	}
+	return loadWebAuthnConfig()
+}
+
+// AccessTokenTTL is how long an issued access token stays valid.
+// Clients renew it with a refresh token (see RefreshTokenTTL).
+const AccessTokenTTL = 15 * time.Minute
 
-func init() {
-	secretStr := os.Getenv("JWT_SECRET")
-	if secretStr == "" {
-		log.Fatal("JWT_SECRET environment variable is required")
+// Token validation settings, read from JWT_ISSUER, JWT_AUDIENCE and JWT_LEEWAY
+var (
+	tokenIssuer   = "pswd"
+	tokenAudience = "pswd-api"
+	tokenLeeway   = 30 * time.Second // Tolerated clock skew for exp, nbf and iat
+)
+
+// now is the clock used for issuing and validating tokens
+var now = time.Now
+
+func loadTokenConfig() error {
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		tokenIssuer = issuer
+	}
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		tokenAudience = audience
 	}
-	if len(secretStr) < 32 {
-		log.Fatal("JWT_SECRET must be at least 32 characters for security")
+	if leeway := os.Getenv("JWT_LEEWAY"); leeway != "" {
+		d, err := time.ParseDuration(leeway)
+		if err != nil || d < 0 || d > 5*time.Minute {
+			return fmt.Errorf("JWT_LEEWAY must be a duration between 0s and 5m, got %q", leeway)
+		}
+		tokenLeeway = d
 	}
-	jwtSecret = []byte(secretStr)
+	return nil
+}
+
+type tokenHeader struct {
+	Alg  string   `json:"alg"`
+	Typ  string   `json:"typ"`
+	Kid  string   `json:"kid,omitempty"`
+	Crit []string `json:"crit,omitempty"`
 }
 
 type Claims struct {
-	UserID    string `json:"user_id"`
-	Username  string `json:"username"`
-	DeviceID  string `json:"device_id"`
-	ExpiresAt int64  `json:"exp"`
+	ID        string   `json:"jti"` // Session ID; checked against the sessions table on every request
+	Issuer    string   `json:"iss"`
+	Audience  Audience `json:"aud"`
+	IssuedAt  int64    `json:"iat"`
+	NotBefore int64    `json:"nbf"`
+	ExpiresAt int64    `json:"exp"`
+	UserID    string   `json:"user_id"`
+	Username  string   `json:"username"`
+	DeviceID  string   `json:"device_id"`
+}
+
+// Audience is the "aud" claim, which RFC 7519 allows as a single string or an array
+type Audience []string
+
+// MarshalJSON encodes a single audience as a plain string
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// UnmarshalJSON accepts both the string and the array form
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return errors.New("aud must be a string or an array of strings")
+	}
+	*a = multiple
+	return nil
+}
+
+// contains reports whether the audience includes aud
+func (a Audience) contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
 }
 
-// GenerateToken creates a JWT token for a user
-func GenerateToken(userID, username, deviceID string) (string, error) {
+// GenerateToken creates a short-lived JWT access token for a user, bound to the given session
+func GenerateToken(userID, username, deviceID, sessionID string) (string, error) {
+	issuedAt := now()
 	claims := Claims{
+		ID:        sessionID,
+		Issuer:    tokenIssuer,
+		Audience:  Audience{tokenAudience},
+		IssuedAt:  issuedAt.Unix(),
+		NotBefore: issuedAt.Unix(),
+		ExpiresAt: issuedAt.Add(AccessTokenTTL).Unix(),
 		UserID:    userID,
 		Username:  username,
 		DeviceID:  deviceID,
-		ExpiresAt: time.Now().Add(24 * time.Hour * 30).Unix(), // 30 days
 	}
 
-	header := map[string]string{
-		"alg": "HS256",
-		"typ": "JWT",
+	kid, key := currentKeyring().signingKey()
+
+	header := tokenHeader{
+		Alg: key.alg,
+		Typ: "JWT",
+		Kid: kid,
 	}
 
 	headerJSON, _ := json.Marshal(header)
@@ -60,27 +148,58 @@ func GenerateToken(userID, username, deviceID string) (string, error) {
 	claimsB64 := base64.RawURLEncoding.EncodeToString(claimsJSON)
 
 	message := headerB64 + "." + claimsB64
-	signature := sign(message)
+	signature := key.sign(message)
 
 	return message + "." + signature, nil
 }
 
-// ValidateToken validates a JWT token and returns the claims
+// ValidateToken validates a JWT token and returns the claims.
+// It rejects tokens whose alg isn't the one of the key named by kid (including "none"),
+// tokens with unsupported critical headers, bad signatures, and tokens that are expired,
+// not yet valid, issued in the future, or minted for another issuer or audience.
 func ValidateToken(tokenString string) (*Claims, error) {
 	parts := strings.Split(tokenString, ".")
-	if len(parts) != 3 {
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
 		return nil, errors.New("invalid token format")
 	}
 
+	headerJSON, err := base64.RawURLEncoding.Strict().DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("invalid header encoding")
+	}
+
+	var header tokenHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, errors.New("invalid header")
+	}
+	if header.Typ != "" && header.Typ != "JWT" {
+		return nil, errors.New("unexpected token type")
+	}
+	if len(header.Crit) > 0 {
+		return nil, errors.New("unsupported critical header")
+	}
+	if header.Alg != AlgHS256 && header.Alg != AlgEdDSA {
+		return nil, errors.New("unexpected signing algorithm")
+	}
+
+	// Select the verification key by kid so tokens signed before a rotation stay valid.
+	// The algorithm is pinned by the key, never chosen by the token.
+	key, ok := currentKeyring().verificationKey(header.Kid)
+	if !ok {
+		return nil, errors.New("unknown signing key")
+	}
+	if header.Alg != key.alg {
+		return nil, errors.New("unexpected signing algorithm")
+	}
+
 	message := parts[0] + "." + parts[1]
 	signature := parts[2]
 
-	expectedSignature := sign(message)
-	if signature != expectedSignature {
+	if !key.verify(message, signature) {
 		return nil, errors.New("invalid signature")
 	}
 
-	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	claimsJSON, err := base64.RawURLEncoding.Strict().DecodeString(parts[1])
 	if err != nil {
 		return nil, errors.New("invalid claims encoding")
 	}
@@ -90,17 +209,42 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		return nil, errors.New("invalid claims")
 	}
 
-	if time.Now().Unix() > claims.ExpiresAt {
-		return nil, errors.New("token expired")
+	if err := validateClaims(&claims, now()); err != nil {
+		return nil, err
 	}
 
 	return &claims, nil
 }
 
-func sign(message string) string {
-	h := hmac.New(sha256.New, jwtSecret)
-	h.Write([]byte(message))
-	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+// validateClaims checks the registered claims against the configured issuer,
+// audience and clock, allowing tokenLeeway of clock skew
+func validateClaims(claims *Claims, t time.Time) error {
+	current := t.Unix()
+	leeway := int64(tokenLeeway.Seconds())
+
+	if claims.ExpiresAt == 0 || claims.IssuedAt == 0 {
+		return errors.New("missing exp or iat claim")
+	}
+	if current > claims.ExpiresAt+leeway {
+		return errors.New("token expired")
+	}
+	if claims.NotBefore != 0 && current < claims.NotBefore-leeway {
+		return errors.New("token not yet valid")
+	}
+	if claims.IssuedAt > current+leeway {
+		return errors.New("token issued in the future")
+	}
+	if claims.Issuer != tokenIssuer {
+		return errors.New("unexpected issuer")
+	}
+	if !claims.Audience.contains(tokenAudience) {
+		return errors.New("unexpected audience")
+	}
+	if claims.ID == "" || claims.UserID == "" {
+		return errors.New("missing jti or user_id claim")
+	}
+
+	return nil
 }
 
 // ExtractToken extracts the token from the Authorization header or cookie
@@ -136,13 +280,13 @@ func SetAuthCookie(w http.ResponseWriter, token string, maxAge int) {
 		Value:    token,
 		Path:     "/",
 		MaxAge:   maxAge,
-		HttpOnly: true,                         // Prevents JavaScript access (XSS protection)
+		HttpOnly: true,                             // Prevents JavaScript access (XSS protection)
 		Secure:   os.Getenv("ENV") == "production", // Only send over HTTPS in production
-		SameSite: http.SameSiteStrictMode,      // Strict CSRF protection
+		SameSite: http.SameSiteStrictMode,          // Strict CSRF protection
 	})
 }
 
-// ClearAuthCookie removes the auth cookie
+// ClearAuthCookie removes the auth cookie and the refresh token cookie
 func ClearAuthCookie(w http.ResponseWriter) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "auth_token",
@@ -153,6 +297,7 @@ func ClearAuthCookie(w http.ResponseWriter) {
 		Secure:   os.Getenv("ENV") == "production",
 		SameSite: http.SameSiteStrictMode,
 	})
+	ClearRefreshCookie(w)
 }
 
 // HashPassword hashes a password using bcrypt with a cost of 12.
@@ -0,0 +1,414 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTime is the fixed clock the token tests run at
+var testTime = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// useClock fixes the package clock for the duration of a test
+func useClock(t *testing.T, at time.Time) {
+	t.Helper()
+	previous := now
+	now = func() time.Time { return at }
+	t.Cleanup(func() { now = previous })
+}
+
+// useKeyring swaps the package keyring for the duration of a test
+func useKeyring(t *testing.T, kr *Keyring) {
+	t.Helper()
+	keyringMu.Lock()
+	previous := keyring
+	keyring = kr
+	keyringMu.Unlock()
+	t.Cleanup(func() {
+		keyringMu.Lock()
+		keyring = previous
+		keyringMu.Unlock()
+	})
+}
+
+// testKeyring returns a keyring with an active EdDSA key "ed" and an HS256 key "hs"
+func testKeyring(t *testing.T) (*Keyring, ed25519.PrivateKey) {
+	t.Helper()
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Keyring{
+		keys: map[string]*signingKey{
+			"ed": {alg: AlgEdDSA, privateKey: privateKey},
+			"hs": {alg: AlgHS256, secret: []byte("another-secret-that-is-at-least-32-chars")},
+		},
+		active: "ed",
+	}, privateKey
+}
+
+// validClaims returns claims that pass validation at testTime
+func validClaims() Claims {
+	return Claims{
+		ID:        "session-1",
+		Issuer:    tokenIssuer,
+		Audience:  Audience{tokenAudience},
+		IssuedAt:  testTime.Unix(),
+		NotBefore: testTime.Unix(),
+		ExpiresAt: testTime.Add(AccessTokenTTL).Unix(),
+		UserID:    "user-1",
+		Username:  "alice",
+		DeviceID:  "device-1",
+	}
+}
+
+func encodeSegment(t *testing.T, v any) string {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// signSegments signs already encoded header and claims segments with key
+func signSegments(key *signingKey, headerB64, claimsB64 string) string {
+	message := headerB64 + "." + claimsB64
+	return message + "." + key.sign(message)
+}
+
+// makeToken signs claims with the keyring's key kid, using its algorithm
+func makeToken(t *testing.T, kr *Keyring, kid string, claims Claims) string {
+	t.Helper()
+	key := kr.keys[kid]
+	header := encodeSegment(t, tokenHeader{Alg: key.alg, Typ: "JWT", Kid: kid})
+	return signSegments(key, header, encodeSegment(t, claims))
+}
+
+const base64URLAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+// nonCanonical re-encodes the last character of an unpadded base64url segment with
+// one of its unused low bits set: lenient decoders accept it, strict ones don't
+func nonCanonical(t *testing.T, segment string) string {
+	t.Helper()
+	if len(segment)%4 == 0 {
+		t.Fatalf("segment %q has no unused bits", segment)
+	}
+	last := strings.IndexByte(base64URLAlphabet, segment[len(segment)-1])
+	return segment[:len(segment)-1] + string(base64URLAlphabet[last^1])
+}
+
+// flipFirst changes the high bit of the first base64url character of a segment,
+// which always changes the first decoded byte
+func flipFirst(segment string) string {
+	first := strings.IndexByte(base64URLAlphabet, segment[0])
+	return string(base64URLAlphabet[first^0x20]) + segment[1:]
+}
+
+func TestGenerateAndValidateToken(t *testing.T) {
+	kr, _ := testKeyring(t)
+	useKeyring(t, kr)
+	useClock(t, testTime)
+
+	token, err := GenerateToken("user-1", "alice", "device-1", "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := ValidateToken(token)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if claims.UserID != "user-1" || claims.ID != "session-1" || claims.DeviceID != "device-1" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	useClock(t, testTime.Add(AccessTokenTTL+tokenLeeway+time.Second))
+	if _, err := ValidateToken(token); err == nil {
+		t.Error("token was accepted after it expired")
+	}
+}
+
+func TestValidateTokenAfterRotation(t *testing.T) {
+	kr, _ := testKeyring(t)
+	useKeyring(t, kr)
+	useClock(t, testTime)
+
+	// A token signed by a key that is no longer active still verifies by its kid
+	token := makeToken(t, kr, "hs", validClaims())
+	if _, err := ValidateToken(token); err != nil {
+		t.Errorf("token from the previous key was rejected: %v", err)
+	}
+}
+
+func TestValidateTokenRejectsMalformedHeaders(t *testing.T) {
+	kr, privateKey := testKeyring(t)
+	useKeyring(t, kr)
+	useClock(t, testTime)
+
+	claims := encodeSegment(t, validClaims())
+
+	// alg swap: an HS256 token for the EdDSA key, "signed" with its public key bytes
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	swapHeader := encodeSegment(t, tokenHeader{Alg: AlgHS256, Typ: "JWT", Kid: "ed"})
+	mac := hmac.New(sha256.New, publicKey)
+	mac.Write([]byte(swapHeader + "." + claims))
+	algSwap := swapHeader + "." + claims + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	validHeader := encodeSegment(t, tokenHeader{Alg: AlgEdDSA, Typ: "JWT", Kid: "ed"})
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{
+			name:  "alg none without signature",
+			token: encodeSegment(t, map[string]string{"alg": "none", "typ": "JWT"}) + "." + claims + ".",
+			want:  "invalid token format",
+		},
+		{
+			name:  "alg none with signature",
+			token: encodeSegment(t, map[string]string{"alg": "none", "kid": "ed"}) + "." + claims + ".c2ln",
+			want:  "unexpected signing algorithm",
+		},
+		{
+			name:  "alg swap to HS256",
+			token: algSwap,
+			want:  "unexpected signing algorithm",
+		},
+		{
+			name:  "unknown kid",
+			token: signSegments(kr.keys["ed"], encodeSegment(t, tokenHeader{Alg: AlgEdDSA, Typ: "JWT", Kid: "gone"}), claims),
+			want:  "unknown signing key",
+		},
+		{
+			name:  "missing kid without a legacy key",
+			token: signSegments(kr.keys["ed"], encodeSegment(t, tokenHeader{Alg: AlgEdDSA, Typ: "JWT"}), claims),
+			want:  "unknown signing key",
+		},
+		{
+			name:  "crit header",
+			token: signSegments(kr.keys["ed"], encodeSegment(t, tokenHeader{Alg: AlgEdDSA, Typ: "JWT", Kid: "ed", Crit: []string{"exp"}}), claims),
+			want:  "unsupported critical header",
+		},
+		{
+			name:  "unexpected typ",
+			token: signSegments(kr.keys["ed"], encodeSegment(t, tokenHeader{Alg: AlgEdDSA, Typ: "JWE", Kid: "ed"}), claims),
+			want:  "unexpected token type",
+		},
+		{
+			name:  "two segments",
+			token: validHeader + "." + claims,
+			want:  "invalid token format",
+		},
+		{
+			name:  "header not json",
+			token: signSegments(kr.keys["ed"], base64.RawURLEncoding.EncodeToString([]byte("{alg")), claims),
+			want:  "invalid header",
+		},
+		{
+			name:  "padded header",
+			token: signSegments(kr.keys["ed"], base64.URLEncoding.EncodeToString([]byte(`{"alg":"EdDSA","kid":"ed"}`)), claims),
+			want:  "invalid header encoding",
+		},
+		{
+			name:  "standard base64 header",
+			token: signSegments(kr.keys["ed"], validHeader+"+/", claims),
+			want:  "invalid header encoding",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ValidateToken(tt.token)
+			if err == nil {
+				t.Fatal("token was accepted")
+			}
+			if err.Error() != tt.want {
+				t.Errorf("got error %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTokenRejectsNonCanonicalBase64(t *testing.T) {
+	kr, _ := testKeyring(t)
+	useKeyring(t, kr)
+	useClock(t, testTime)
+
+	key := kr.keys["ed"]
+
+	// Pad the header until its encoding has unused bits
+	header := encodeSegment(t, tokenHeader{Alg: AlgEdDSA, Typ: "JWT", Kid: "ed"})
+	for pad := "x"; len(header)%4 == 0; pad += "x" {
+		header = encodeSegment(t, map[string]string{"alg": AlgEdDSA, "typ": "JWT", "kid": "ed", "pad": pad})
+	}
+	claimsJSON, _ := json.Marshal(validClaims())
+	for len(base64.RawURLEncoding.EncodeToString(claimsJSON))%4 == 0 {
+		claimsJSON = append(claimsJSON, ' ')
+	}
+	claims := base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	if _, err := ValidateToken(signSegments(key, header, claims)); err != nil {
+		t.Fatalf("canonical token was rejected: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"header", signSegments(key, nonCanonical(t, header), claims), "invalid header encoding"},
+		{"claims", signSegments(key, header, nonCanonical(t, claims)), "invalid claims encoding"},
+	}
+
+	// Ed25519 signatures are 64 bytes, so their encoding always has unused bits
+	token := signSegments(key, header, claims)
+	dot := strings.LastIndexByte(token, '.')
+	tests = append(tests, struct {
+		name  string
+		token string
+		want  string
+	}{"signature", token[:dot+1] + nonCanonical(t, token[dot+1:]), "invalid signature"})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ValidateToken(tt.token)
+			if err == nil {
+				t.Fatal("token was accepted")
+			}
+			if err.Error() != tt.want {
+				t.Errorf("got error %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTokenRejectsTampering(t *testing.T) {
+	kr, _ := testKeyring(t)
+	useKeyring(t, kr)
+	useClock(t, testTime)
+
+	for _, kid := range []string{"ed", "hs"} {
+		t.Run(kid, func(t *testing.T) {
+			token := makeToken(t, kr, kid, validClaims())
+			parts := strings.Split(token, ".")
+
+			forged := validClaims()
+			forged.UserID = "user-2"
+			tamperedClaims := parts[0] + "." + encodeSegment(t, forged) + "." + parts[2]
+
+			tamperedSignature := parts[0] + "." + parts[1] + "." + flipFirst(parts[2])
+
+			for name, token := range map[string]string{
+				"claims":    tamperedClaims,
+				"signature": tamperedSignature,
+				"truncated": parts[0] + "." + parts[1] + "." + parts[2][:len(parts[2])-4],
+			} {
+				if _, err := ValidateToken(token); err == nil || err.Error() != "invalid signature" {
+					t.Errorf("tampered %s: got %v, want invalid signature", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateTokenTimeClaims(t *testing.T) {
+	kr, _ := testKeyring(t)
+	useKeyring(t, kr)
+	useClock(t, testTime)
+
+	at := testTime.Unix()
+	leeway := int64(tokenLeeway.Seconds())
+
+	tests := []struct {
+		name   string
+		modify func(*Claims)
+		want   string // Empty when the token is valid
+	}{
+		{"exp within leeway", func(c *Claims) { c.IssuedAt, c.NotBefore, c.ExpiresAt = at-600, at-600, at-leeway }, ""},
+		{"exp past leeway", func(c *Claims) { c.IssuedAt, c.NotBefore, c.ExpiresAt = at-600, at-600, at-leeway-1 }, "token expired"},
+		{"nbf within leeway", func(c *Claims) { c.NotBefore = at + leeway }, ""},
+		{"nbf past leeway", func(c *Claims) { c.NotBefore = at + leeway + 1 }, "token not yet valid"},
+		{"iat within leeway", func(c *Claims) { c.IssuedAt = at + leeway }, ""},
+		{"iat past leeway", func(c *Claims) { c.IssuedAt = at + leeway + 1 }, "token issued in the future"},
+		{"no nbf", func(c *Claims) { c.NotBefore = 0 }, ""},
+		{"no exp", func(c *Claims) { c.ExpiresAt = 0 }, "missing exp or iat claim"},
+		{"no iat", func(c *Claims) { c.IssuedAt = 0 }, "missing exp or iat claim"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			tt.modify(&claims)
+
+			_, err := ValidateToken(makeToken(t, kr, "ed", claims))
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("token was rejected: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTokenIssuerAndAudience(t *testing.T) {
+	kr, _ := testKeyring(t)
+	useKeyring(t, kr)
+	useClock(t, testTime)
+
+	tests := []struct {
+		name   string
+		modify func(*Claims)
+		want   string
+	}{
+		{"wrong issuer", func(c *Claims) { c.Issuer = "someone-else" }, "unexpected issuer"},
+		{"no issuer", func(c *Claims) { c.Issuer = "" }, "unexpected issuer"},
+		{"wrong audience", func(c *Claims) { c.Audience = Audience{"another-api"} }, "unexpected audience"},
+		{"no audience", func(c *Claims) { c.Audience = nil }, "unexpected audience"},
+		{"audience list", func(c *Claims) { c.Audience = Audience{"another-api", tokenAudience} }, ""},
+		{"no session", func(c *Claims) { c.ID = "" }, "missing jti or user_id claim"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			tt.modify(&claims)
+
+			_, err := ValidateToken(makeToken(t, kr, "ed", claims))
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("token was rejected: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAudienceJSON(t *testing.T) {
+	var single, multiple Audience
+	if err := json.Unmarshal([]byte(`"pswd-api"`), &single); err != nil || len(single) != 1 {
+		t.Errorf("string audience: %v %v", single, err)
+	}
+	if err := json.Unmarshal([]byte(`["a","b"]`), &multiple); err != nil || len(multiple) != 2 {
+		t.Errorf("array audience: %v %v", multiple, err)
+	}
+	if err := json.Unmarshal([]byte(`42`), &single); err == nil {
+		t.Error("numeric audience was accepted")
+	}
+}
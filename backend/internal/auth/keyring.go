@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"backend/pswd/internal/keystore"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// defaultKID identifies the key loaded from the legacy JWT_SECRET variable
+const defaultKID = "default"
+
+// Supported token signing algorithms
+const (
+	AlgHS256 = "HS256" // HMAC-SHA256 with a shared secret
+	AlgEdDSA = "EdDSA" // Ed25519; verifiers only need the public key (see JWKS)
+)
+
+// signingKey is a single keyring entry. The algorithm follows from the key material:
+// a PEM-encoded Ed25519 private key is EdDSA, anything else is an HS256 secret.
+type signingKey struct {
+	alg        string
+	secret     []byte
+	privateKey ed25519.PrivateKey
+}
+
+// Keyring holds every key that may verify a token, indexed by key ID ("kid").
+// Only the active key signs new tokens; the others keep verifying tokens issued
+// before the last rotation until they expire.
+type Keyring struct {
+	keys   map[string]*signingKey
+	active string
+}
+
+var (
+	keyringMu sync.RWMutex
+	keyring   *Keyring
+)
+
+// LoadKeys (re)loads the signing keys from the environment. Sources, in order of precedence:
+//   - JWT_KEYS_DIR: a key directory managed with the keyctl command
+//   - JWT_KEYS: a "kid:secret,kid:secret" list, with JWT_ACTIVE_KID naming the signing key
+//     (defaults to the first key in the list)
+//   - JWT_SECRET: a single HS256 key with kid "default"
+//
+// Switching between HS256 and EdDSA is a regular key rotation: promote a key of the
+// other type (see `keyctl generate -alg`).
+func LoadKeys() error {
+	kr, err := keyringFromEnv()
+	if err != nil {
+		return err
+	}
+
+	keyringMu.Lock()
+	keyring = kr
+	keyringMu.Unlock()
+	return nil
+}
+
+func keyringFromEnv() (*Keyring, error) {
+	material := make(map[string][]byte)
+	kr := &Keyring{keys: make(map[string]*signingKey)}
+
+	switch {
+	case os.Getenv("JWT_KEYS_DIR") != "":
+		keys, active, err := keystore.Load(os.Getenv("JWT_KEYS_DIR"))
+		if err != nil {
+			return nil, err
+		}
+		material, kr.active = keys, active
+
+	case os.Getenv("JWT_KEYS") != "":
+		for _, pair := range strings.Split(os.Getenv("JWT_KEYS"), ",") {
+			kid, secret, ok := strings.Cut(strings.TrimSpace(pair), ":")
+			if !ok {
+				return nil, errors.New("JWT_KEYS entries must be formatted as kid:secret")
+			}
+			if err := keystore.ValidateKID(kid); err != nil {
+				return nil, err
+			}
+			material[kid] = []byte(secret)
+			if kr.active == "" {
+				kr.active = kid
+			}
+		}
+		if active := os.Getenv("JWT_ACTIVE_KID"); active != "" {
+			kr.active = active
+		}
+
+	case os.Getenv("JWT_SECRET") != "":
+		material[defaultKID] = []byte(os.Getenv("JWT_SECRET"))
+		kr.active = defaultKID
+
+	default:
+		return nil, errors.New("JWT_SECRET, JWT_KEYS or JWT_KEYS_DIR environment variable is required")
+	}
+
+	for kid, raw := range material {
+		key, err := parseSigningKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %q: %w", kid, err)
+		}
+		kr.keys[kid] = key
+	}
+
+	if _, ok := kr.keys[kr.active]; !ok {
+		return nil, fmt.Errorf("active key %q is not configured", kr.active)
+	}
+
+	return kr, nil
+}
+
+// parseSigningKey turns key material into a signing key. PEM-encoded PKCS#8 Ed25519
+// private keys become EdDSA keys; any other value is used as an HS256 secret.
+func parseSigningKey(material []byte) (*signingKey, error) {
+	if block, _ := pem.Decode(material); block != nil {
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %w", err)
+		}
+		privateKey, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("only Ed25519 private keys are supported")
+		}
+		return &signingKey{alg: AlgEdDSA, privateKey: privateKey}, nil
+	}
+
+	if len(material) < 32 {
+		return nil, errors.New("HS256 secrets must be at least 32 characters for security")
+	}
+	return &signingKey{alg: AlgHS256, secret: material}, nil
+}
+
+func currentKeyring() *Keyring {
+	keyringMu.RLock()
+	defer keyringMu.RUnlock()
+	return keyring
+}
+
+// ActiveKID returns the ID of the key currently used to sign new tokens
+func ActiveKID() string {
+	return currentKeyring().active
+}
+
+// signingKey returns the active key and its ID
+func (k *Keyring) signingKey() (string, *signingKey) {
+	return k.active, k.keys[k.active]
+}
+
+// verificationKey looks up a key by ID. Tokens without a kid header predate
+// key rotation and are checked against the legacy JWT_SECRET key, if configured.
+func (k *Keyring) verificationKey(kid string) (*signingKey, bool) {
+	if kid == "" {
+		kid = defaultKID
+	}
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+// sign returns the base64url-encoded signature of message
+func (k *signingKey) sign(message string) string {
+	if k.alg == AlgEdDSA {
+		return base64.RawURLEncoding.EncodeToString(ed25519.Sign(k.privateKey, []byte(message)))
+	}
+	return base64.RawURLEncoding.EncodeToString(k.mac(message))
+}
+
+// verify checks a base64url-encoded signature over message.
+// HMAC signatures are compared in constant time.
+func (k *signingKey) verify(message, signature string) bool {
+	sig, err := base64.RawURLEncoding.Strict().DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	if k.alg == AlgEdDSA {
+		return ed25519.Verify(k.privateKey.Public().(ed25519.PublicKey), []byte(message), sig)
+	}
+	return hmac.Equal(sig, k.mac(message))
+}
+
+func (k *signingKey) mac(message string) []byte {
+	h := hmac.New(sha256.New, k.secret)
+	h.Write([]byte(message))
+	return h.Sum(nil)
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Device pairing lets an enrolled device add a new one without the new device ever
+// seeing the password. The server only creates the channel and relays opaque messages;
+// the devices run the key exchange themselves:
+//
+//  1. The existing device A opens a pairing session and shows its short code (or a QR code).
+//  2. The new device B joins with the code and its pk_device.
+//  3. A sends a commitment H(epkA), B replies with epkB, A reveals epkA. Committing first
+//     stops a relay in the middle from picking a key that produces a matching SAS.
+//  4. Both derive k = X25519(eskA, epkB) and show a short authentication string derived
+//     from k and the transcript; the user checks that both screens match.
+//  5. A sends the vault key encrypted under k and completes the session, which activates B.
+//     B then exchanges its pairing token for a session.
+//
+// PairingTTL bounds the whole exchange.
+const PairingTTL = 10 * time.Minute
+
+// pairingAlphabet leaves out characters that are easy to misread (0/O, 1/I/L)
+const pairingAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+const pairingCodeLength = 8
+
+// GeneratePairingCode returns a random code of pairingCodeLength characters, shown as XXXX-XXXX
+func GeneratePairingCode() (string, error) {
+	buf := make([]byte, pairingCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate pairing code: %w", err)
+	}
+
+	// 256 isn't a multiple of the alphabet size; the bias is negligible here. Failed joins
+	// aren't counted per session (a wrong code names no session), so guessing is only
+	// slowed by the per-IP rate limit: 31^8 codes against a session open for PairingTTL,
+	// and a lucky guess still has to match the SAS shown on the master device.
+	code := make([]byte, pairingCodeLength)
+	for i, b := range buf {
+		code[i] = pairingAlphabet[int(b)%len(pairingAlphabet)]
+	}
+	return string(code[:4]) + "-" + string(code[4:]), nil
+}
+
+// NormalizePairingCode accepts codes typed in lowercase or without the dash
+func NormalizePairingCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != pairingCodeLength {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// RefreshTokenTTL is how long a refresh token (and the session it belongs to) stays
+// valid without being used. Every use rotates the token and extends the session.
+const RefreshTokenTTL = 24 * time.Hour * 30 // 30 days
+
+// refreshCookiePath limits the refresh cookie to the auth endpoints so it is not
+// sent along with every API request
+const refreshCookiePath = "/api/auth"
+
+// GenerateRefreshToken creates a new opaque refresh token.
+// Only its hash (see HashToken) is ever stored on the server.
+func GenerateRefreshToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashToken returns the hex-encoded SHA-256 of an opaque token (refresh tokens, MFA tokens).
+// These are high-entropy random values, so a fast hash is sufficient.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ExtractRefreshTokenFromRequest extracts the refresh token from its cookie.
+// Bearer clients send it in the request body instead.
+func ExtractRefreshTokenFromRequest(r *http.Request) (string, error) {
+	cookie, err := r.Cookie("refresh_token")
+	if err != nil || cookie.Value == "" {
+		return "", errors.New("refresh token missing")
+	}
+	return cookie.Value, nil
+}
+
+// SetRefreshCookie sets an HTTP-only secure cookie with the refresh token
+func SetRefreshCookie(w http.ResponseWriter, token string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    token,
+		Path:     refreshCookiePath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   os.Getenv("ENV") == "production",
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// ClearRefreshCookie removes the refresh token cookie
+func ClearRefreshCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    "",
+		Path:     refreshCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   os.Getenv("ENV") == "production",
+		SameSite: http.SameSiteStrictMode,
+	})
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoginChallengeTTL is how long a login challenge can be answered
+const LoginChallengeTTL = 2 * time.Minute
+
+// loginChallengeContext domain-separates login signatures from any other message
+// signed with the same key
+const loginChallengeContext = "pswd-login-v1"
+
+// decodeBase64 accepts both the URL-safe unpadded encoding used by libsodium's
+// to_base64 and standard padded base64
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if b, err := base64.RawURLEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
+
+// DecodePublicKey parses a base64-encoded Ed25519 public key
+func DecodePublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := decodeBase64(s)
+	if err != nil {
+		return nil, errors.New("invalid public key encoding")
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %d bytes", ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// VerifySignature checks a base64-encoded Ed25519 signature over message
+// against a base64-encoded public key
+func VerifySignature(publicKey string, message []byte, signature string) bool {
+	pub, err := DecodePublicKey(publicKey)
+	if err != nil {
+		return false
+	}
+	sig, err := decodeBase64(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(pub, message, sig)
+}
+
+// GenerateNonce returns 32 random bytes, base64url-encoded
+func GenerateNonce() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// LoginChallengeMessage is the exact byte string a device signs to answer a login challenge:
+//
+//	"pswd-login-v1\n" + challenge_id + "\n" + nonce
+func LoginChallengeMessage(challengeID, nonce string) []byte {
+	return []byte(loginChallengeContext + "\n" + challengeID + "\n" + nonce)
+}
+
+// Contexts for the master-device operations signed with pk_device_sign or the recovery key
+const (
+	masterTransferContext         = "pswd-master-transfer-v1"
+	masterTransferAcceptContext   = "pswd-master-transfer-accept-v1"
+	masterRecoveryContext         = "pswd-master-recovery-v1"
+	masterRecoveryCompleteContext = "pswd-master-recovery-complete-v1"
+)
+
+// MasterTransferMessage is what the current master device signs to hand the master
+// role to another device:
+//
+//	"pswd-master-transfer-v1\n" + user_id + "\n" + from_device_id + "\n" + to_device_id + "\n" + nonce
+func MasterTransferMessage(userID, fromDeviceID, toDeviceID, nonce string) []byte {
+	return []byte(strings.Join([]string{masterTransferContext, userID, fromDeviceID, toDeviceID, nonce}, "\n"))
+}
+
+// MasterTransferAcceptMessage is what the target device signs to accept a transfer:
+//
+//	"pswd-master-transfer-accept-v1\n" + transfer_id + "\n" + nonce
+func MasterTransferAcceptMessage(transferID, nonce string) []byte {
+	return []byte(strings.Join([]string{masterTransferAcceptContext, transferID, nonce}, "\n"))
+}
+
+// MasterRecoveryMessage is what the recovery key signs to start promoting a device
+// to master:
+//
+//	"pswd-master-recovery-v1\n" + username + "\n" + device_fingerprint + "\n" + nonce
+func MasterRecoveryMessage(username, deviceFingerprint, nonce string) []byte {
+	return []byte(strings.Join([]string{masterRecoveryContext, username, deviceFingerprint, nonce}, "\n"))
+}
+
+// MasterRecoveryCompleteMessage is what the recovery key signs to finish a recovery
+// once its waiting period is over:
+//
+//	"pswd-master-recovery-complete-v1\n" + recovery_id
+func MasterRecoveryCompleteMessage(recoveryID string) []byte {
+	return []byte(masterRecoveryCompleteContext + "\n" + recoveryID)
+}
+
+// vaultShareContext separates vault share signatures from the other signed messages
+const vaultShareContext = "pswd-vault-share-v1"
+
+// VaultShareMessage is what a vault owner signs with pk_sign when sealing a vault key
+// to another user's pk_encrypt, so the recipient can check who the key came from:
+//
+//	"pswd-vault-share-v1\n" + vault_id + "\n" + recipient_user_id + "\n" + key_version + "\n" + wrapped_key
+func VaultShareMessage(vaultID, recipientUserID string, keyVersion int, wrappedKey string) []byte {
+	return []byte(strings.Join([]string{vaultShareContext, vaultID, recipientUserID, strconv.Itoa(keyVersion), wrappedKey}, "\n"))
+}
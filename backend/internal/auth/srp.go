@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// SRP-6a (RFC 5054 2048-bit group, SHA-256) lets a client prove knowledge of its
+// password without the password, or anything equivalent to it, reaching the server.
+// The server only stores the salt s and the verifier v = g^x mod N, where the client
+// derives x from its password (e.g. x = H(s | Argon2id(password))).
+//
+// All big integers travel as lowercase hex. Inside hashes, A, B, S and g are
+// left-padded to the byte length of N:
+//
+//	k  = H(N | PAD(g))
+//	u  = H(PAD(A) | PAD(B))
+//	K  = H(PAD(S))
+//	M1 = H((H(N) xor H(PAD(g))) | H(username) | s | PAD(A) | PAD(B) | K)
+//	M2 = H(PAD(A) | M1 | K)
+
+// SRPHandshakeTTL is how long a client has to answer the server's SRP challenge
+const SRPHandshakeTTL = 2 * time.Minute
+
+// srpSaltLen is the only salt length accepted, so fake salts look like real ones
+const srpSaltLen = 16
+
+var (
+	srpN = mustHexInt("" +
+		"AC6BDB41324A9A9BF166DE5E1389582FAF72B6651987EE07FC3192943DB56050" +
+		"A37329CBB4A099ED8193E0757767A13DD52312AB4B03310DCD7F48A9DA04FD50" +
+		"E8083969EDB767B0CF6095179A163AB3661A05FBD5FAAAE82918A9962F0B93B8" +
+		"55F97993EC975EEAA80D740ADBF4FF747359D041D5C33EA71D281E446B14773B" +
+		"CA97B43A23FB801676BD207A436C6481F1D2B9078717461A5B9D32E688F87748" +
+		"544523B524B0D57D5EA77A2775D2ECFA032CFBDBF52FB3786160279004E57AE6" +
+		"AF874E7303CE53299CCC041C7BC308D82A5698F3A8D0C38271AE35F8E9DBFBB6" +
+		"94B5C803D89F7AE435DE236D525F54759B65E372FCD68EF20FA7111F9E4AFF73")
+	srpG   = big.NewInt(2)
+	srpLen = len(srpN.Bytes())
+	srpK   = new(big.Int).SetBytes(srpHash(srpN.Bytes(), srpPad(srpG)))
+)
+
+func mustHexInt(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("invalid SRP group constant")
+	}
+	return n
+}
+
+func srpHash(parts ...[]byte) []byte {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// srpPad left-pads n to the byte length of N
+func srpPad(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) >= srpLen {
+		return b
+	}
+	padded := make([]byte, srpLen)
+	copy(padded[srpLen-len(b):], b)
+	return padded
+}
+
+// parseSRPInt decodes a hex group element and rejects values that are zero mod N
+func parseSRPInt(s string) (*big.Int, error) {
+	n, ok := new(big.Int).SetString(strings.TrimSpace(s), 16)
+	if !ok || n.Sign() <= 0 {
+		return nil, errors.New("invalid SRP value")
+	}
+	if new(big.Int).Mod(n, srpN).Sign() == 0 {
+		return nil, errors.New("invalid SRP value")
+	}
+	return n, nil
+}
+
+// ValidateSRPVerifier checks that a salt and verifier submitted by a client are well formed
+func ValidateSRPVerifier(salt, verifier string) error {
+	s, err := hex.DecodeString(salt)
+	if err != nil || len(s) != srpSaltLen {
+		return fmt.Errorf("srp_salt must be %d hex-encoded bytes", srpSaltLen)
+	}
+	v, err := parseSRPInt(verifier)
+	if err != nil || v.Cmp(srpN) >= 0 {
+		return errors.New("srp_verifier must be a hex-encoded group element")
+	}
+	return nil
+}
+
+// ValidateSRPEphemeral checks a client public ephemeral A (A mod N must not be zero).
+// Check it before looking the user up, so known and unknown users fail alike.
+func ValidateSRPEphemeral(a string) error {
+	_, err := parseSRPInt(a)
+	return err
+}
+
+// SRPServer holds the server side of one SRP handshake
+type SRPServer struct {
+	Username string
+	Salt     string // hex
+	Verifier string // hex
+	A        string // client public ephemeral, hex
+	B        string // server public ephemeral, hex
+	b        *big.Int
+}
+
+// NewSRPServer starts a handshake for a client that sent its public ephemeral A
+func NewSRPServer(username, salt, verifier, a string) (*SRPServer, error) {
+	v, err := parseSRPInt(verifier)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parseSRPInt(a); err != nil {
+		return nil, err
+	}
+
+	for {
+		b, err := rand.Int(rand.Reader, srpN)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate SRP ephemeral: %w", err)
+		}
+		if b.BitLen() < 256 {
+			continue
+		}
+
+		// B = k*v + g^b mod N
+		B := new(big.Int).Mul(srpK, v)
+		B.Add(B, new(big.Int).Exp(srpG, b, srpN))
+		B.Mod(B, srpN)
+		if B.Sign() == 0 {
+			continue
+		}
+
+		return &SRPServer{
+			Username: username,
+			Salt:     salt,
+			Verifier: verifier,
+			A:        strings.ToLower(strings.TrimSpace(a)),
+			B:        B.Text(16),
+			b:        b,
+		}, nil
+	}
+}
+
+// SecretEphemeral returns b as hex so the handshake can be resumed on another request
+func (s *SRPServer) SecretEphemeral() string {
+	return s.b.Text(16)
+}
+
+// ResumeSRPServer restores a handshake persisted with SecretEphemeral
+func ResumeSRPServer(username, salt, verifier, a, B, secretEphemeral string) (*SRPServer, error) {
+	b, ok := new(big.Int).SetString(secretEphemeral, 16)
+	if !ok {
+		return nil, errors.New("invalid SRP state")
+	}
+	return &SRPServer{Username: username, Salt: salt, Verifier: verifier, A: a, B: B, b: b}, nil
+}
+
+// VerifyClientProof checks the client's proof M1 and, if it is valid,
+// returns the server proof M2 (hex) for the client to check in turn
+func (s *SRPServer) VerifyClientProof(m1 string) (string, error) {
+	A, err := parseSRPInt(s.A)
+	if err != nil {
+		return "", err
+	}
+	B, err := parseSRPInt(s.B)
+	if err != nil {
+		return "", err
+	}
+	v, err := parseSRPInt(s.Verifier)
+	if err != nil {
+		return "", err
+	}
+	salt, err := hex.DecodeString(s.Salt)
+	if err != nil {
+		return "", errors.New("invalid SRP salt")
+	}
+
+	u := new(big.Int).SetBytes(srpHash(srpPad(A), srpPad(B)))
+	if u.Sign() == 0 {
+		return "", errors.New("invalid SRP handshake")
+	}
+
+	// S = (A * v^u) ^ b mod N
+	S := new(big.Int).Exp(v, u, srpN)
+	S.Mul(S, A)
+	S.Mod(S, srpN)
+	S.Exp(S, s.b, srpN)
+	K := srpHash(srpPad(S))
+
+	hN := srpHash(srpN.Bytes())
+	hG := srpHash(srpPad(srpG))
+	for i := range hN {
+		hN[i] ^= hG[i]
+	}
+	expected := srpHash(hN, srpHash([]byte(s.Username)), salt, srpPad(A), srpPad(B), K)
+
+	clientProof, err := hex.DecodeString(strings.TrimSpace(m1))
+	if err != nil || !hmac.Equal(clientProof, expected) {
+		return "", errors.New("invalid SRP proof")
+	}
+
+	return hex.EncodeToString(srpHash(srpPad(A), expected, K)), nil
+}
+
+// FakeSRPSalt returns a stable salt for a username that has no SRP verifier,
+// so an SRP handshake doesn't reveal whether the account exists
+func FakeSRPSalt(username string) string {
+	h := decoyMAC("pswd-srp-fake-salt")
+	h.Write([]byte(username))
+	return hex.EncodeToString(h.Sum(nil)[:srpSaltLen])
+}
+
+// FakeSRPEphemeral returns a random value shaped like B, for handshakes that can never succeed
+func FakeSRPEphemeral() (string, error) {
+	b, err := rand.Int(rand.Reader, srpN)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate SRP ephemeral: %w", err)
+	}
+	return b.Text(16), nil
+}
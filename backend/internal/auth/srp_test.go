@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+// srpClient is the client side of SRP-6a, written from the formulas in srp.go, with
+// x = H(s | H(password)) standing in for the client's password hash
+type srpClient struct {
+	username string
+	password string
+	salt     string
+	a        *big.Int
+	A        *big.Int
+}
+
+func newSRPClient(t *testing.T, username, password, salt string) *srpClient {
+	t.Helper()
+	a, err := rand.Int(rand.Reader, srpN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &srpClient{
+		username: username,
+		password: password,
+		salt:     salt,
+		a:        a,
+		A:        new(big.Int).Exp(srpG, a, srpN),
+	}
+}
+
+func (c *srpClient) x(t *testing.T) *big.Int {
+	t.Helper()
+	salt, err := hex.DecodeString(c.salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return new(big.Int).SetBytes(srpHash(salt, srpHash([]byte(c.password))))
+}
+
+// verifier is what the client registers: v = g^x mod N
+func (c *srpClient) verifier(t *testing.T) string {
+	return new(big.Int).Exp(srpG, c.x(t), srpN).Text(16)
+}
+
+// proofs answers the server's B with M1, and returns the M2 the server should send back
+func (c *srpClient) proofs(t *testing.T, b string) (m1, m2 string) {
+	t.Helper()
+	B, ok := new(big.Int).SetString(b, 16)
+	if !ok {
+		t.Fatalf("invalid B %q", b)
+	}
+	salt, _ := hex.DecodeString(c.salt)
+	x := c.x(t)
+	u := new(big.Int).SetBytes(srpHash(srpPad(c.A), srpPad(B)))
+
+	// S = (B - k*g^x) ^ (a + u*x) mod N
+	base := new(big.Int).Mul(srpK, new(big.Int).Exp(srpG, x, srpN))
+	base.Sub(B, base)
+	base.Mod(base, srpN)
+	exp := new(big.Int).Mul(u, x)
+	exp.Add(exp, c.a)
+	S := new(big.Int).Exp(base, exp, srpN)
+	K := srpHash(srpPad(S))
+
+	hN := srpHash(srpN.Bytes())
+	hG := srpHash(srpPad(srpG))
+	for i := range hN {
+		hN[i] ^= hG[i]
+	}
+	proof := srpHash(hN, srpHash([]byte(c.username)), salt, srpPad(c.A), srpPad(B), K)
+	return hex.EncodeToString(proof), hex.EncodeToString(srpHash(srpPad(c.A), proof, K))
+}
+
+const testSRPSalt = "00112233445566778899aabbccddeeff"
+
+func TestSRPHandshake(t *testing.T) {
+	client := newSRPClient(t, "alice", "correct horse", testSRPSalt)
+	verifier := client.verifier(t)
+	if err := ValidateSRPVerifier(testSRPSalt, verifier); err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := NewSRPServer("alice", testSRPSalt, verifier, client.A.Text(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1, wantM2 := client.proofs(t, server.B)
+
+	m2, err := server.VerifyClientProof(m1)
+	if err != nil {
+		t.Fatalf("valid proof was rejected: %v", err)
+	}
+	if m2 != wantM2 {
+		t.Errorf("got M2 %s, want %s", m2, wantM2)
+	}
+
+	// The handshake survives being persisted between the init and verify requests
+	resumed, err := ResumeSRPServer("alice", testSRPSalt, verifier, server.A, server.B, server.SecretEphemeral())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m2, err := resumed.VerifyClientProof(m1); err != nil || m2 != wantM2 {
+		t.Errorf("resumed handshake: got %q, %v", m2, err)
+	}
+}
+
+func TestSRPHandshakeWrongPassword(t *testing.T) {
+	verifier := newSRPClient(t, "alice", "correct horse", testSRPSalt).verifier(t)
+
+	client := newSRPClient(t, "alice", "battery staple", testSRPSalt)
+	server, err := NewSRPServer("alice", testSRPSalt, verifier, client.A.Text(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1, _ := client.proofs(t, server.B)
+	if m2, err := server.VerifyClientProof(m1); err == nil {
+		t.Errorf("proof from the wrong password was accepted (M2 %s)", m2)
+	}
+}
+
+func TestSRPHandshakeTamperedProof(t *testing.T) {
+	client := newSRPClient(t, "alice", "correct horse", testSRPSalt)
+	server, err := NewSRPServer("alice", testSRPSalt, client.verifier(t), client.A.Text(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1, _ := client.proofs(t, server.B)
+
+	tampered := []byte(m1)
+	if tampered[0] == '0' {
+		tampered[0] = '1'
+	} else {
+		tampered[0] = '0'
+	}
+	for _, proof := range []string{string(tampered), m1[:len(m1)-2], "", "not hex"} {
+		if _, err := server.VerifyClientProof(proof); err == nil {
+			t.Errorf("proof %q was accepted", proof)
+		}
+	}
+
+	// Another user's name changes M1, so a proof doesn't carry over
+	other, err := ResumeSRPServer("bob", testSRPSalt, server.Verifier, server.A, server.B, server.SecretEphemeral())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.VerifyClientProof(m1); err == nil {
+		t.Error("proof was accepted for another username")
+	}
+}
+
+func TestSRPRejectsZeroEphemeral(t *testing.T) {
+	verifier := newSRPClient(t, "alice", "correct horse", testSRPSalt).verifier(t)
+
+	// A ≡ 0 mod N would make S = 0 whatever the password
+	for _, a := range []string{
+		"0",
+		srpN.Text(16),
+		new(big.Int).Lsh(srpN, 1).Text(16),
+		"-" + srpN.Text(16),
+		"",
+		"xyz",
+	} {
+		if err := ValidateSRPEphemeral(a); err == nil {
+			t.Errorf("A = %q passed validation", a)
+		}
+		if _, err := NewSRPServer("alice", testSRPSalt, verifier, a); err == nil {
+			t.Errorf("A = %q started a handshake", a)
+		}
+		server := &SRPServer{Username: "alice", Salt: testSRPSalt, Verifier: verifier, A: a, B: "2", b: big.NewInt(3)}
+		if _, err := server.VerifyClientProof("00"); err == nil {
+			t.Errorf("A = %q was accepted in a resumed handshake", a)
+		}
+	}
+}
+
+func TestValidateSRPVerifier(t *testing.T) {
+	verifier := newSRPClient(t, "alice", "correct horse", testSRPSalt).verifier(t)
+
+	tests := []struct {
+		salt     string
+		verifier string
+		ok       bool
+	}{
+		{testSRPSalt, verifier, true},
+		{testSRPSalt[:30], verifier, false},
+		{testSRPSalt + "00", verifier, false},
+		{"zz" + testSRPSalt[2:], verifier, false},
+		{testSRPSalt, "0", false},
+		{testSRPSalt, srpN.Text(16), false},
+		{testSRPSalt, new(big.Int).Add(srpN, big.NewInt(1)).Text(16), false},
+	}
+	for _, tt := range tests {
+		if err := ValidateSRPVerifier(tt.salt, tt.verifier); (err == nil) != tt.ok {
+			t.Errorf("salt %q, verifier %.16q: got %v, want ok = %v", tt.salt, tt.verifier, err, tt.ok)
+		}
+	}
+}
+
+func TestFakeSRPSalt(t *testing.T) {
+	salt := FakeSRPSalt("nobody")
+	if salt != FakeSRPSalt("nobody") {
+		t.Error("fake salt changed between calls")
+	}
+	if salt == FakeSRPSalt("somebody") {
+		t.Error("different usernames got the same fake salt")
+	}
+
+	// A fake salt must be indistinguishable in shape from one a client registered
+	if len(salt) != len(testSRPSalt) {
+		t.Errorf("fake salt %q has length %d, real salts have %d", salt, len(salt), len(testSRPSalt))
+	}
+	verifier := newSRPClient(t, "nobody", "x", salt).verifier(t)
+	if err := ValidateSRPVerifier(salt, verifier); err != nil {
+		t.Errorf("fake salt would not be accepted from a client: %v", err)
+	}
+}
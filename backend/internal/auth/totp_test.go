@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 seed of the RFC 6238 test vectors ("12345678901234567890")
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+// RFC 6238 appendix B, SHA-1. The RFC lists 8-digit codes; ours are their last 6 digits.
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	for _, v := range rfc6238Vectors {
+		if got := totpCode(key, v.unix/totpPeriod); got != v.code {
+			t.Errorf("at %d: got %s, want %s", v.unix, got, v.code)
+		}
+
+		step, ok := ValidateTOTP(rfc6238Secret, v.code, time.Unix(v.unix, 0), 0)
+		if !ok || step != v.unix/totpPeriod {
+			t.Errorf("at %d: code %s was rejected (step %d)", v.unix, v.code, step)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	key := []byte("12345678901234567890")
+	at := time.Unix(1234567890, 0)
+	current := at.Unix() / totpPeriod
+
+	tests := []struct {
+		offset int64
+		ok     bool
+	}{
+		{-2, false},
+		{-1, true},
+		{0, true},
+		{1, true},
+		{2, false},
+	}
+	for _, tt := range tests {
+		step, ok := ValidateTOTP(rfc6238Secret, totpCode(key, current+tt.offset), at, 0)
+		if ok != tt.ok {
+			t.Errorf("step %+d: got %v, want %v", tt.offset, ok, tt.ok)
+		}
+		if ok && step != current+tt.offset {
+			t.Errorf("step %+d: matched step %d", tt.offset, step)
+		}
+	}
+}
+
+func TestValidateTOTPRejectsReplay(t *testing.T) {
+	key := []byte("12345678901234567890")
+	at := time.Unix(1234567890, 0)
+	current := at.Unix() / totpPeriod
+	code := totpCode(key, current)
+
+	step, ok := ValidateTOTP(rfc6238Secret, code, at, 0)
+	if !ok {
+		t.Fatal("code was rejected")
+	}
+
+	// The same code again, even a few seconds later in the same step
+	if _, ok := ValidateTOTP(rfc6238Secret, code, at.Add(5*time.Second), step); ok {
+		t.Error("code was accepted twice")
+	}
+
+	// A code from before the last accepted step is stale, even within the window
+	if _, ok := ValidateTOTP(rfc6238Secret, totpCode(key, current-1), at, step); ok {
+		t.Error("code older than the last accepted one was accepted")
+	}
+
+	// The next code is fine
+	if next, ok := ValidateTOTP(rfc6238Secret, totpCode(key, current+1), at.Add(totpPeriod*time.Second), step); !ok || next != current+1 {
+		t.Error("next code was rejected")
+	}
+}
+
+func TestValidateTOTPInput(t *testing.T) {
+	at := time.Unix(59, 0)
+
+	if _, ok := ValidateTOTP(rfc6238Secret, " 287 082 ", at, 0); !ok {
+		t.Error("code with spaces was rejected")
+	}
+	if _, ok := ValidateTOTP(strings.ToLower(rfc6238Secret), "287082", at, 0); !ok {
+		t.Error("lowercase secret was rejected")
+	}
+
+	for _, code := range []string{"", "28708", "2870820", "abcdef", "94287082"} {
+		if _, ok := ValidateTOTP(rfc6238Secret, code, at, 0); ok {
+			t.Errorf("code %q was accepted", code)
+		}
+	}
+	if _, ok := ValidateTOTP("not base32!", "287082", at, 0); ok {
+		t.Error("invalid secret was accepted")
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Errorf("secret %q should decode to 20 bytes (%v)", secret, err)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("got %d codes, want %d", len(codes), RecoveryCodeCount)
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("code %q is not formatted as xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q was issued twice", code)
+		}
+		seen[code] = true
+	}
+
+	// However it is typed, a code hashes to the same value, so it can only be used once
+	hash := HashRecoveryCode(codes[0])
+	for _, typed := range []string{strings.ToUpper(codes[0]), strings.ReplaceAll(codes[0], "-", ""), " " + codes[0][:5] + " " + codes[0][6:] + " "} {
+		if HashRecoveryCode(typed) != hash {
+			t.Errorf("%q hashes differently from %q", typed, codes[0])
+		}
+	}
+	if HashRecoveryCode(codes[1]) == hash {
+		t.Error("different codes have the same hash")
+	}
+}
@@ -0,0 +1,398 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// WebAuthn (passkeys and security keys). The server verifies registration and
+// assertion responses itself: client data, authenticator data, COSE public keys
+// and "none" or "packed" attestation. Attestation certificates are not checked
+// against any vendor roots; we only need to know the key is bound to this RP.
+//
+// The PRF extension is evaluated entirely on the client. The server only hands out
+// a per-credential salt and stores the vault key the client wrapped with the PRF
+// output, so it never sees anything that could unwrap it.
+
+// WebAuthnChallengeTTL is how long a WebAuthn ceremony can take
+const WebAuthnChallengeTTL = 5 * time.Minute
+
+// COSE algorithm identifiers we accept (RFC 9053)
+const (
+	COSEAlgES256 = -7
+	COSEAlgEdDSA = -8
+)
+
+// Authenticator data flags
+const (
+	authDataUserPresent  = 0x01
+	authDataUserVerified = 0x04
+	authDataAttested     = 0x40
+	authDataExtensions   = 0x80
+)
+
+var (
+	webAuthnRPID    = "localhost"
+	webAuthnRPName  = "pswd"
+	webAuthnOrigins = []string{"http://localhost:5173", "http://localhost:3000"}
+)
+
+// loadWebAuthnConfig reads the relying party from WEBAUTHN_RP_ID, WEBAUTHN_RP_NAME
+// and the comma-separated WEBAUTHN_ORIGINS
+func loadWebAuthnConfig() error {
+	if rpID := os.Getenv("WEBAUTHN_RP_ID"); rpID != "" {
+		webAuthnRPID = rpID
+	}
+	if rpName := os.Getenv("WEBAUTHN_RP_NAME"); rpName != "" {
+		webAuthnRPName = rpName
+	}
+	if origins := os.Getenv("WEBAUTHN_ORIGINS"); origins != "" {
+		webAuthnOrigins = nil
+		for _, origin := range strings.Split(origins, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				webAuthnOrigins = append(webAuthnOrigins, origin)
+			}
+		}
+		if len(webAuthnOrigins) == 0 {
+			return errors.New("WEBAUTHN_ORIGINS must list at least one origin")
+		}
+	}
+	return nil
+}
+
+// WebAuthnRP returns the relying party ID and display name sent to authenticators
+func WebAuthnRP() (id, name string) {
+	return webAuthnRPID, webAuthnRPName
+}
+
+// WebAuthnCredential is a credential accepted by VerifyWebAuthnRegistration
+type WebAuthnCredential struct {
+	ID           []byte
+	PublicKey    []byte // COSE_Key, as sent by the authenticator
+	Alg          int
+	SignCount    uint32
+	AAGUID       []byte
+	UserVerified bool
+}
+
+// EncodeWebAuthnID encodes credential IDs, challenges and other binary WebAuthn values
+// as base64url without padding, the encoding browsers use in PublicKeyCredential JSON
+func EncodeWebAuthnID(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeWebAuthnID is the inverse of EncodeWebAuthnID; padded base64 is accepted too
+func DecodeWebAuthnID(s string) ([]byte, error) {
+	return decodeBase64(s)
+}
+
+// VerifyWebAuthnRegistration checks the response to navigator.credentials.create()
+// against the challenge the server issued and returns the new credential
+func VerifyWebAuthnRegistration(challenge string, clientDataJSON, attestationObject []byte, requireUV bool) (*WebAuthnCredential, error) {
+	if err := verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	decoded, _, err := cborDecode(attestationObject)
+	if err != nil {
+		return nil, errors.New("invalid attestation object")
+	}
+	attestation, err := cborMap(decoded, "attestation object")
+	if err != nil {
+		return nil, err
+	}
+	format, _ := attestation["fmt"].(string)
+	rawAuthData, _ := attestation["authData"].([]byte)
+	attStmt, err := cborMap(attestation["attStmt"], "attStmt")
+	if err != nil {
+		return nil, err
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := authData.check(requireUV); err != nil {
+		return nil, err
+	}
+	if authData.credentialID == nil {
+		return nil, errors.New("authenticator data has no attested credential")
+	}
+
+	publicKey, alg, err := parseCOSEKey(authData.credentialKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := slices.Concat(rawAuthData, clientDataHash[:])
+
+	switch format {
+	case "none":
+		if len(attStmt) != 0 {
+			return nil, errors.New("none attestation must have an empty statement")
+		}
+	case "packed":
+		if err := verifyPackedAttestation(attStmt, signed, publicKey, alg); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported attestation format %q", format)
+	}
+
+	return &WebAuthnCredential{
+		ID:           authData.credentialID,
+		PublicKey:    authData.credentialKey,
+		Alg:          alg,
+		SignCount:    authData.signCount,
+		AAGUID:       authData.aaguid,
+		UserVerified: authData.flags&authDataUserVerified != 0,
+	}, nil
+}
+
+// VerifyWebAuthnAssertion checks the response to navigator.credentials.get() against
+// a stored credential and returns the authenticator's new signature counter
+func VerifyWebAuthnAssertion(challenge string, publicKey []byte, storedSignCount uint32, clientDataJSON, authenticatorData, signature []byte, requireUV bool) (uint32, error) {
+	if err := verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	authData, err := parseAuthenticatorData(authenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	if err := authData.check(requireUV); err != nil {
+		return 0, err
+	}
+
+	key, alg, err := parseCOSEKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	if !verifyCOSESignature(key, alg, slices.Concat(authenticatorData, clientDataHash[:]), signature) {
+		return 0, errors.New("invalid assertion signature")
+	}
+
+	// Authenticators that keep a counter must increase it; a counter that goes
+	// backwards means the credential was probably cloned
+	if (authData.signCount != 0 || storedSignCount != 0) && authData.signCount <= storedSignCount {
+		return 0, errors.New("authenticator signature counter did not increase")
+	}
+
+	return authData.signCount, nil
+}
+
+type collectedClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+func verifyClientData(raw []byte, ceremony, challenge string) error {
+	var clientData collectedClientData
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return errors.New("invalid client data")
+	}
+	if clientData.Type != ceremony {
+		return fmt.Errorf("client data type must be %q", ceremony)
+	}
+	if clientData.Challenge == "" || !hmac.Equal([]byte(clientData.Challenge), []byte(challenge)) {
+		return errors.New("client data challenge mismatch")
+	}
+	if clientData.CrossOrigin || !slices.Contains(webAuthnOrigins, clientData.Origin) {
+		return fmt.Errorf("origin %q is not allowed", clientData.Origin)
+	}
+	return nil
+}
+
+type authenticatorData struct {
+	rpIDHash      []byte
+	flags         byte
+	signCount     uint32
+	aaguid        []byte
+	credentialID  []byte
+	credentialKey []byte
+}
+
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("authenticator data too short")
+	}
+	ad := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+
+	if ad.flags&authDataAttested != 0 {
+		if len(rest) < 18 {
+			return nil, errors.New("attested credential data too short")
+		}
+		ad.aaguid = rest[:16]
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLen == 0 || idLen > 1023 || len(rest) < idLen {
+			return nil, errors.New("invalid credential id length")
+		}
+		ad.credentialID = rest[:idLen]
+		rest = rest[idLen:]
+
+		_, n, err := cborDecode(rest)
+		if err != nil {
+			return nil, errors.New("invalid credential public key")
+		}
+		ad.credentialKey = rest[:n]
+		rest = rest[n:]
+	}
+
+	if ad.flags&authDataExtensions != 0 {
+		_, n, err := cborDecode(rest)
+		if err != nil {
+			return nil, errors.New("invalid authenticator extensions")
+		}
+		rest = rest[n:]
+	}
+
+	if len(rest) != 0 {
+		return nil, errors.New("trailing bytes in authenticator data")
+	}
+	return ad, nil
+}
+
+// check verifies the RP ID hash and the user presence/verification flags
+func (ad *authenticatorData) check(requireUV bool) error {
+	rpIDHash := sha256.Sum256([]byte(webAuthnRPID))
+	if !hmac.Equal(ad.rpIDHash, rpIDHash[:]) {
+		return errors.New("rp id mismatch")
+	}
+	if ad.flags&authDataUserPresent == 0 {
+		return errors.New("user presence required")
+	}
+	if requireUV && ad.flags&authDataUserVerified == 0 {
+		return errors.New("user verification required")
+	}
+	return nil
+}
+
+// parseCOSEKey decodes an ES256 (P-256) or EdDSA (Ed25519) COSE_Key
+func parseCOSEKey(data []byte) (any, int, error) {
+	decoded, n, err := cborDecode(data)
+	if err != nil || n != len(data) {
+		return nil, 0, errors.New("invalid credential public key")
+	}
+	key, err := cborMap(decoded, "credential public key")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	kty, _ := key[int64(1)].(int64)
+	alg, _ := key[int64(3)].(int64)
+	crv, _ := key[int64(-1)].(int64)
+	x, _ := key[int64(-2)].([]byte)
+
+	switch {
+	case alg == COSEAlgES256 && kty == 2 && crv == 1:
+		y, _ := key[int64(-3)].([]byte)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, 0, errors.New("invalid P-256 public key")
+		}
+		pub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), slices.Concat([]byte{4}, x, y))
+		if err != nil {
+			return nil, 0, errors.New("invalid P-256 public key")
+		}
+		return pub, COSEAlgES256, nil
+	case alg == COSEAlgEdDSA && kty == 1 && crv == 6:
+		if len(x) != ed25519.PublicKeySize {
+			return nil, 0, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), COSEAlgEdDSA, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported credential key (kty %d, alg %d)", kty, alg)
+	}
+}
+
+func verifyCOSESignature(key any, alg int, message, signature []byte) bool {
+	switch alg {
+	case COSEAlgES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return false
+		}
+		digest := sha256.Sum256(message)
+		return ecdsa.VerifyASN1(pub, digest[:], signature)
+	case COSEAlgEdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(pub, message, signature)
+	}
+	return false
+}
+
+// verifyPackedAttestation checks a "packed" attestation statement: either a self
+// attestation signed by the credential key, or one signed by the certificate in x5c
+func verifyPackedAttestation(attStmt map[any]any, signed []byte, credentialKey any, credentialAlg int) error {
+	alg, _ := attStmt["alg"].(int64)
+	sig, _ := attStmt["sig"].([]byte)
+	if sig == nil {
+		return errors.New("packed attestation has no signature")
+	}
+
+	x5c, hasCerts := attStmt["x5c"].([]any)
+	if !hasCerts {
+		if int(alg) != credentialAlg {
+			return errors.New("self attestation algorithm mismatch")
+		}
+		if !verifyCOSESignature(credentialKey, credentialAlg, signed, sig) {
+			return errors.New("invalid self attestation signature")
+		}
+		return nil
+	}
+
+	if len(x5c) == 0 {
+		return errors.New("packed attestation has an empty certificate chain")
+	}
+	der, _ := x5c[0].([]byte)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return errors.New("invalid attestation certificate")
+	}
+	if cert.IsCA {
+		return errors.New("attestation certificate must not be a CA")
+	}
+	if !verifyCOSESignature(cert.PublicKey, int(alg), signed, sig) {
+		return errors.New("invalid attestation signature")
+	}
+	return nil
+}
+
+// FakeWebAuthnCredential returns a stable credential ID and PRF salt for a username
+// and device that have no credentials, so login options don't reveal which accounts exist
+func FakeWebAuthnCredential(username, deviceFingerprint string) (id, prfSalt string) {
+	h := decoyMAC("pswd-webauthn-fake-credential")
+	h.Write([]byte(username))
+	h.Write([]byte{0})
+	h.Write([]byte(deviceFingerprint))
+	sum := h.Sum(nil)
+
+	salt := decoyMAC("pswd-webauthn-fake-prf-salt")
+	salt.Write(sum)
+
+	return EncodeWebAuthnID(bytes.Repeat(sum, 2)), EncodeWebAuthnID(salt.Sum(nil))
+}
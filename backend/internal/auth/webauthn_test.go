@@ -0,0 +1,576 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A software authenticator: just enough CTAP2 to build the attestation objects and
+// assertions a browser would hand the server.
+
+// cborPair is a map entry; cborPairs keeps entries in order so encodings are stable
+type cborPair struct {
+	key, value any
+}
+
+type cborPairs []cborPair
+
+// cborEncode encodes ints, byte and text strings, arrays and cborPairs maps
+func cborEncode(v any) []byte {
+	head := func(major byte, arg uint64) []byte {
+		switch {
+		case arg < 24:
+			return []byte{major<<5 | byte(arg)}
+		case arg <= 0xff:
+			return []byte{major<<5 | 24, byte(arg)}
+		case arg <= 0xffff:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+		default:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+		}
+	}
+
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case []any:
+		out := head(4, uint64(len(v)))
+		for _, item := range v {
+			out = append(out, cborEncode(item)...)
+		}
+		return out
+	case cborPairs:
+		out := head(5, uint64(len(v)))
+		for _, pair := range v {
+			out = append(out, cborEncode(pair.key)...)
+			out = append(out, cborEncode(pair.value)...)
+		}
+		return out
+	}
+	panic("cborEncode: unsupported type")
+}
+
+type softAuthenticator struct {
+	alg          int
+	ecKey        *ecdsa.PrivateKey
+	edKey        ed25519.PrivateKey
+	credentialID []byte
+}
+
+func newSoftAuthenticator(t *testing.T, alg int) *softAuthenticator {
+	t.Helper()
+	a := &softAuthenticator{alg: alg, credentialID: make([]byte, 32)}
+	rand.Read(a.credentialID)
+
+	var err error
+	switch alg {
+	case COSEAlgES256:
+		a.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case COSEAlgEdDSA:
+		_, a.edKey, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// coseKey returns the credential public key as a COSE_Key
+func (a *softAuthenticator) coseKey() []byte {
+	if a.alg == COSEAlgEdDSA {
+		return cborEncode(cborPairs{
+			{1, 1}, {3, COSEAlgEdDSA}, {-1, 6}, {-2, []byte(a.edKey.Public().(ed25519.PublicKey))},
+		})
+	}
+	point, _ := a.ecKey.PublicKey.ECDH()
+	raw := point.Bytes() // 0x04 | x | y
+	return cborEncode(cborPairs{
+		{1, 2}, {3, COSEAlgES256}, {-1, 1}, {-2, raw[1:33]}, {-3, raw[33:]},
+	})
+}
+
+func (a *softAuthenticator) sign(t *testing.T, message []byte) []byte {
+	t.Helper()
+	if a.alg == COSEAlgEdDSA {
+		return ed25519.Sign(a.edKey, message)
+	}
+	digest := sha256.Sum256(message)
+	sig, err := ecdsa.SignASN1(rand.Reader, a.ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+// authData builds authenticator data, with attested credential data when attested is set
+func (a *softAuthenticator) authData(rpID string, flags byte, signCount uint32, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, signCount)
+	if attested {
+		data = append(data, make([]byte, 16)...) // AAGUID
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, a.coseKey()...)
+	}
+	return data
+}
+
+func clientDataJSON(t *testing.T, ceremony, challenge, origin string) []byte {
+	t.Helper()
+	raw, err := json.Marshal(collectedClientData{Type: ceremony, Challenge: challenge, Origin: origin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// attestationCert returns a leaf certificate and its P-256 key for "packed" x5c attestation
+func attestationCert(t *testing.T) ([]byte, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Soft Authenticator Attestation"},
+		NotBefore:             testTime.Add(-time.Hour),
+		NotAfter:              testTime.Add(time.Hour),
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der, key
+}
+
+// attestationObject wraps authData in a "none", "packed" (self) or "packed-x5c" statement
+func (a *softAuthenticator) attestationObject(t *testing.T, format string, authData, clientData []byte) []byte {
+	t.Helper()
+	clientDataHash := sha256.Sum256(clientData)
+	signed := slices.Concat(authData, clientDataHash[:])
+
+	var attStmt cborPairs
+	switch format {
+	case "packed":
+		attStmt = cborPairs{{"alg", a.alg}, {"sig", a.sign(t, signed)}}
+	case "packed-x5c":
+		der, key := attestationCert(t)
+		digest := sha256.Sum256(signed)
+		sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		format = "packed"
+		attStmt = cborPairs{{"alg", COSEAlgES256}, {"sig", sig}, {"x5c", []any{der}}}
+	}
+
+	return cborEncode(cborPairs{{"fmt", format}, {"attStmt", attStmt}, {"authData", authData}})
+}
+
+const (
+	testChallenge = "c2VydmVyLWlzc3VlZC1jaGFsbGVuZ2U"
+	testOrigin    = "http://localhost:5173"
+)
+
+func TestVerifyWebAuthnRegistration(t *testing.T) {
+	for _, alg := range []int{COSEAlgES256, COSEAlgEdDSA} {
+		for _, format := range []string{"none", "packed", "packed-x5c"} {
+			t.Run(format+"/"+algName(alg), func(t *testing.T) {
+				a := newSoftAuthenticator(t, alg)
+				clientData := clientDataJSON(t, "webauthn.create", testChallenge, testOrigin)
+				authData := a.authData(webAuthnRPID, authDataUserPresent|authDataUserVerified|authDataAttested, 0, true)
+
+				credential, err := VerifyWebAuthnRegistration(testChallenge, clientData, a.attestationObject(t, format, authData, clientData), true)
+				if err != nil {
+					t.Fatalf("registration was rejected: %v", err)
+				}
+				if !bytes.Equal(credential.ID, a.credentialID) || credential.Alg != alg || !credential.UserVerified {
+					t.Errorf("unexpected credential %+v", credential)
+				}
+				if !bytes.Equal(credential.PublicKey, a.coseKey()) {
+					t.Error("stored public key differs from the COSE key")
+				}
+			})
+		}
+	}
+}
+
+func algName(alg int) string {
+	if alg == COSEAlgEdDSA {
+		return "EdDSA"
+	}
+	return "ES256"
+}
+
+func TestVerifyWebAuthnRegistrationRejects(t *testing.T) {
+	a := newSoftAuthenticator(t, COSEAlgES256)
+	flags := byte(authDataUserPresent | authDataUserVerified | authDataAttested)
+	goodClientData := clientDataJSON(t, "webauthn.create", testChallenge, testOrigin)
+
+	tests := []struct {
+		name       string
+		clientData []byte
+		object     func(clientData []byte) []byte
+		requireUV  bool
+		want       string
+	}{
+		{
+			name: "bad rp id hash",
+			object: func(cd []byte) []byte {
+				return a.attestationObject(t, "none", a.authData("evil.example", flags, 0, true), cd)
+			},
+			want: "rp id mismatch",
+		},
+		{
+			name: "no user presence",
+			object: func(cd []byte) []byte {
+				return a.attestationObject(t, "none", a.authData(webAuthnRPID, flags&^authDataUserPresent, 0, true), cd)
+			},
+			want: "user presence required",
+		},
+		{
+			name: "no user verification",
+			object: func(cd []byte) []byte {
+				return a.attestationObject(t, "none", a.authData(webAuthnRPID, flags&^authDataUserVerified, 0, true), cd)
+			},
+			requireUV: true,
+			want:      "user verification required",
+		},
+		{
+			name:       "wrong challenge",
+			clientData: clientDataJSON(t, "webauthn.create", "another-challenge", testOrigin),
+			want:       "client data challenge mismatch",
+		},
+		{
+			name:       "wrong origin",
+			clientData: clientDataJSON(t, "webauthn.create", testChallenge, "https://evil.example"),
+			want:       `origin "https://evil.example" is not allowed`,
+		},
+		{
+			name:       "assertion client data",
+			clientData: clientDataJSON(t, "webauthn.get", testChallenge, testOrigin),
+			want:       `client data type must be "webauthn.create"`,
+		},
+		{
+			name:       "cross-origin",
+			clientData: []byte(`{"type":"webauthn.create","challenge":"` + testChallenge + `","origin":"` + testOrigin + `","crossOrigin":true}`),
+			want:       `origin "` + testOrigin + `" is not allowed`,
+		},
+		{
+			name:       "client data not json",
+			clientData: []byte("{"),
+			want:       "invalid client data",
+		},
+		{
+			name: "no attested credential",
+			object: func(cd []byte) []byte {
+				return a.attestationObject(t, "none", a.authData(webAuthnRPID, flags&^authDataAttested, 0, false), cd)
+			},
+			want: "authenticator data has no attested credential",
+		},
+		{
+			name: "attested flag without credential data",
+			object: func(cd []byte) []byte {
+				return a.attestationObject(t, "none", a.authData(webAuthnRPID, flags, 0, false), cd)
+			},
+			want: "attested credential data too short",
+		},
+		{
+			name: "trailing bytes in authenticator data",
+			object: func(cd []byte) []byte {
+				return a.attestationObject(t, "none", append(a.authData(webAuthnRPID, flags, 0, true), 0), cd)
+			},
+			want: "trailing bytes in authenticator data",
+		},
+		{
+			name: "none with a statement",
+			object: func(cd []byte) []byte {
+				authData := a.authData(webAuthnRPID, flags, 0, true)
+				return cborEncode(cborPairs{{"fmt", "none"}, {"attStmt", cborPairs{{"alg", -7}}}, {"authData", authData}})
+			},
+			want: "none attestation must have an empty statement",
+		},
+		{
+			name: "packed signature over other data",
+			object: func(cd []byte) []byte {
+				authData := a.authData(webAuthnRPID, flags, 0, true)
+				return cborEncode(cborPairs{{"fmt", "packed"}, {"attStmt", cborPairs{{"alg", COSEAlgES256}, {"sig", a.sign(t, authData)}}}, {"authData", authData}})
+			},
+			want: "invalid self attestation signature",
+		},
+		{
+			name: "packed algorithm mismatch",
+			object: func(cd []byte) []byte {
+				authData := a.authData(webAuthnRPID, flags, 0, true)
+				return cborEncode(cborPairs{{"fmt", "packed"}, {"attStmt", cborPairs{{"alg", COSEAlgEdDSA}, {"sig", []byte{1}}}}, {"authData", authData}})
+			},
+			want: "self attestation algorithm mismatch",
+		},
+		{
+			name: "unsupported format",
+			object: func(cd []byte) []byte {
+				return cborEncode(cborPairs{{"fmt", "tpm"}, {"attStmt", cborPairs{}}, {"authData", a.authData(webAuthnRPID, flags, 0, true)}})
+			},
+			want: `unsupported attestation format "tpm"`,
+		},
+		{
+			name: "truncated attestation object",
+			object: func(cd []byte) []byte {
+				object := a.attestationObject(t, "none", a.authData(webAuthnRPID, flags, 0, true), cd)
+				return object[:len(object)-10]
+			},
+			want: "invalid attestation object",
+		},
+		{
+			name:   "attestation object not a map",
+			object: func(cd []byte) []byte { return cborEncode([]any{"fmt"}) },
+			want:   "attestation object must be a cbor map",
+		},
+		{
+			name: "indefinite-length attestation object",
+			object: func(cd []byte) []byte {
+				return []byte{0xbf, 0x63, 'f', 'm', 't', 0x64, 'n', 'o', 'n', 'e', 0xff}
+			},
+			want: "invalid attestation object",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientData := tt.clientData
+			if clientData == nil {
+				clientData = goodClientData
+			}
+			object := a.attestationObject(t, "packed", a.authData(webAuthnRPID, flags, 0, true), clientData)
+			if tt.object != nil {
+				object = tt.object(clientData)
+			}
+
+			_, err := VerifyWebAuthnRegistration(testChallenge, clientData, object, tt.requireUV)
+			if err == nil {
+				t.Fatal("registration was accepted")
+			}
+			if err.Error() != tt.want {
+				t.Errorf("got error %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyWebAuthnRegistrationRejectsBadCredentialKey(t *testing.T) {
+	a := newSoftAuthenticator(t, COSEAlgES256)
+	clientData := clientDataJSON(t, "webauthn.create", testChallenge, testOrigin)
+
+	// Attested credential data whose COSE key is an RSA key (kty 3), which we don't accept
+	rpIDHash := sha256.Sum256([]byte(webAuthnRPID))
+	authData := append(rpIDHash[:], authDataUserPresent|authDataAttested, 0, 0, 0, 0)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credentialID)))
+	authData = append(authData, a.credentialID...)
+	authData = append(authData, cborEncode(cborPairs{{1, 3}, {3, -257}, {-1, []byte{1}}, {-2, []byte{1, 0, 1}}})...)
+
+	_, err := VerifyWebAuthnRegistration(testChallenge, clientData, a.attestationObject(t, "none", authData, clientData), false)
+	if err == nil || !strings.HasPrefix(err.Error(), "unsupported credential key") {
+		t.Errorf("got %v, want an unsupported credential key error", err)
+	}
+}
+
+func TestVerifyWebAuthnAssertion(t *testing.T) {
+	for _, alg := range []int{COSEAlgES256, COSEAlgEdDSA} {
+		t.Run(algName(alg), func(t *testing.T) {
+			a := newSoftAuthenticator(t, alg)
+			clientData := clientDataJSON(t, "webauthn.get", testChallenge, testOrigin)
+			authData := a.authData(webAuthnRPID, authDataUserPresent|authDataUserVerified, 8, false)
+			clientDataHash := sha256.Sum256(clientData)
+			sig := a.sign(t, slices.Concat(authData, clientDataHash[:]))
+
+			count, err := VerifyWebAuthnAssertion(testChallenge, a.coseKey(), 7, clientData, authData, sig, true)
+			if err != nil {
+				t.Fatalf("assertion was rejected: %v", err)
+			}
+			if count != 8 {
+				t.Errorf("got sign count %d, want 8", count)
+			}
+		})
+	}
+}
+
+func TestVerifyWebAuthnAssertionRejects(t *testing.T) {
+	a := newSoftAuthenticator(t, COSEAlgES256)
+	flags := byte(authDataUserPresent | authDataUserVerified)
+
+	// assertion signs authData and clientData the way the authenticator would
+	assertion := func(authData, clientData []byte) []byte {
+		clientDataHash := sha256.Sum256(clientData)
+		return a.sign(t, slices.Concat(authData, clientDataHash[:]))
+	}
+
+	tests := []struct {
+		name        string
+		clientData  []byte
+		authData    []byte
+		storedCount uint32
+		badSig      bool
+		requireUV   bool
+		want        string
+	}{
+		{name: "counter went backwards", authData: a.authData(webAuthnRPID, flags, 3, false), storedCount: 5, want: "authenticator signature counter did not increase"},
+		{name: "counter did not move", authData: a.authData(webAuthnRPID, flags, 5, false), storedCount: 5, want: "authenticator signature counter did not increase"},
+		{name: "counter dropped to zero", authData: a.authData(webAuthnRPID, flags, 0, false), storedCount: 5, want: "authenticator signature counter did not increase"},
+		{name: "bad rp id hash", authData: a.authData("evil.example", flags, 9, false), want: "rp id mismatch"},
+		{name: "no user presence", authData: a.authData(webAuthnRPID, authDataUserVerified, 9, false), want: "user presence required"},
+		{name: "no user verification", authData: a.authData(webAuthnRPID, authDataUserPresent, 9, false), requireUV: true, want: "user verification required"},
+		{name: "wrong challenge", clientData: clientDataJSON(t, "webauthn.get", "stale-challenge", testOrigin), want: "client data challenge mismatch"},
+		{name: "wrong origin", clientData: clientDataJSON(t, "webauthn.get", testChallenge, "https://evil.example"), want: `origin "https://evil.example" is not allowed`},
+		{name: "registration client data", clientData: clientDataJSON(t, "webauthn.create", testChallenge, testOrigin), want: `client data type must be "webauthn.get"`},
+		{name: "bad signature", badSig: true, want: "invalid assertion signature"},
+		{name: "truncated authenticator data", authData: a.authData(webAuthnRPID, flags, 9, false)[:36], want: "authenticator data too short"},
+		{name: "extensions flag without extensions", authData: a.authData(webAuthnRPID, flags|authDataExtensions, 9, false), want: "invalid authenticator extensions"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientData := tt.clientData
+			if clientData == nil {
+				clientData = clientDataJSON(t, "webauthn.get", testChallenge, testOrigin)
+			}
+			authData := tt.authData
+			if authData == nil {
+				authData = a.authData(webAuthnRPID, flags, 9, false)
+			}
+			sig := assertion(authData, clientData)
+			if tt.badSig {
+				sig = assertion(authData, []byte("other client data"))
+			}
+
+			_, err := VerifyWebAuthnAssertion(testChallenge, a.coseKey(), tt.storedCount, clientData, authData, sig, tt.requireUV)
+			if err == nil {
+				t.Fatal("assertion was accepted")
+			}
+			if err.Error() != tt.want {
+				t.Errorf("got error %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyWebAuthnAssertionWithoutCounter(t *testing.T) {
+	// Authenticators without a counter always report zero, which is allowed
+	a := newSoftAuthenticator(t, COSEAlgEdDSA)
+	clientData := clientDataJSON(t, "webauthn.get", testChallenge, testOrigin)
+	authData := a.authData(webAuthnRPID, authDataUserPresent, 0, false)
+	clientDataHash := sha256.Sum256(clientData)
+	sig := a.sign(t, slices.Concat(authData, clientDataHash[:]))
+
+	if _, err := VerifyWebAuthnAssertion(testChallenge, a.coseKey(), 0, clientData, authData, sig, false); err != nil {
+		t.Errorf("assertion was rejected: %v", err)
+	}
+}
+
+func TestCBORDecode(t *testing.T) {
+	data := cborEncode(cborPairs{{1, 2}, {-7, "text"}, {"bytes", []byte{1, 2}}, {"list", []any{0, -1, 500, 70000}}})
+	v, n, err := cborDecode(append(data, 0xff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data) {
+		t.Errorf("decoded %d bytes, want %d", n, len(data))
+	}
+
+	m := v.(map[any]any)
+	if m[int64(1)] != int64(2) || m[int64(-7)] != "text" || !bytes.Equal(m["bytes"].([]byte), []byte{1, 2}) {
+		t.Errorf("unexpected map %v", m)
+	}
+	list := m["list"].([]any)
+	if len(list) != 4 || list[1] != int64(-1) || list[2] != int64(500) || list[3] != int64(70000) {
+		t.Errorf("unexpected list %v", list)
+	}
+
+	simple, _, err := cborDecode([]byte{0x83, 0xf4, 0xf5, 0xf6})
+	if err != nil || !slices.Equal(simple.([]any), []any{false, true, nil}) {
+		t.Errorf("simple values: %v %v", simple, err)
+	}
+}
+
+func TestCBORDecodeRejectsMalformed(t *testing.T) {
+	deep := bytes.Repeat([]byte{0x81}, cborMaxDepth+2)
+	deep = append(deep, 0x00)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated argument", []byte{0x19, 0x01}},
+		{"truncated byte string", []byte{0x42, 0x01}},
+		{"truncated text string", []byte{0x63, 'a', 'b'}},
+		{"truncated array", []byte{0x82, 0x01}},
+		{"truncated map", []byte{0xa1, 0x01}},
+		{"reserved additional info", []byte{0x1c}},
+		{"indefinite-length array", []byte{0x9f, 0x01, 0xff}},
+		{"indefinite-length byte string", []byte{0x5f, 0x41, 0x01, 0xff}},
+		{"huge array length", []byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"huge byte string length", []byte{0x5b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"integer overflow", []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"byte string map key", []byte{0xa1, 0x41, 0x01, 0x00}},
+		{"array map key", []byte{0xa1, 0x80, 0x00}},
+		{"duplicate map key", []byte{0xa2, 0x01, 0x00, 0x01, 0x00}},
+		{"unassigned simple value", []byte{0xf0}},
+		{"half-precision float", []byte{0xf9, 0x3c, 0x00}},
+		{"break outside indefinite item", []byte{0xff}},
+		{"too deeply nested", deep},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if v, _, err := cborDecode(tt.data); err == nil {
+				t.Errorf("decoded %v from malformed input", v)
+			}
+		})
+	}
+}
+
+func TestParseAuthenticatorDataRejectsBadCredentialLength(t *testing.T) {
+	rpIDHash := sha256.Sum256([]byte(webAuthnRPID))
+	base := append(rpIDHash[:], authDataUserPresent|authDataAttested, 0, 0, 0, 0)
+	base = append(base, make([]byte, 16)...)
+
+	for name, data := range map[string][]byte{
+		"zero length":           append(slices.Clone(base), 0, 0),
+		"longer than remaining": append(slices.Clone(base), 0, 40, 1, 2, 3),
+		"over 1023 bytes":       append(append(slices.Clone(base), 0x04, 0x00), make([]byte, 1024)...),
+	} {
+		if _, err := parseAuthenticatorData(data); err == nil || err.Error() != "invalid credential id length" {
+			t.Errorf("%s: got %v, want invalid credential id length", name, err)
+		}
+	}
+
+	// A credential ID followed by something that isn't a COSE key
+	bad := append(slices.Clone(base), 0, 2, 0xaa, 0xbb, 0x1c)
+	if _, err := parseAuthenticatorData(bad); err == nil || err.Error() != "invalid credential public key" {
+		t.Errorf("got %v, want invalid credential public key", err)
+	}
+}
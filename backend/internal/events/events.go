@@ -0,0 +1,116 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types pushed to clients
+const (
+	EntryCreated  = "entry.created"
+	EntryUpdated  = "entry.updated"  // Edited, restored from a revision or from the trash
+	EntryDeleted  = "entry.deleted"  // Moved to the trash
+	EntryPurged   = "entry.purged"   // Deleted for good
+	VaultRekeyed  = "vault.rekeyed"  // Key rotated; every entry changed
+	VaultChanged  = "vault.changed"  // Many entries changed at once (a batch)
+	DeviceAdded   = "device.added"   // A device is waiting for approval
+	DeviceChanged = "device.changed" // Approved, rejected, renamed or revoked
+	ShareInvited  = "share.invited"  // The user was invited to a vault
+	ShareChanged  = "share.changed"  // A member joined, left, declined or changed role
+)
+
+// Event is a change a user's other sessions should hear about. It only carries IDs;
+// clients fetch what changed through the API (e.g. GET /api/sync).
+type Event struct {
+	Type     string    `json:"type"`
+	VaultID  string    `json:"vault_id,omitempty"`
+	EntryID  string    `json:"entry_id,omitempty"`
+	DeviceID string    `json:"device_id,omitempty"`
+	At       time.Time `json:"at"`
+
+	UserID    string `json:"-"` // Recipient
+	SessionID string `json:"-"` // Session that caused the change; it isn't told about it
+}
+
+// Broker fans events out to the subscribed sessions of their recipient. Hub does this
+// within one process; a deployment running several instances would implement it on top
+// of Postgres LISTEN/NOTIFY, publishing with NOTIFY and delivering from LISTEN.
+type Broker interface {
+	Publish(e Event)
+
+	// Subscribe registers a session for the user's events. The channel is closed when
+	// the subscriber falls too far behind, and the client should resync and reconnect;
+	// call the returned function to unsubscribe.
+	Subscribe(userID, sessionID string) (<-chan Event, func())
+}
+
+// subscriberBuffer is how many events a subscriber can lag behind before it is dropped
+const subscriberBuffer = 64
+
+type subscriber struct {
+	sessionID string
+	ch        chan Event
+}
+
+// Hub is an in-process Broker
+type Hub struct {
+	mu   sync.Mutex
+	subs map[string]map[*subscriber]struct{}
+}
+
+// NewHub creates an in-process Broker
+func NewHub() *Hub {
+	return &Hub{subs: make(map[string]map[*subscriber]struct{})}
+}
+
+// Publish delivers e to every session of e.UserID except the one it came from. It never
+// blocks: a subscriber whose buffer is full is dropped.
+func (h *Hub) Publish(e Event) {
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs[e.UserID] {
+		if e.SessionID != "" && sub.sessionID == e.SessionID {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			h.remove(e.UserID, sub)
+		}
+	}
+}
+
+// Subscribe registers a session for the user's events
+func (h *Hub) Subscribe(userID, sessionID string) (<-chan Event, func()) {
+	sub := &subscriber{sessionID: sessionID, ch: make(chan Event, subscriberBuffer)}
+
+	h.mu.Lock()
+	if h.subs[userID] == nil {
+		h.subs[userID] = make(map[*subscriber]struct{})
+	}
+	h.subs[userID][sub] = struct{}{}
+	h.mu.Unlock()
+
+	return sub.ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.remove(userID, sub)
+	}
+}
+
+// remove drops a subscriber and closes its channel; h.mu must be held
+func (h *Hub) remove(userID string, sub *subscriber) {
+	if _, ok := h.subs[userID][sub]; !ok {
+		return
+	}
+	delete(h.subs[userID], sub)
+	if len(h.subs[userID]) == 0 {
+		delete(h.subs, userID)
+	}
+	close(sub.ch)
+}
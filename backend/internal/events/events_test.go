@@ -0,0 +1,157 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+// drain returns the events waiting on ch, and whether ch is still open
+func drain(ch <-chan Event) ([]Event, bool) {
+	var got []Event
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return got, false
+			}
+			got = append(got, e)
+		default:
+			return got, true
+		}
+	}
+}
+
+func TestHubPublish(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+		want  map[string]bool // subscriber -> receives the event
+	}{
+		{
+			name:  "every session of the user",
+			event: Event{Type: EntryCreated, UserID: "alice"},
+			want:  map[string]bool{"alice/s1": true, "alice/s2": true, "bob/s3": false},
+		},
+		{
+			name:  "not back to the sending session",
+			event: Event{Type: EntryUpdated, UserID: "alice", SessionID: "s1"},
+			want:  map[string]bool{"alice/s1": false, "alice/s2": true, "bob/s3": false},
+		},
+		{
+			name:  "a session ID of another user's session is ignored",
+			event: Event{Type: EntryDeleted, UserID: "bob", SessionID: "s1"},
+			want:  map[string]bool{"alice/s1": false, "alice/s2": false, "bob/s3": true},
+		},
+		{
+			name:  "nobody subscribed",
+			event: Event{Type: DeviceAdded, UserID: "carol"},
+			want:  map[string]bool{"alice/s1": false, "alice/s2": false, "bob/s3": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := NewHub()
+			subs := map[string]<-chan Event{}
+			for _, s := range []struct{ user, session string }{{"alice", "s1"}, {"alice", "s2"}, {"bob", "s3"}} {
+				ch, unsubscribe := hub.Subscribe(s.user, s.session)
+				defer unsubscribe()
+				subs[s.user+"/"+s.session] = ch
+			}
+
+			hub.Publish(tt.event)
+
+			for name, ch := range subs {
+				got, open := drain(ch)
+				if !open {
+					t.Errorf("%s: channel was closed", name)
+				}
+				if received := len(got) == 1; received != tt.want[name] {
+					t.Errorf("%s: got %d events, want the event: %v", name, len(got), tt.want[name])
+					continue
+				}
+				if len(got) == 1 && (got[0].Type != tt.event.Type || got[0].At.IsZero()) {
+					t.Errorf("%s: got %+v", name, got[0])
+				}
+			}
+		})
+	}
+}
+
+func TestHubPublishKeepsTimestamp(t *testing.T) {
+	hub := NewHub()
+	ch, unsubscribe := hub.Subscribe("alice", "s1")
+	defer unsubscribe()
+
+	at := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	hub.Publish(Event{Type: EntryCreated, UserID: "alice", At: at})
+	if got, _ := drain(ch); len(got) != 1 || !got[0].At.Equal(at) {
+		t.Errorf("got %+v, want the event at %v", got, at)
+	}
+}
+
+func TestHubDropsSlowSubscriber(t *testing.T) {
+	hub := NewHub()
+	slow, unsubscribeSlow := hub.Subscribe("alice", "slow")
+	fast, unsubscribeFast := hub.Subscribe("alice", "fast")
+	defer unsubscribeFast()
+
+	// Publish never blocks, even once the slow subscriber's buffer is full
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < subscriberBuffer+1; i++ {
+			hub.Publish(Event{Type: EntryUpdated, UserID: "alice"})
+			if i%8 == 0 {
+				drain(fast)
+			}
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish blocked on a full subscriber")
+	}
+
+	got, open := drain(slow)
+	if open {
+		t.Fatal("slow subscriber was not dropped")
+	}
+	if len(got) != subscriberBuffer {
+		t.Errorf("slow subscriber got %d buffered events, want %d", len(got), subscriberBuffer)
+	}
+
+	// The dropped subscriber hears nothing more; the other one still does
+	drain(fast)
+	hub.Publish(Event{Type: EntryDeleted, UserID: "alice"})
+	if got, open := drain(fast); !open || len(got) != 1 {
+		t.Errorf("fast subscriber: got %d events, open %v", len(got), open)
+	}
+
+	// Unsubscribing after the drop must not close the channel a second time
+	unsubscribeSlow()
+	unsubscribeSlow()
+}
+
+func TestHubUnsubscribe(t *testing.T) {
+	hub := NewHub()
+	ch, unsubscribe := hub.Subscribe("alice", "s1")
+
+	unsubscribe()
+	if _, open := drain(ch); open {
+		t.Error("channel is still open after unsubscribing")
+	}
+	unsubscribe()
+
+	hub.Publish(Event{Type: EntryCreated, UserID: "alice"})
+	if len(hub.subs) != 0 {
+		t.Errorf("hub still tracks %d users", len(hub.subs))
+	}
+
+	// Later subscriptions for the same user work as before
+	ch, unsubscribe = hub.Subscribe("alice", "s1")
+	defer unsubscribe()
+	hub.Publish(Event{Type: EntryCreated, UserID: "alice"})
+	if got, open := drain(ch); !open || len(got) != 1 {
+		t.Errorf("new subscription: got %d events, open %v", len(got), open)
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"backend/pswd/internal/auth"
 	"backend/pswd/internal/models"
+	"database/sql"
 	"encoding/json"
 	"net/http"
 )
@@ -16,17 +17,43 @@ func (h *Handler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate required fields
-	if req.Username == "" || req.Password == "" || req.DeviceFingerprint == "" {
-		http.Error(w, "username, password, and device_fingerprint are required", http.StatusBadRequest)
+	usesSRP := req.SRPSalt != "" || req.SRPVerifier != ""
+	if req.Username == "" || req.DeviceFingerprint == "" || (req.Password == "" && !usesSRP) {
+		http.Error(w, "username, password (or srp_salt and srp_verifier), and device_fingerprint are required", http.StatusBadRequest)
 		return
 	}
-
-	// Hash password
-	passwordHash, err := auth.HashPassword(req.Password)
-	if err != nil {
-		http.Error(w, "failed to process password", http.StatusInternalServerError)
+	if usesSRP && req.Password != "" {
+		http.Error(w, "send either password or srp_salt and srp_verifier, not both", http.StatusBadRequest)
 		return
 	}
+	if usesSRP {
+		if err := auth.ValidateSRPVerifier(req.SRPSalt, req.SRPVerifier); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Signing keys are optional, but must be usable if present
+	for _, key := range []string{req.PkSign, req.PkDeviceSign, req.PkRecovery} {
+		if key == "" {
+			continue
+		}
+		if _, err := auth.DecodePublicKey(key); err != nil {
+			http.Error(w, "pk_sign, pk_device_sign and pk_recovery must be Ed25519 public keys", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Hash password (SRP registrations never send one)
+	var passwordHash sql.NullString
+	if !usesSRP {
+		hash, err := auth.HashPassword(req.Password)
+		if err != nil {
+			http.Error(w, "failed to process password", http.StatusInternalServerError)
+			return
+		}
+		passwordHash = sql.NullString{String: hash, Valid: true}
+	}
 
 	// Start transaction
 	tx, err := h.DB.Begin()
@@ -39,10 +66,12 @@ func (h *Handler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	// Insert user
 	var userID string
 	err = tx.QueryRow(`
-		INSERT INTO users (username, email, pk_encrypt, pk_sign, password_hash, is_master_device_registered)
-		VALUES ($1, $2, $3, $4, $5, true)
+		INSERT INTO users (username, email, pk_encrypt, pk_sign, password_hash, srp_salt, srp_verifier,
+			pk_recovery, recovery_wrapped_vault_key, is_master_device_registered)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), true)
 		RETURNING user_id`,
-		req.Username, req.Email, req.PkEncrypt, req.PkSign, passwordHash,
+		req.Username, req.Email, req.PkEncrypt, req.PkSign, passwordHash, req.SRPSalt, req.SRPVerifier,
+		req.PkRecovery, req.RecoveryWrappedVaultKey,
 	).Scan(&userID)
 
 	if err != nil {
@@ -53,10 +82,10 @@ func (h *Handler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	// Register master device
 	var deviceID string
 	err = tx.QueryRow(`
-		INSERT INTO devices (user_id, device_name, device_fingerprint, pk_device, is_master)
-		VALUES ($1, $2, $3, $4, true)
+		INSERT INTO devices (user_id, device_name, device_fingerprint, pk_device, pk_device_sign, is_master)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), true)
 		RETURNING device_id`,
-		userID, req.DeviceName, req.DeviceFingerprint, req.PkDevice,
+		userID, req.DeviceName, req.DeviceFingerprint, req.PkDevice, req.PkDeviceSign,
 	).Scan(&deviceID)
 
 	if err != nil {
@@ -64,27 +93,45 @@ func (h *Handler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var vaultID string
+	err = tx.QueryRow(`
+		INSERT INTO vaults (user_id, name, is_default) VALUES ($1, $2, true)
+		RETURNING vault_id`,
+		userID, defaultVaultName,
+	).Scan(&vaultID)
+	if err != nil {
+		http.Error(w, "failed to create vault", http.StatusInternalServerError)
+		return
+	}
+
+	if req.WrappedVaultKey != "" {
+		if err := putVaultKey(tx, vaultID, deviceID, req.WrappedVaultKey, 0); err != nil {
+			http.Error(w, "failed to create vault", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	if err := tx.Commit(); err != nil {
 		http.Error(w, "failed to complete registration", http.StatusInternalServerError)
 		return
 	}
 
-	// Generate JWT token
-	token, err := auth.GenerateToken(userID, req.Username, deviceID)
+	// Open a server-side session and issue an access/refresh token pair.
+	// Both are also set as HTTP-only secure cookies.
+	token, refreshToken, err := h.startSession(w, r, userID, req.Username, deviceID)
 	if err != nil {
-		http.Error(w, "failed to generate token", http.StatusInternalServerError)
+		http.Error(w, "failed to create session", http.StatusInternalServerError)
 		return
 	}
 
-	// Set HTTP-only secure cookie
-	auth.SetAuthCookie(w, token, 60*60*24*30) // 30 days
-
 	resp := models.RegisterResponse{
-		UserID:   userID,
-		Username: req.Username,
-		Token:    token, // Still send in response for backward compatibility
-		DeviceID: deviceID,
-		IsMaster: true,
+		UserID:       userID,
+		Username:     req.Username,
+		Token:        token, // Still send in response for backward compatibility
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(auth.AccessTokenTTL.Seconds()),
+		DeviceID:     deviceID,
+		IsMaster:     true,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -105,12 +152,13 @@ func (h *Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	err := h.DB.QueryRow(`
 		SELECT user_id, username, password_hash
 		FROM users
-		WHERE username = $1`,
+		WHERE username = $1 AND password_hash IS NOT NULL`,
 		req.Username,
 	).Scan(&userID, &username, &passwordHash)
 
 	// Always verify password hash even if user not found (prevents timing attacks)
-	// Use a dummy hash if user doesn't exist so bcrypt still runs
+	// Use a dummy hash if user doesn't exist so bcrypt still runs.
+	// Users who moved to SRP have no password hash and can only log in with SRP.
 	if err != nil {
 		// Dummy bcrypt hash - ensures timing is consistent whether user exists or not
 		passwordHash = "$2a$12$DummyHashToPreventTimingAttacksForNonExistentUsers1234567"
@@ -127,54 +175,250 @@ func (h *Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if device exists
-	var deviceID string
+	var deviceID, status string
 	var isMaster bool
 	err = h.DB.QueryRow(`
-		SELECT device_id, is_master
+		SELECT device_id, is_master, status
 		FROM devices
 		WHERE user_id = $1 AND device_fingerprint = $2`,
 		userID, req.DeviceFingerprint,
-	).Scan(&deviceID, &isMaster)
+	).Scan(&deviceID, &isMaster, &status)
 
-	if err != nil {
-		// Device not registered - this should prompt device registration flow
+	if err == nil && status == devicePending {
+		http.Error(w, "device pending approval", http.StatusForbidden)
+		return
+	}
+	if err != nil || status != deviceActive {
+		// Device not registered (or rejected/revoked) - this should prompt device enrollment (POST /api/devices/enroll)
 		http.Error(w, "device not registered", http.StatusForbidden)
 		return
 	}
 
-	// Update last seen
-	_, err = h.DB.Exec(`
-		UPDATE devices SET last_seen = now() WHERE device_id = $1`,
-		deviceID,
-	)
+	h.completeLogin(w, r, models.LoginResponse{
+		UserID:   userID,
+		Username: username,
+		DeviceID: deviceID,
+		IsMaster: isMaster,
+	})
+}
 
-	// Generate token
-	token, err := auth.GenerateToken(userID, username, deviceID)
+// completeLogin finishes any successful first-factor login. Users with two-factor
+// authentication get an MFA challenge instead of tokens (see TwoFactorLoginHandler).
+func (h *Handler) completeLogin(w http.ResponseWriter, r *http.Request, resp models.LoginResponse) {
+	var totpEnabled, webAuthnEnabled bool
+	err := h.DB.QueryRow(`
+		SELECT u.totp_enabled, EXISTS (
+			SELECT 1 FROM webauthn_credentials wc WHERE wc.user_id = u.user_id
+		)
+		FROM users u
+		WHERE u.user_id = $1`,
+		resp.UserID,
+	).Scan(&totpEnabled, &webAuthnEnabled)
 	if err != nil {
-		http.Error(w, "failed to generate token", http.StatusInternalServerError)
+		http.Error(w, "database error", http.StatusInternalServerError)
 		return
 	}
 
-	// Set HTTP-only secure cookie
-	auth.SetAuthCookie(w, token, 60*60*24*30) // 30 days
+	if totpEnabled || webAuthnEnabled {
+		var methods []string
+		if totpEnabled {
+			methods = append(methods, "totp")
+		}
+		if webAuthnEnabled {
+			methods = append(methods, "webauthn")
+		}
+		h.beginSecondFactor(w, resp, append(methods, "recovery_code"))
+		return
+	}
 
-	resp := models.LoginResponse{
-		UserID:   userID,
-		Username: username,
-		Token:    token, // Still send in response for backward compatibility
-		DeviceID: deviceID,
-		IsMaster: isMaster,
+	h.issueLogin(w, r, resp)
+}
+
+// issueLogin records the device as seen, opens a session and writes resp with the
+// issued tokens and the device's wrapped vault key filled in
+func (h *Handler) issueLogin(w http.ResponseWriter, r *http.Request, resp models.LoginResponse) {
+	// Update last seen
+	var wrappedVaultKey sql.NullString
+	err := h.DB.QueryRow(`
+		UPDATE devices SET last_seen = now() WHERE device_id = $1
+		RETURNING (
+			SELECT vk.wrapped_key FROM vault_keys vk
+			JOIN vaults v ON v.vault_id = vk.vault_id
+			WHERE vk.device_id = devices.device_id AND v.is_default
+		)`,
+		resp.DeviceID,
+	).Scan(&wrappedVaultKey)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
 	}
+	resp.WrappedVaultKey = wrappedVaultKey.String
+
+	// Open a server-side session and issue an access/refresh token pair.
+	// Both are also set as HTTP-only secure cookies.
+	token, refreshToken, err := h.startSession(w, r, resp.UserID, resp.Username, resp.DeviceID)
+	if err != nil {
+		http.Error(w, "failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	resp.Token = token // Still send in response for backward compatibility
+	resp.RefreshToken = refreshToken
+	resp.ExpiresIn = int(auth.AccessTokenTTL.Seconds())
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
-// LogoutHandler clears the authentication cookie
+// LogoutHandler revokes the current session and clears the authentication cookie
 func (h *Handler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	// Clear the auth cookie
+	// Revoke the session behind the presented token, if any, so copies of it stop working
+	if tokenString, err := auth.ExtractTokenFromRequest(r); err == nil {
+		if claims, err := auth.ValidateToken(tokenString); err == nil && claims.ID != "" {
+			_, err = h.DB.Exec(`
+				UPDATE sessions SET revoked_at = now()
+				WHERE session_id = $1 AND user_id = $2 AND revoked_at IS NULL`,
+				claims.ID, claims.UserID,
+			)
+			if err != nil {
+				http.Error(w, "failed to revoke session", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	// The access token may already have expired; the refresh cookie still identifies the session
+	if refreshToken, err := auth.ExtractRefreshTokenFromRequest(r); err == nil {
+		_, err = h.DB.Exec(`
+			UPDATE sessions SET revoked_at = now()
+			WHERE revoked_at IS NULL AND session_id = (
+				SELECT session_id FROM refresh_tokens WHERE token_hash = $1
+			)`,
+			auth.HashToken(refreshToken),
+		)
+		if err != nil {
+			http.Error(w, "failed to revoke session", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Clear the auth and refresh cookies
 	auth.ClearAuthCookie(w)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "logged out successfully"})
 }
+
+// RefreshHandler rotates a refresh token and issues a new access token.
+// The refresh token is read from its cookie or, for bearer clients, from the JSON body.
+// Presenting an already-used refresh token is treated as theft: the whole token
+// family (the session) is revoked.
+func (h *Handler) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	presented, err := auth.ExtractRefreshTokenFromRequest(r)
+	if err != nil {
+		var req models.RefreshRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+			http.Error(w, "refresh token missing", http.StatusUnauthorized)
+			return
+		}
+		presented = req.RefreshToken
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var tokenID, sessionID, userID, username, deviceID string
+	var used, usable bool
+	err = tx.QueryRow(`
+		SELECT rt.token_id, rt.session_id, rt.used_at IS NOT NULL,
+			rt.expires_at > now() AND s.revoked_at IS NULL AND s.expires_at > now(),
+			s.user_id, u.username, s.device_id
+		FROM refresh_tokens rt
+		JOIN sessions s ON s.session_id = rt.session_id
+		JOIN users u ON u.user_id = s.user_id
+		WHERE rt.token_hash = $1
+		FOR UPDATE OF rt`,
+		auth.HashToken(presented),
+	).Scan(&tokenID, &sessionID, &used, &usable, &userID, &username, &deviceID)
+	if err != nil {
+		auth.ClearAuthCookie(w)
+		http.Error(w, "invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	if used {
+		// Reuse detected: someone else holds a copy of this token family
+		_, err = tx.Exec(`
+			UPDATE sessions SET revoked_at = now()
+			WHERE session_id = $1 AND revoked_at IS NULL`,
+			sessionID,
+		)
+		if err != nil || tx.Commit() != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		auth.ClearAuthCookie(w)
+		http.Error(w, "refresh token reuse detected", http.StatusUnauthorized)
+		return
+	}
+
+	if !usable {
+		auth.ClearAuthCookie(w)
+		http.Error(w, "session expired", http.StatusUnauthorized)
+		return
+	}
+
+	// Rotate: retire the presented token, issue its successor and slide the session expiry
+	refreshToken, err := storeRefreshToken(tx, sessionID)
+	if err != nil {
+		http.Error(w, "failed to rotate refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE refresh_tokens SET used_at = now()
+		WHERE token_id = $1`,
+		tokenID,
+	)
+	if err != nil {
+		http.Error(w, "failed to rotate refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE sessions
+		SET last_used_at = now(), expires_at = now() + ($1 * interval '1 second')
+		WHERE session_id = $2`,
+		int64(auth.RefreshTokenTTL.Seconds()), sessionID,
+	)
+	if err != nil {
+		http.Error(w, "failed to extend session", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to rotate refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := auth.GenerateToken(userID, username, deviceID, sessionID)
+	if err != nil {
+		http.Error(w, "failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	setAuthCookies(w, token, refreshToken)
+
+	resp := models.RefreshResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(auth.AccessTokenTTL.Seconds()),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"backend/pswd/internal/events"
+	"backend/pswd/internal/models"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Batch operations
+const (
+	batchCreate = "create"
+	batchUpdate = "update"
+	batchDelete = "delete"
+)
+
+// maxBatchOperations caps how many operations one batch can hold
+const maxBatchOperations = 1000
+
+// BatchEntriesHandler applies a list of create, update and delete operations to a vault in
+// one transaction, e.g. for an import. Updates and deletes carry the revision they are
+// based on; if any operation fails, nothing is applied and the response names it. Vault
+// key rotation has its own endpoint (RotateVaultKeyHandler), which also swaps the keys.
+func (h *Handler) BatchEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	deviceID := getDeviceID(r.Context())
+	vaultID := getVaultID(r.Context())
+
+	var req models.BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Operations) == 0 {
+		http.Error(w, "operations are required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Operations) > maxBatchOperations {
+		http.Error(w, fmt.Sprintf("a batch can hold at most %d operations", maxBatchOperations), http.StatusBadRequest)
+		return
+	}
+
+	// Check every operation before touching the database
+	decoded := make([][]byte, len(req.Operations))
+	for i, op := range req.Operations {
+		switch op.Op {
+		case batchCreate:
+		case batchUpdate, batchDelete:
+			if op.EntryID == "" || op.Revision < 1 {
+				writeBatchError(w, http.StatusBadRequest, i, op.EntryID, "entry_id and revision are required", 0)
+				return
+			}
+		default:
+			writeBatchError(w, http.StatusBadRequest, i, op.EntryID, "op must be create, update or delete", 0)
+			return
+		}
+
+		if op.Op != batchDelete {
+			encryptedData, err := base64.StdEncoding.DecodeString(op.EncryptedData)
+			if err != nil {
+				writeBatchError(w, http.StatusBadRequest, i, op.EntryID, "invalid encrypted data", 0)
+				return
+			}
+			decoded[i] = encryptedData
+		}
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	seq, err := nextChangeSeq(tx, vaultID)
+	if err != nil {
+		http.Error(w, "failed to apply batch", http.StatusInternalServerError)
+		return
+	}
+
+	resp := models.BatchResponse{Results: make([]models.BatchResult, 0, len(req.Operations))}
+	for i, op := range req.Operations {
+		entry := models.VaultEntryRequest{Title: op.Title, EncryptedData: op.EncryptedData, EntryType: op.EntryType}
+		result := models.BatchResult{Op: op.Op, EntryID: op.EntryID}
+
+		var current int
+		switch op.Op {
+		case batchCreate:
+			result.EntryID, result.Revision, err = insertEntry(tx, vaultID, userID, seq, entry, decoded[i])
+		case batchUpdate:
+			var updated models.VaultEntryResponse
+			updated, current, err = h.updateEntry(tx, vaultID, op.EntryID, deviceID, seq, op.Revision, entry, decoded[i])
+			result.Revision = updated.Revision
+		case batchDelete:
+			result.EntryID, current, err = trashEntry(tx, vaultID, op.EntryID, seq, op.Revision)
+			result.Revision = current
+		}
+
+		if err == sql.ErrNoRows {
+			writeBatchError(w, http.StatusNotFound, i, op.EntryID, "entry not found", 0)
+			return
+		}
+		if err == errStaleRevision {
+			writeBatchError(w, http.StatusPreconditionFailed, i, op.EntryID, err.Error(), current)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to apply batch", http.StatusInternalServerError)
+			return
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to apply batch", http.StatusInternalServerError)
+		return
+	}
+
+	h.publishVaultEvent(getSessionID(r.Context()), events.Event{Type: events.VaultChanged, VaultID: vaultID})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// writeBatchError reports the operation that made a batch fail
+func writeBatchError(w http.ResponseWriter, status, index int, entryID, message string, current int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.BatchErrorResponse{
+		Error:           message,
+		Index:           index,
+		EntryID:         entryID,
+		CurrentRevision: current,
+	})
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"backend/pswd/internal/auth"
+	"backend/pswd/internal/models"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ChallengeHandler issues a single-use nonce for a signature-based login.
+// Unknown usernames or devices get a decoy challenge that can never be answered,
+// so the endpoint doesn't reveal which accounts exist.
+func (h *Handler) ChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.ChallengeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	nonce, err := auth.GenerateNonce()
+	if err != nil {
+		http.Error(w, "failed to generate challenge", http.StatusInternalServerError)
+		return
+	}
+
+	resp := models.ChallengeResponse{
+		Nonce:     nonce,
+		ExpiresAt: time.Now().Add(auth.LoginChallengeTTL).UTC(),
+	}
+
+	err = h.DB.QueryRow(`
+		INSERT INTO auth_challenges (user_id, device_id, nonce, expires_at)
+		SELECT u.user_id, d.device_id, $3, now() + ($4 * interval '1 second')
+		FROM users u
+		JOIN devices d ON d.user_id = u.user_id
+		WHERE u.username = $1 AND d.device_fingerprint = $2 AND d.status = 'active'
+		RETURNING challenge_id`,
+		req.Username, req.DeviceFingerprint, nonce, int64(auth.LoginChallengeTTL.Seconds()),
+	).Scan(&resp.ChallengeID)
+
+	if err == sql.ErrNoRows {
+		resp.ChallengeID = uuid.NewString()
+	} else if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// SignatureLoginHandler logs a device in by verifying its signature over a challenge,
+// so an enrolled device never has to send the master password
+func (h *Handler) SignatureLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.SignatureLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	if req.Key != "device" && req.Key != "sign" {
+		http.Error(w, `key must be "device" or "sign"`, http.StatusBadRequest)
+		return
+	}
+
+	// Consume the challenge atomically so each nonce can be answered only once
+	var userID, deviceID, nonce string
+	err := h.DB.QueryRow(`
+		UPDATE auth_challenges SET used_at = now()
+		WHERE challenge_id::text = $1 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id, device_id, nonce`,
+		req.ChallengeID,
+	).Scan(&userID, &deviceID, &nonce)
+	if err != nil {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	var username string
+	var isMaster bool
+	var pkSign, pkDeviceSign sql.NullString
+	err = h.DB.QueryRow(`
+		SELECT u.username, u.pk_sign, d.pk_device_sign, d.is_master
+		FROM users u
+		JOIN devices d ON d.user_id = u.user_id
+		WHERE u.user_id = $1 AND d.device_id = $2 AND d.status = 'active'`,
+		userID, deviceID,
+	).Scan(&username, &pkSign, &pkDeviceSign, &isMaster)
+	if err != nil {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	publicKey := pkSign.String
+	if req.Key == "device" {
+		publicKey = pkDeviceSign.String
+	}
+
+	message := auth.LoginChallengeMessage(req.ChallengeID, nonce)
+	if publicKey == "" || !auth.VerifySignature(publicKey, message, req.Signature) {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	h.completeLogin(w, r, models.LoginResponse{
+		UserID:   userID,
+		Username: username,
+		DeviceID: deviceID,
+		IsMaster: isMaster,
+	})
+}
@@ -6,8 +6,14 @@ import "context"
 type contextKey string
 
 const (
-	userIDKey   contextKey = "userID"
-	deviceIDKey contextKey = "deviceID"
+	userIDKey     contextKey = "userID"
+	deviceIDKey   contextKey = "deviceID"
+	sessionIDKey  contextKey = "sessionID"
+	mfaEnabledKey contextKey = "mfaEnabled"
+	vaultIDKey    contextKey = "vaultID"
+	vaultRoleKey  contextKey = "vaultRole"
+	orgIDKey      contextKey = "orgID"
+	orgRoleKey    contextKey = "orgRole"
 )
 
 func setUserID(ctx context.Context, userID string) context.Context {
@@ -31,3 +37,67 @@ func getDeviceID(ctx context.Context) string {
 	}
 	return ""
 }
+
+func setSessionID(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionIDKey, sessionID)
+}
+
+func getSessionID(ctx context.Context) string {
+	if sessionID, ok := ctx.Value(sessionIDKey).(string); ok {
+		return sessionID
+	}
+	return ""
+}
+
+func setMFAEnabled(ctx context.Context, enabled bool) context.Context {
+	return context.WithValue(ctx, mfaEnabledKey, enabled)
+}
+
+func getMFAEnabled(ctx context.Context) bool {
+	enabled, _ := ctx.Value(mfaEnabledKey).(bool)
+	return enabled
+}
+
+func setVaultID(ctx context.Context, vaultID string) context.Context {
+	return context.WithValue(ctx, vaultIDKey, vaultID)
+}
+
+func getVaultID(ctx context.Context) string {
+	if vaultID, ok := ctx.Value(vaultIDKey).(string); ok {
+		return vaultID
+	}
+	return ""
+}
+
+func setVaultRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, vaultRoleKey, role)
+}
+
+func getVaultRole(ctx context.Context) string {
+	if role, ok := ctx.Value(vaultRoleKey).(string); ok {
+		return role
+	}
+	return ""
+}
+
+func setOrgID(ctx context.Context, orgID string) context.Context {
+	return context.WithValue(ctx, orgIDKey, orgID)
+}
+
+func getOrgID(ctx context.Context) string {
+	if orgID, ok := ctx.Value(orgIDKey).(string); ok {
+		return orgID
+	}
+	return ""
+}
+
+func setOrgRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, orgRoleKey, role)
+}
+
+func getOrgRole(ctx context.Context) string {
+	if role, ok := ctx.Value(orgRoleKey).(string); ok {
+		return role
+	}
+	return ""
+}
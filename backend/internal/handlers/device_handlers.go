@@ -0,0 +1,462 @@
+package handlers
+
+import (
+	"backend/pswd/internal/auth"
+	"backend/pswd/internal/events"
+	"backend/pswd/internal/models"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Device statuses. Only active devices can log in.
+const (
+	deviceActive   = "active"
+	devicePending  = "pending"
+	deviceRejected = "rejected"
+	deviceRevoked  = "revoked"
+)
+
+// Limits on enrollment requests, which anyone can make for any username. Past them new
+// requests are refused (silently, like unknown usernames), never the owner's earlier one
+// evicted, so a flood can only delay the owner until the master device rejects it.
+const (
+	// maxPendingDevices caps open enrollment requests per user, so an attacker can't
+	// flood the master device with requests
+	maxPendingDevices = 5
+
+	// maxEnrollmentsPerHour caps requests per username, whatever their source, so
+	// rejected requests can't be refilled as fast as the master device clears them
+	maxEnrollmentsPerHour = 10
+
+	// pendingDeviceTTL is how long a request waits for the master device. Expired and
+	// rejected requests are deleted after it (see StartDevicePurger).
+	pendingDeviceTTL = 24 * time.Hour
+)
+
+// EnrollDeviceHandler lets an unknown device ask to join an account. The device becomes
+// pending until the master device approves it (see ApproveDeviceHandler), and still
+// needs the account's credentials to log in afterwards. Unknown usernames get the
+// same response as real ones so the endpoint doesn't reveal which accounts exist.
+func (h *Handler) EnrollDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.DeviceEnrollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	req.DeviceName = strings.TrimSpace(req.DeviceName)
+	if req.Username == "" || req.DeviceName == "" || req.DeviceFingerprint == "" {
+		http.Error(w, "username, device_name and device_fingerprint are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateDeviceKeys(req.PkDevice, req.PkDeviceSign); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := models.DeviceEnrollResponse{Status: devicePending}
+	userID, deviceID, err := enrollPendingDevice(h.DB, req)
+	if err == sql.ErrNoRows {
+		resp.DeviceID = uuid.NewString()
+	} else if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	} else {
+		resp.DeviceID = deviceID
+		h.publishUserEvent("", userID, events.Event{Type: events.DeviceAdded, DeviceID: deviceID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// enrollPendingDevice adds a pending device to the account named in req. It returns
+// sql.ErrNoRows if there is no such account, the fingerprint is already in use or the
+// account has reached maxPendingDevices or maxEnrollmentsPerHour.
+func enrollPendingDevice(db *sql.DB, req models.DeviceEnrollRequest) (string, string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	// Lock the account so concurrent requests can't both see room under the caps
+	var userID string
+	err = tx.QueryRow(`SELECT user_id FROM users WHERE username = $1 FOR UPDATE`, req.Username).Scan(&userID)
+	if err != nil {
+		return "", "", err
+	}
+
+	var pending, recent int
+	err = tx.QueryRow(`
+		SELECT count(*) FILTER (WHERE status = 'pending' AND created_at > now() - ($2 * interval '1 second')),
+			count(*) FILTER (WHERE NOT is_master AND created_at > now() - interval '1 hour')
+		FROM devices WHERE user_id = $1`,
+		userID, int(pendingDeviceTTL.Seconds()),
+	).Scan(&pending, &recent)
+	if err != nil {
+		return "", "", err
+	}
+	if pending >= maxPendingDevices || recent >= maxEnrollmentsPerHour {
+		return "", "", sql.ErrNoRows
+	}
+
+	// A rejected or revoked device may ask again; any other existing fingerprint is left alone
+	var deviceID string
+	err = tx.QueryRow(`
+		INSERT INTO devices (user_id, device_name, device_fingerprint, pk_device, pk_device_sign, is_master, status)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), false, 'pending')
+		ON CONFLICT (device_fingerprint) DO UPDATE
+		SET device_name = EXCLUDED.device_name,
+			pk_device = EXCLUDED.pk_device,
+			pk_device_sign = EXCLUDED.pk_device_sign,
+			status = 'pending',
+			created_at = now()
+		WHERE devices.status IN ('rejected', 'revoked') AND devices.user_id = EXCLUDED.user_id
+		RETURNING device_id`,
+		userID, req.DeviceName, req.DeviceFingerprint, req.PkDevice, req.PkDeviceSign,
+	).Scan(&deviceID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", "", err
+	}
+	return userID, deviceID, nil
+}
+
+// StartDevicePurger deletes, every interval, the enrollment requests that were rejected
+// or left pending for longer than pendingDeviceTTL
+func (h *Handler) StartDevicePurger(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			result, err := h.DB.Exec(`
+				DELETE FROM devices
+				WHERE status IN ('pending', 'rejected') AND created_at <= now() - ($1 * interval '1 second')`,
+				int(pendingDeviceTTL.Seconds()),
+			)
+			if err != nil {
+				log.Println("❌ Failed to purge enrollment requests:", err)
+				continue
+			}
+			if purged, _ := result.RowsAffected(); purged > 0 {
+				log.Printf("🗑️  Purged %d expired enrollment requests\n", purged)
+			}
+		}
+	}()
+}
+
+// ListPendingDevicesHandler returns the enrollment requests waiting for the master device,
+// expired ones left out
+func (h *Handler) ListPendingDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.requireMaster(w, r) {
+		return
+	}
+	userID := getUserID(r.Context())
+
+	rows, err := h.DB.Query(`
+		SELECT device_id, device_name, device_fingerprint, pk_device, COALESCE(pk_device_sign, ''), created_at
+		FROM devices
+		WHERE user_id = $1 AND status = 'pending' AND created_at > now() - ($2 * interval '1 second')
+		ORDER BY created_at`,
+		userID, int(pendingDeviceTTL.Seconds()),
+	)
+	if err != nil {
+		http.Error(w, "failed to fetch devices", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	devices := []models.PendingDeviceResponse{}
+	for rows.Next() {
+		var d models.PendingDeviceResponse
+		if err := rows.Scan(&d.DeviceID, &d.DeviceName, &d.DeviceFingerprint, &d.PkDevice, &d.PkDeviceSign, &d.CreatedAt); err != nil {
+			http.Error(w, "failed to read devices", http.StatusInternalServerError)
+			return
+		}
+		devices = append(devices, d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(devices)
+}
+
+// ApproveDeviceHandler activates a pending device. The master device uploads the vault
+// key sealed to the new device's pk_device (its envelope, see vault_keys), which the
+// device receives when it logs in.
+func (h *Handler) ApproveDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.requireMaster(w, r) {
+		return
+	}
+	userID := getUserID(r.Context())
+	deviceID := chi.URLParam(r, "deviceID")
+
+	var req models.ApproveDeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	if req.WrappedVaultKey == "" {
+		http.Error(w, "wrapped_vault_key is required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var vaultID string
+	err = tx.QueryRow(`
+		UPDATE devices SET status = 'active'
+		WHERE device_id::text = $1 AND user_id = $2 AND status = 'pending'
+			AND created_at > now() - ($3 * interval '1 second')
+		RETURNING (SELECT vault_id FROM vaults WHERE user_id = $2 AND is_default)`,
+		deviceID, userID, int(pendingDeviceTTL.Seconds()),
+	).Scan(&vaultID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "pending device not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to approve device", http.StatusInternalServerError)
+		return
+	}
+
+	if err := putVaultKey(tx, vaultID, deviceID, req.WrappedVaultKey, 0); err != nil {
+		http.Error(w, "failed to approve device", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to approve device", http.StatusInternalServerError)
+		return
+	}
+
+	h.publishUserEvent(getSessionID(r.Context()), userID, events.Event{Type: events.DeviceChanged, DeviceID: deviceID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RejectDeviceHandler declines a pending enrollment request
+func (h *Handler) RejectDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.requireMaster(w, r) {
+		return
+	}
+	userID := getUserID(r.Context())
+	deviceID := chi.URLParam(r, "deviceID")
+
+	result, err := h.DB.Exec(`
+		UPDATE devices SET status = 'rejected'
+		WHERE device_id::text = $1 AND user_id = $2 AND status = 'pending'`,
+		deviceID, userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to reject device", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "pending device not found", http.StatusNotFound)
+		return
+	}
+
+	h.publishUserEvent(getSessionID(r.Context()), userID, events.Event{Type: events.DeviceChanged, DeviceID: deviceID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListDevicesHandler returns the current user's active and pending devices
+func (h *Handler) ListDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	currentDeviceID := getDeviceID(r.Context())
+
+	rows, err := h.DB.Query(`
+		SELECT device_id, device_name, device_fingerprint, is_master, status, last_seen, created_at
+		FROM devices
+		WHERE user_id = $1 AND status IN ('active', 'pending')
+		ORDER BY is_master DESC, created_at`,
+		userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to fetch devices", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	devices := []models.DeviceResponse{}
+	for rows.Next() {
+		var d models.DeviceResponse
+		if err := rows.Scan(&d.DeviceID, &d.DeviceName, &d.DeviceFingerprint, &d.IsMaster, &d.Status, &d.LastSeen, &d.CreatedAt); err != nil {
+			http.Error(w, "failed to read devices", http.StatusInternalServerError)
+			return
+		}
+		d.Current = d.DeviceID == currentDeviceID
+		devices = append(devices, d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(devices)
+}
+
+// RenameDeviceHandler renames a device. A device can rename itself; the master device
+// can rename any of the user's devices.
+func (h *Handler) RenameDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	deviceID := chi.URLParam(r, "deviceID")
+
+	var req models.UpdateDeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	req.DeviceName = strings.TrimSpace(req.DeviceName)
+	if req.DeviceName == "" {
+		http.Error(w, "device_name is required", http.StatusBadRequest)
+		return
+	}
+
+	if deviceID != getDeviceID(r.Context()) && !h.requireMaster(w, r) {
+		return
+	}
+
+	result, err := h.DB.Exec(`
+		UPDATE devices SET device_name = $1
+		WHERE device_id::text = $2 AND user_id = $3 AND status IN ('active', 'pending')`,
+		req.DeviceName, deviceID, userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to rename device", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+
+	h.publishUserEvent(getSessionID(r.Context()), userID, events.Event{Type: events.DeviceChanged, DeviceID: deviceID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeDeviceHandler removes a device from the account. Its sessions are revoked, so its
+// tokens stop working immediately, and its vault key envelope and passkeys are deleted.
+// The device may still know the vault key, so the master device should then rotate it.
+// A device can revoke itself; the master device can revoke any other device.
+func (h *Handler) RevokeDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	deviceID := chi.URLParam(r, "deviceID")
+
+	if deviceID != getDeviceID(r.Context()) && !h.requireMaster(w, r) {
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var isMaster bool
+	err = tx.QueryRow(`
+		UPDATE devices SET status = 'revoked'
+		WHERE device_id::text = $1 AND user_id = $2 AND status = 'active'
+		RETURNING is_master`,
+		deviceID, userID,
+	).Scan(&isMaster)
+	if err == sql.ErrNoRows {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to revoke device", http.StatusInternalServerError)
+		return
+	}
+	if isMaster {
+		http.Error(w, "the master device can't be revoked", http.StatusForbidden)
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE sessions SET revoked_at = now()
+		WHERE device_id::text = $1 AND revoked_at IS NULL`,
+		deviceID,
+	)
+	if err != nil {
+		http.Error(w, "failed to revoke device", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(`DELETE FROM webauthn_credentials WHERE device_id::text = $1`, deviceID)
+	if err != nil {
+		http.Error(w, "failed to revoke device", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(`DELETE FROM vault_keys WHERE device_id::text = $1`, deviceID)
+	if err != nil {
+		http.Error(w, "failed to revoke device", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to revoke device", http.StatusInternalServerError)
+		return
+	}
+
+	h.publishUserEvent(getSessionID(r.Context()), userID, events.Event{Type: events.DeviceChanged, DeviceID: deviceID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateDeviceKeys checks the keys a new device submits. pk_device is an X25519 key
+// and the optional pk_device_sign an Ed25519 key; both are 32 bytes.
+func validateDeviceKeys(pkDevice, pkDeviceSign string) error {
+	if _, err := auth.DecodePublicKey(pkDevice); err != nil {
+		return fmt.Errorf("invalid pk_device: %w", err)
+	}
+	if pkDeviceSign != "" {
+		if _, err := auth.DecodePublicKey(pkDeviceSign); err != nil {
+			return fmt.Errorf("invalid pk_device_sign: %w", err)
+		}
+	}
+	return nil
+}
+
+// requireMaster writes 403 and returns false unless the request comes from the user's master device
+func (h *Handler) requireMaster(w http.ResponseWriter, r *http.Request) bool {
+	var isMaster bool
+	err := h.DB.QueryRow(`
+		SELECT is_master FROM devices WHERE device_id = $1 AND user_id = $2`,
+		getDeviceID(r.Context()), getUserID(r.Context()),
+	).Scan(&isMaster)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return false
+	}
+	if !isMaster {
+		http.Error(w, "only the master device can manage devices", http.StatusForbidden)
+		return false
+	}
+	return true
+}
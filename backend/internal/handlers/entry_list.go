@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"backend/pswd/internal/models"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry listing page sizes
+const (
+	defaultEntryPageSize = 100
+	maxEntryPageSize     = 500
+)
+
+// entrySortColumns maps the sort parameter to the column entries are ordered by
+var entrySortColumns = map[string]string{
+	"created_at": "e.created_at",
+	"updated_at": "e.updated_at",
+	"title":      "e.title",
+}
+
+// entryListQuery is a parsed entry listing request
+type entryListQuery struct {
+	paged          bool // limit or cursor given; otherwise every entry, as a bare array
+	limit          int
+	sort           string
+	desc           bool
+	entryType      string
+	updatedAfter   *time.Time
+	includeDeleted bool
+	vaultID        string
+	after          *entryCursor
+}
+
+// entryCursor points at the last entry of a page: its sort key and, to break ties, its ID
+type entryCursor struct {
+	Sort    string `json:"s"`
+	Desc    bool   `json:"d"`
+	Value   string `json:"v"`
+	EntryID string `json:"id"`
+}
+
+// parseEntryListQuery reads limit, cursor, sort, order, entry_type, updated_after,
+// include_deleted and vault_id from the query string
+func parseEntryListQuery(r *http.Request) (entryListQuery, error) {
+	params := r.URL.Query()
+	q := entryListQuery{
+		limit:          defaultEntryPageSize,
+		sort:           "created_at",
+		desc:           true,
+		entryType:      params.Get("entry_type"),
+		includeDeleted: params.Get("include_deleted") == "true",
+		vaultID:        params.Get("vault_id"),
+		paged:          params.Has("limit") || params.Has("cursor"),
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxEntryPageSize {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxEntryPageSize)
+		}
+		q.limit = n
+	}
+
+	if sort := params.Get("sort"); sort != "" {
+		if _, ok := entrySortColumns[sort]; !ok {
+			return q, errors.New("sort must be created_at, updated_at or title")
+		}
+		q.sort = sort
+		// Titles read naturally A to Z; dates newest first
+		q.desc = sort != "title"
+	}
+
+	switch params.Get("order") {
+	case "":
+	case "asc":
+		q.desc = false
+	case "desc":
+		q.desc = true
+	default:
+		return q, errors.New("order must be asc or desc")
+	}
+
+	if updatedAfter := params.Get("updated_after"); updatedAfter != "" {
+		t, err := time.Parse(time.RFC3339Nano, updatedAfter)
+		if err != nil {
+			return q, errors.New("updated_after must be an RFC 3339 timestamp")
+		}
+		t = t.UTC()
+		q.updatedAfter = &t
+	}
+
+	if cursor := params.Get("cursor"); cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return q, errors.New("invalid cursor")
+		}
+		var c entryCursor
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return q, errors.New("invalid cursor")
+		}
+		if c.Sort != q.sort || c.Desc != q.desc {
+			return q, errors.New("cursor was issued for a different sort order")
+		}
+		q.after = &c
+	}
+
+	return q, nil
+}
+
+// nextCursor encodes the cursor for the page following entry
+func (q entryListQuery) nextCursor(entry models.VaultEntryResponse) string {
+	c := entryCursor{Sort: q.sort, Desc: q.desc, EntryID: entry.EntryID}
+	switch q.sort {
+	case "created_at":
+		c.Value = entry.CreatedAt.Format(time.RFC3339Nano)
+	case "updated_at":
+		c.Value = entry.UpdatedAt.Format(time.RFC3339Nano)
+	case "title":
+		c.Value = entry.Title
+	}
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// GetVaultEntriesHandler lists a vault's entries, a page at a time when limit or cursor is
+// given. Entries in the trash are left out unless include_deleted=true.
+func (h *Handler) GetVaultEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := parseEntryListQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.vaultID = getVaultID(r.Context())
+
+	h.listEntries(w, r, q)
+}
+
+// ListEntriesHandler lists entries across every vault the user can reach, or one of
+// them with vault_id, a page at a time when limit or cursor is given
+func (h *Handler) ListEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := parseEntryListQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.listEntries(w, r, q)
+}
+
+// listEntries runs an entry listing for the current user and writes the page. Without
+// limit or cursor it writes every entry as a bare array, the shape listings had before
+// they were paged.
+func (h *Handler) listEntries(w http.ResponseWriter, r *http.Request, q entryListQuery) {
+	args := []any{getUserID(r.Context())}
+	where := []string{`e.vault_id IN (SELECT vault_id FROM vault_access WHERE user_id = $1)`}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if q.vaultID != "" {
+		where = append(where, "e.vault_id::text = "+arg(q.vaultID))
+	}
+	if !q.includeDeleted {
+		where = append(where, "e.deleted_at IS NULL")
+	}
+	if q.entryType != "" {
+		where = append(where, "e.entry_type = "+arg(q.entryType))
+	}
+	if q.updatedAfter != nil {
+		where = append(where, "e.updated_at > "+arg(*q.updatedAfter))
+	}
+
+	column := entrySortColumns[q.sort]
+	direction, compare := "ASC", ">"
+	if q.desc {
+		direction, compare = "DESC", "<"
+	}
+	if q.after != nil {
+		var value any = q.after.Value
+		if q.sort != "title" {
+			t, err := time.Parse(time.RFC3339Nano, q.after.Value)
+			if err != nil {
+				http.Error(w, "invalid cursor", http.StatusBadRequest)
+				return
+			}
+			value = t
+		}
+		where = append(where, fmt.Sprintf("(%s, e.entry_id::text) %s (%s, %s)",
+			column, compare, arg(value), arg(q.after.EntryID)))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT e.entry_id, e.vault_id, e.title, e.encrypted_data, e.entry_type, e.revision,
+			e.created_at, e.updated_at, e.deleted_at
+		FROM vault_entries e
+		WHERE %s
+		ORDER BY %s %s, e.entry_id::text %s`,
+		strings.Join(where, " AND "), column, direction, direction)
+	if q.paged {
+		// One extra row tells whether there is a next page
+		query += "\n\t\tLIMIT " + arg(q.limit+1)
+	}
+
+	rows, err := h.DB.Query(query, args...)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	page := models.VaultEntryPage{Entries: []models.VaultEntryResponse{}}
+	for rows.Next() {
+		var entry models.VaultEntryResponse
+		var encryptedData []byte
+		err := rows.Scan(&entry.EntryID, &entry.VaultID, &entry.Title, &encryptedData,
+			&entry.EntryType, &entry.Revision, &entry.CreatedAt, &entry.UpdatedAt, &entry.DeletedAt)
+		if err != nil {
+			http.Error(w, "failed to read entries", http.StatusInternalServerError)
+			return
+		}
+		entry.EncryptedData = base64.StdEncoding.EncodeToString(encryptedData)
+		page.Entries = append(page.Entries, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !q.paged {
+		json.NewEncoder(w).Encode(page.Entries)
+		return
+	}
+
+	if len(page.Entries) > q.limit {
+		page.Entries = page.Entries[:q.limit]
+		page.NextCursor = q.nextCursor(page.Entries[q.limit-1])
+	}
+	json.NewEncoder(w).Encode(page)
+}
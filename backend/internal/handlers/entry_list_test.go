@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"backend/pswd/internal/models"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func encodeCursor(c entryCursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func TestParseEntryListQuery(t *testing.T) {
+	titleCursor := encodeCursor(entryCursor{Sort: "title", Value: "b", EntryID: "x"})
+	createdCursor := encodeCursor(entryCursor{Sort: "created_at", Desc: true, Value: "2025-06-01T12:00:00Z", EntryID: "x"})
+
+	tests := []struct {
+		query string
+		err   string // "" when the query is accepted
+		limit int
+		sort  string
+		desc  bool
+		paged bool
+	}{
+		{"", "", defaultEntryPageSize, "created_at", true, false},
+		{"limit=1", "", 1, "created_at", true, true},
+		{"limit=500", "", 500, "created_at", true, true},
+		{"limit=0", "limit must be", 0, "", false, false},
+		{"limit=501", "limit must be", 0, "", false, false},
+		{"limit=-1", "limit must be", 0, "", false, false},
+		{"limit=ten", "limit must be", 0, "", false, false},
+		{"limit=", "", defaultEntryPageSize, "created_at", true, true},
+		{"sort=title", "", defaultEntryPageSize, "title", false, false},
+		{"sort=title&order=desc", "", defaultEntryPageSize, "title", true, false},
+		{"sort=updated_at&order=asc", "", defaultEntryPageSize, "updated_at", false, false},
+		{"sort=name", "sort must be", 0, "", false, false},
+		{"order=up", "order must be", 0, "", false, false},
+		{"updated_after=yesterday", "updated_after must be", 0, "", false, false},
+		{"cursor=" + createdCursor, "", defaultEntryPageSize, "created_at", true, true},
+		{"sort=title&cursor=" + titleCursor, "", defaultEntryPageSize, "title", false, true},
+		{"cursor=" + titleCursor, "different sort order", 0, "", false, false},
+		{"order=asc&cursor=" + createdCursor, "different sort order", 0, "", false, false},
+		{"sort=title&order=desc&cursor=" + titleCursor, "different sort order", 0, "", false, false},
+		{"cursor=not*base64", "invalid cursor", 0, "", false, false},
+		{"cursor=" + base64.RawURLEncoding.EncodeToString([]byte("[1]")), "invalid cursor", 0, "", false, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/entries?"+tt.query, nil)
+		q, err := parseEntryListQuery(r)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: got error %v, want %q", tt.query, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.query, err)
+			continue
+		}
+		if q.limit != tt.limit || q.sort != tt.sort || q.desc != tt.desc || q.paged != tt.paged {
+			t.Errorf("%q: got limit %d, sort %s, desc %v, paged %v", tt.query, q.limit, q.sort, q.desc, q.paged)
+		}
+	}
+}
+
+// entryListStore fakes vault_entries for listings sorted by created_at, newest first,
+// applying the cursor and limit the way the query does
+type entryListStore struct {
+	entries []models.VaultEntryResponse
+	queries []string
+}
+
+func (s *entryListStore) handle(query string, args []driver.Value) (fakeResult, error) {
+	if !strings.Contains(query, "ORDER BY e.created_at DESC, e.entry_id::text DESC") {
+		return fakeResult{}, fmt.Errorf("unexpected query: %s", query)
+	}
+	s.queries = append(s.queries, query)
+
+	// Args are the user ID, then the cursor's sort key and entry ID, then the limit
+	entries := append([]models.VaultEntryResponse(nil), s.entries...)
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].CreatedAt.Equal(entries[j].CreatedAt) {
+			return entries[i].CreatedAt.After(entries[j].CreatedAt)
+		}
+		return entries[i].EntryID > entries[j].EntryID
+	})
+	if strings.Contains(query, "e.entry_id::text) <") {
+		at, id := args[1].(time.Time), args[2].(string)
+		for len(entries) > 0 && (entries[0].CreatedAt.After(at) ||
+			entries[0].CreatedAt.Equal(at) && entries[0].EntryID >= id) {
+			entries = entries[1:]
+		}
+	}
+	if strings.Contains(query, "LIMIT") {
+		if limit := int(args[len(args)-1].(int64)); len(entries) > limit {
+			entries = entries[:limit]
+		}
+	}
+
+	result := fakeResult{columns: []string{"entry_id", "vault_id", "title", "encrypted_data",
+		"entry_type", "revision", "created_at", "updated_at", "deleted_at"}}
+	for _, e := range entries {
+		result.rows = append(result.rows, []driver.Value{
+			e.EntryID, "vault", e.Title, []byte("data"), "login", int64(1), e.CreatedAt, e.CreatedAt, nil,
+		})
+	}
+	return result, nil
+}
+
+func listEntriesRequest(t *testing.T, h *Handler, params url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/api/entries?"+params.Encode(), nil)
+	r = r.WithContext(setUserID(r.Context(), "user"))
+	w := httptest.NewRecorder()
+	h.ListEntriesHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s: got status %d: %s", params.Encode(), w.Code, w.Body)
+	}
+	return w
+}
+
+func TestListEntriesPagesThroughTies(t *testing.T) {
+	base := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := &entryListStore{}
+	// Five entries share a created_at, so pages have to split them by entry ID
+	for i, offset := range []time.Duration{time.Hour, 0, 0, 0, 0, 0, -time.Hour} {
+		store.entries = append(store.entries, models.VaultEntryResponse{
+			EntryID:   fmt.Sprintf("entry-%d", i),
+			Title:     fmt.Sprintf("title %d", i),
+			CreatedAt: base.Add(offset),
+		})
+	}
+	h := &Handler{DB: openFakeDB(t, store.handle)}
+
+	var got []string
+	params := url.Values{"limit": {"2"}}
+	for pages := 0; ; pages++ {
+		if pages > len(store.entries) {
+			t.Fatal("listing never reached the last page")
+		}
+		var page models.VaultEntryPage
+		if err := json.NewDecoder(listEntriesRequest(t, h, params).Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Entries) > 2 {
+			t.Fatalf("page has %d entries, limit is 2", len(page.Entries))
+		}
+		for _, e := range page.Entries {
+			got = append(got, e.EntryID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		params.Set("cursor", page.NextCursor)
+	}
+
+	want := []string{"entry-0", "entry-5", "entry-4", "entry-3", "entry-2", "entry-1", "entry-6"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got entries %v, want %v", got, want)
+	}
+}
+
+func TestListEntriesWithoutPaging(t *testing.T) {
+	store := &entryListStore{}
+	for i := 0; i < defaultEntryPageSize+1; i++ {
+		store.entries = append(store.entries, models.VaultEntryResponse{
+			EntryID:   fmt.Sprintf("entry-%03d", i),
+			CreatedAt: time.Date(2025, 6, 1, 12, 0, i, 0, time.UTC),
+		})
+	}
+	h := &Handler{DB: openFakeDB(t, store.handle)}
+
+	// Clients from before paging get every entry as a bare array
+	var entries []models.VaultEntryResponse
+	if err := json.NewDecoder(listEntriesRequest(t, h, url.Values{}).Body).Decode(&entries); err != nil {
+		t.Fatalf("response is not an array: %v", err)
+	}
+	if len(entries) != len(store.entries) {
+		t.Errorf("got %d entries, want %d", len(entries), len(store.entries))
+	}
+	if strings.Contains(store.queries[0], "LIMIT") {
+		t.Error("unpaged listing was limited")
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"backend/pswd/internal/events"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// eventsHeartbeat is how often an idle event stream gets a comment line, which also
+// rechecks that its session is still valid
+const eventsHeartbeat = 25 * time.Second
+
+// EventsHandler streams the user's events as Server-Sent Events until the client goes
+// away, its session is revoked or it falls too far behind; the client should then catch
+// up with GET /api/sync and reconnect. Changes made by this session aren't echoed.
+func (h *Handler) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	deviceID := getDeviceID(r.Context())
+	sessionID := getSessionID(r.Context())
+
+	flusher, ok := w.(http.Flusher)
+	if !ok || h.Events == nil {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	stream, unsubscribe := h.Events.Subscribe(userID, sessionID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Keep nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case e, ok := <-stream:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			flusher.Flush()
+
+		case <-heartbeat.C:
+			session, err := h.loadSession(sessionID, userID, deviceID)
+			if err != nil || !session.active || !session.deviceActive {
+				return
+			}
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// publishUserEvent sends an event to a user's sessions, except sessionID's
+func (h *Handler) publishUserEvent(sessionID, userID string, e events.Event) {
+	if h.Events == nil {
+		return
+	}
+	e.UserID = userID
+	e.SessionID = sessionID
+	h.Events.Publish(e)
+}
+
+// publishVaultEvent sends an event to everyone who can reach a vault, except the session
+// that caused it. Call it once the change is committed.
+func (h *Handler) publishVaultEvent(sessionID string, e events.Event) {
+	if h.Events == nil {
+		return
+	}
+
+	rows, err := h.DB.Query(`SELECT DISTINCT user_id FROM vault_access WHERE vault_id = $1`, e.VaultID)
+	if err != nil {
+		log.Printf("failed to publish %s for vault %s: %v", e.Type, e.VaultID, err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			log.Printf("failed to publish %s for vault %s: %v", e.Type, e.VaultID, err)
+			return
+		}
+		h.publishUserEvent(sessionID, userID, e)
+	}
+}
@@ -1,10 +1,52 @@
 package handlers
 
 import (
+	"backend/pswd/internal/events"
 	"database/sql"
+	"time"
 )
 
 // Handler holds dependencies for HTTP handlers
 type Handler struct {
-	DB *sql.DB
+	DB         *sql.DB
+	Require2FA bool // Every user must enroll a second factor before using the API
+
+	// MasterRecoveryDelay is how long a recovery-key master recovery waits before it
+	// can be completed, giving the user's devices time to cancel it
+	MasterRecoveryDelay time.Duration
+
+	// EntryRevisionRetention is how many previous versions are kept per vault entry
+	EntryRevisionRetention int
+
+	// TrashRetention is how long deleted vault entries stay restorable before the trash
+	// purger removes them
+	TrashRetention time.Duration
+
+	// TombstoneRetention is how long /api/sync remembers entries deleted for good; older
+	// cursors have to sync from scratch
+	TombstoneRetention time.Duration
+
+	// Events delivers change notifications to the user's other sessions (see EventsHandler)
+	Events events.Broker
+
+	// Now is the clock TOTP codes are checked against; nil means time.Now
+	Now func() time.Time
+
+	lastSeen lastSeenTracker
+}
+
+// now returns the current time from h.Now, or the system clock
+func (h *Handler) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+	return time.Now()
+}
+
+// dbExecutor is satisfied by both *sql.DB and *sql.Tx, so helpers can run
+// either standalone or as part of a larger transaction
+type dbExecutor interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
 }
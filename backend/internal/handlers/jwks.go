@@ -0,0 +1,14 @@
+package handlers
+
+import (
+	"backend/pswd/internal/auth"
+	"encoding/json"
+	"net/http"
+)
+
+// JWKSHandler publishes the public token verification keys for other services
+func (h *Handler) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(auth.JWKS())
+}
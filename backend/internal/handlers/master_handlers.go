@@ -0,0 +1,612 @@
+package handlers
+
+import (
+	"backend/pswd/internal/auth"
+	"backend/pswd/internal/models"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// MasterTransferTTL is how long the target device has to accept a master transfer
+const MasterTransferTTL = 15 * time.Minute
+
+// minNonceLength is the shortest client-chosen nonce accepted in signed operations
+const minNonceLength = 16
+
+// Account notification kinds
+const (
+	notifyMasterTransferred       = "master_transferred"
+	notifyRecoveryKeyChanged      = "recovery_key_changed"
+	notifyMasterRecoveryStarted   = "master_recovery_started"
+	notifyMasterRecoveryCancelled = "master_recovery_cancelled"
+	notifyMasterRecovered         = "master_recovered"
+	notifyVaultKeyRotated         = "vault_key_rotated"
+	notifyVaultShared             = "vault_shared"
+	notifyPasswordChanged         = "password_changed"
+	notifyPasskeyAdded            = "passkey_added"
+	notifyPasskeyRemoved          = "passkey_removed"
+)
+
+// CreateMasterTransferHandler starts handing the master role to another active device.
+// The master device signs the transfer with its pk_device_sign; the target device
+// must then accept it with its own signature (see AcceptMasterTransferHandler).
+func (h *Handler) CreateMasterTransferHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.requireMaster(w, r) {
+		return
+	}
+	userID := getUserID(r.Context())
+	deviceID := getDeviceID(r.Context())
+
+	var req models.MasterTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Nonce) < minNonceLength {
+		http.Error(w, "nonce must be at least 16 characters", http.StatusBadRequest)
+		return
+	}
+	if req.TargetDeviceID == deviceID {
+		http.Error(w, "target device is already the master", http.StatusBadRequest)
+		return
+	}
+
+	var masterKey, targetKey sql.NullString
+	err := h.DB.QueryRow(`
+		SELECT m.pk_device_sign, t.pk_device_sign
+		FROM devices m
+		JOIN devices t ON t.user_id = m.user_id
+		WHERE m.device_id = $1 AND m.user_id = $2
+		AND t.device_id::text = $3 AND t.status = 'active'`,
+		deviceID, userID, req.TargetDeviceID,
+	).Scan(&masterKey, &targetKey)
+	if err == sql.ErrNoRows {
+		http.Error(w, "target device not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if !masterKey.Valid || !targetKey.Valid {
+		http.Error(w, "both devices need a pk_device_sign to transfer the master role", http.StatusBadRequest)
+		return
+	}
+
+	message := auth.MasterTransferMessage(userID, deviceID, req.TargetDeviceID, req.Nonce)
+	if !auth.VerifySignature(masterKey.String, message, req.Signature) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Only the latest transfer can be accepted
+	_, err = tx.Exec(`
+		UPDATE master_transfers SET cancelled_at = now()
+		WHERE user_id = $1 AND completed_at IS NULL AND cancelled_at IS NULL`,
+		userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to create transfer", http.StatusInternalServerError)
+		return
+	}
+
+	resp := models.MasterTransferResponse{
+		FromDeviceID: deviceID,
+		ToDeviceID:   req.TargetDeviceID,
+		Nonce:        req.Nonce,
+	}
+	err = tx.QueryRow(`
+		INSERT INTO master_transfers (user_id, from_device_id, to_device_id, nonce, from_signature, expires_at)
+		VALUES ($1, $2, $3, $4, $5, now() + ($6 * interval '1 second'))
+		ON CONFLICT (nonce) DO NOTHING
+		RETURNING transfer_id, created_at, expires_at`,
+		userID, deviceID, req.TargetDeviceID, req.Nonce, req.Signature, int64(MasterTransferTTL.Seconds()),
+	).Scan(&resp.TransferID, &resp.CreatedAt, &resp.ExpiresAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "nonce already used", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to create transfer", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to create transfer", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ListMasterTransfersHandler returns the user's pending master transfers, so the
+// target device can find the one it should accept
+func (h *Handler) ListMasterTransfersHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+
+	rows, err := h.DB.Query(`
+		SELECT transfer_id, from_device_id, to_device_id, nonce, created_at, expires_at, completed_at
+		FROM master_transfers
+		WHERE user_id = $1 AND completed_at IS NULL AND cancelled_at IS NULL AND expires_at > now()
+		ORDER BY created_at DESC`,
+		userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to fetch transfers", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	transfers := []models.MasterTransferResponse{}
+	for rows.Next() {
+		var t models.MasterTransferResponse
+		if err := rows.Scan(&t.TransferID, &t.FromDeviceID, &t.ToDeviceID, &t.Nonce, &t.CreatedAt, &t.ExpiresAt, &t.CompletedAt); err != nil {
+			http.Error(w, "failed to read transfers", http.StatusInternalServerError)
+			return
+		}
+		transfers = append(transfers, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transfers)
+}
+
+// AcceptMasterTransferHandler completes a transfer from the target device, which
+// signs the transfer ID and nonce with its pk_device_sign
+func (h *Handler) AcceptMasterTransferHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	deviceID := getDeviceID(r.Context())
+	transferID := chi.URLParam(r, "transferID")
+
+	var req models.MasterTransferAcceptRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var resp models.MasterTransferResponse
+	var targetKey sql.NullString
+	err = tx.QueryRow(`
+		SELECT t.transfer_id, t.from_device_id, t.to_device_id, t.nonce, t.created_at, t.expires_at, d.pk_device_sign
+		FROM master_transfers t
+		JOIN devices d ON d.device_id = t.to_device_id
+		WHERE t.transfer_id::text = $1 AND t.user_id = $2 AND t.to_device_id = $3
+		AND t.completed_at IS NULL AND t.cancelled_at IS NULL AND t.expires_at > now()
+		FOR UPDATE OF t`,
+		transferID, userID, deviceID,
+	).Scan(&resp.TransferID, &resp.FromDeviceID, &resp.ToDeviceID, &resp.Nonce, &resp.CreatedAt, &resp.ExpiresAt, &targetKey)
+	if err == sql.ErrNoRows {
+		http.Error(w, "transfer not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	message := auth.MasterTransferAcceptMessage(resp.TransferID, resp.Nonce)
+	if !targetKey.Valid || !auth.VerifySignature(targetKey.String, message, req.Signature) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	// Swap the role, but only if the initiating device is still the active master
+	result, err := tx.Exec(`
+		UPDATE devices SET is_master = (device_id = $3)
+		WHERE user_id = $1 AND device_id IN ($2, $3)
+		AND EXISTS (
+			SELECT 1 FROM devices m
+			WHERE m.device_id = $2 AND m.is_master AND m.status = 'active'
+		)`,
+		userID, resp.FromDeviceID, resp.ToDeviceID,
+	)
+	if err != nil {
+		http.Error(w, "failed to transfer master role", http.StatusInternalServerError)
+		return
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected != 2 {
+		http.Error(w, "the initiating device is no longer the master", http.StatusConflict)
+		return
+	}
+
+	err = tx.QueryRow(`
+		UPDATE master_transfers SET completed_at = now(), to_signature = $1
+		WHERE transfer_id = $2
+		RETURNING completed_at`,
+		req.Signature, resp.TransferID,
+	).Scan(&resp.CompletedAt)
+	if err != nil {
+		http.Error(w, "failed to transfer master role", http.StatusInternalServerError)
+		return
+	}
+
+	if err := notify(tx, userID, notifyMasterTransferred, resp.ToDeviceID, resp.TransferID); err != nil {
+		http.Error(w, "failed to transfer master role", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to transfer master role", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// CancelMasterTransferHandler withdraws (master) or declines (target) a pending transfer
+func (h *Handler) CancelMasterTransferHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	deviceID := getDeviceID(r.Context())
+	transferID := chi.URLParam(r, "transferID")
+
+	result, err := h.DB.Exec(`
+		UPDATE master_transfers SET cancelled_at = now()
+		WHERE transfer_id::text = $1 AND user_id = $2 AND $3 IN (from_device_id, to_device_id)
+		AND completed_at IS NULL AND cancelled_at IS NULL`,
+		transferID, userID, deviceID,
+	)
+	if err != nil {
+		http.Error(w, "failed to cancel transfer", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "transfer not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetRecoveryKeyHandler sets or replaces the account recovery key from the master device.
+// Recoveries started with the previous key are cancelled.
+func (h *Handler) SetRecoveryKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.requireMaster(w, r) {
+		return
+	}
+	userID := getUserID(r.Context())
+
+	var req models.RecoveryKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := auth.DecodePublicKey(req.PkRecovery); err != nil {
+		http.Error(w, "pk_recovery must be an Ed25519 public key", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE users SET pk_recovery = $1, recovery_wrapped_vault_key = NULLIF($2, '')
+		WHERE user_id = $3`,
+		req.PkRecovery, req.RecoveryWrappedVaultKey, userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to set recovery key", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE master_recoveries SET cancelled_at = now()
+		WHERE user_id = $1 AND completed_at IS NULL AND cancelled_at IS NULL`,
+		userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to set recovery key", http.StatusInternalServerError)
+		return
+	}
+
+	if err := notify(tx, userID, notifyRecoveryKeyChanged, getDeviceID(r.Context()), ""); err != nil {
+		http.Error(w, "failed to set recovery key", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to set recovery key", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StartMasterRecoveryHandler starts promoting a device to master when the master is lost.
+// The request is signed with the account recovery key and only takes effect after
+// MasterRecoveryDelay, during which every device sees a notification and can cancel it.
+// The device must already be enrolled (active or pending).
+func (h *Handler) StartMasterRecoveryHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.MasterRecoveryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Nonce) < minNonceLength {
+		http.Error(w, "nonce must be at least 16 characters", http.StatusBadRequest)
+		return
+	}
+
+	var userID, deviceID string
+	var pkRecovery sql.NullString
+	err := h.DB.QueryRow(`
+		SELECT u.user_id, u.pk_recovery, d.device_id
+		FROM users u
+		JOIN devices d ON d.user_id = u.user_id
+		WHERE u.username = $1 AND d.device_fingerprint = $2
+		AND d.status IN ('active', 'pending') AND NOT d.is_master`,
+		req.Username, req.DeviceFingerprint,
+	).Scan(&userID, &pkRecovery, &deviceID)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Unknown users, unknown devices and accounts without a recovery key all fail here
+	message := auth.MasterRecoveryMessage(req.Username, req.DeviceFingerprint, req.Nonce)
+	if !pkRecovery.Valid || !auth.VerifySignature(pkRecovery.String, message, req.Signature) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	resp := models.MasterRecoveryResponse{DeviceID: deviceID}
+	err = tx.QueryRow(`
+		INSERT INTO master_recoveries (user_id, device_id, nonce, signature, effective_at)
+		SELECT $1, $2, $3, $4, now() + ($5 * interval '1 second')
+		WHERE NOT EXISTS (
+			SELECT 1 FROM master_recoveries
+			WHERE user_id = $1 AND completed_at IS NULL AND cancelled_at IS NULL
+		)
+		ON CONFLICT (nonce) DO NOTHING
+		RETURNING recovery_id, created_at, effective_at`,
+		userID, deviceID, req.Nonce, req.Signature, int64(h.MasterRecoveryDelay.Seconds()),
+	).Scan(&resp.RecoveryID, &resp.CreatedAt, &resp.EffectiveAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "a recovery is already in progress or the nonce was used", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to start recovery", http.StatusInternalServerError)
+		return
+	}
+
+	if err := notify(tx, userID, notifyMasterRecoveryStarted, deviceID, resp.RecoveryID); err != nil {
+		http.Error(w, "failed to start recovery", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to start recovery", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// CompleteMasterRecoveryHandler promotes the recovering device to master once the
+// waiting period is over. The device is activated if it was still pending, and
+// receives the recovery-wrapped vault key.
+func (h *Handler) CompleteMasterRecoveryHandler(w http.ResponseWriter, r *http.Request) {
+	recoveryID := chi.URLParam(r, "recoveryID")
+
+	var req models.MasterRecoveryCompleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var userID string
+	var due bool
+	var pkRecovery, wrappedVaultKey sql.NullString
+	resp := models.MasterRecoveryResponse{}
+	err = tx.QueryRow(`
+		SELECT mr.recovery_id, mr.user_id, mr.device_id, mr.created_at, mr.effective_at, mr.effective_at <= now(),
+			u.pk_recovery, u.recovery_wrapped_vault_key
+		FROM master_recoveries mr
+		JOIN users u ON u.user_id = mr.user_id
+		WHERE mr.recovery_id::text = $1 AND mr.completed_at IS NULL AND mr.cancelled_at IS NULL
+		FOR UPDATE OF mr`,
+		recoveryID,
+	).Scan(&resp.RecoveryID, &userID, &resp.DeviceID, &resp.CreatedAt, &resp.EffectiveAt, &due, &pkRecovery, &wrappedVaultKey)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	message := auth.MasterRecoveryCompleteMessage(recoveryID)
+	if !pkRecovery.Valid || !auth.VerifySignature(pkRecovery.String, message, req.Signature) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	if !due {
+		http.Error(w, "recovery waiting period is not over until "+resp.EffectiveAt.UTC().Format(time.RFC3339), http.StatusConflict)
+		return
+	}
+
+	_, err = tx.Exec(`UPDATE devices SET is_master = false WHERE user_id = $1 AND is_master`, userID)
+	if err != nil {
+		http.Error(w, "failed to complete recovery", http.StatusInternalServerError)
+		return
+	}
+
+	result, err := tx.Exec(`
+		UPDATE devices SET is_master = true, status = 'active'
+		WHERE device_id = $1 AND user_id = $2 AND status IN ('active', 'pending')`,
+		resp.DeviceID, userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to complete recovery", http.StatusInternalServerError)
+		return
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "the recovering device was removed", http.StatusConflict)
+		return
+	}
+
+	err = tx.QueryRow(`
+		UPDATE master_recoveries SET completed_at = now()
+		WHERE recovery_id = $1
+		RETURNING completed_at`,
+		resp.RecoveryID,
+	).Scan(&resp.CompletedAt)
+	if err != nil {
+		http.Error(w, "failed to complete recovery", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE master_transfers SET cancelled_at = now()
+		WHERE user_id = $1 AND completed_at IS NULL AND cancelled_at IS NULL`,
+		userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to complete recovery", http.StatusInternalServerError)
+		return
+	}
+
+	if err := notify(tx, userID, notifyMasterRecovered, resp.DeviceID, resp.RecoveryID); err != nil {
+		http.Error(w, "failed to complete recovery", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to complete recovery", http.StatusInternalServerError)
+		return
+	}
+
+	resp.RecoveryWrappedVaultKey = wrappedVaultKey.String
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// CancelMasterRecoveryHandler lets any active device stop a recovery it doesn't recognize
+func (h *Handler) CancelMasterRecoveryHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	recoveryID := chi.URLParam(r, "recoveryID")
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var cancelledID string
+	err = tx.QueryRow(`
+		UPDATE master_recoveries SET cancelled_at = now()
+		WHERE recovery_id::text = $1 AND user_id = $2
+		AND completed_at IS NULL AND cancelled_at IS NULL
+		RETURNING recovery_id`,
+		recoveryID, userID,
+	).Scan(&cancelledID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "recovery not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to cancel recovery", http.StatusInternalServerError)
+		return
+	}
+
+	if err := notify(tx, userID, notifyMasterRecoveryCancelled, getDeviceID(r.Context()), cancelledID); err != nil {
+		http.Error(w, "failed to cancel recovery", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to cancel recovery", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListNotificationsHandler returns the user's most recent account notifications
+func (h *Handler) ListNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+
+	rows, err := h.DB.Query(`
+		SELECT notification_id, user_id, kind, device_id, related_id, created_at
+		FROM account_notifications
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT 50`,
+		userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to fetch notifications", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	notifications := []models.AccountNotification{}
+	for rows.Next() {
+		var n models.AccountNotification
+		if err := rows.Scan(&n.NotificationID, &n.UserID, &n.Kind, &n.DeviceID, &n.RelatedID, &n.CreatedAt); err != nil {
+			http.Error(w, "failed to read notifications", http.StatusInternalServerError)
+			return
+		}
+		notifications = append(notifications, n)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifications)
+}
+
+// notify records an account notification. deviceID and relatedID may be empty.
+func notify(db dbExecutor, userID, kind, deviceID, relatedID string) error {
+	_, err := db.Exec(`
+		INSERT INTO account_notifications (user_id, kind, device_id, related_id)
+		VALUES ($1, $2, NULLIF($3, '')::uuid, NULLIF($4, '')::uuid)`,
+		userID, kind, deviceID, relatedID,
+	)
+	return err
+}
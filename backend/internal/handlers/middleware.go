@@ -2,9 +2,41 @@ package handlers
 
 import (
 	"backend/pswd/internal/auth"
+	"database/sql"
+	"log"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
+// lastSeenInterval throttles how often a device's last_seen is written
+const lastSeenInterval = time.Minute
+
+// lastSeenTracker remembers when each device's last_seen was last written,
+// so busy devices don't cost a write per request
+type lastSeenTracker struct {
+	mu      sync.Mutex
+	written map[string]time.Time
+}
+
+// due reports whether deviceID's last_seen should be written now, and if so
+// records the write
+func (t *lastSeenTracker) due(deviceID string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.written == nil {
+		t.written = make(map[string]time.Time)
+	}
+	if now.Sub(t.written[deviceID]) < lastSeenInterval {
+		return false
+	}
+	t.written[deviceID] = now
+	return true
+}
+
 // AuthMiddleware validates JWT tokens from cookie or header
 func (h *Handler) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -16,16 +48,155 @@ func (h *Handler) AuthMiddleware(next http.Handler) http.Handler {
 		}
 
 		claims, err := auth.ValidateToken(tokenString)
-		if err != nil {
+		if err != nil || claims.ID == "" {
 			http.Error(w, "invalid token", http.StatusUnauthorized)
 			return
 		}
 
+		// Reject tokens whose session was revoked (logout, logout everywhere, per-session revoke)
+		// or whose device was revoked
+		session, err := h.loadSession(claims.ID, claims.UserID, claims.DeviceID)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		if !session.deviceActive {
+			http.Error(w, "device revoked", http.StatusUnauthorized)
+			return
+		}
+		if !session.active {
+			http.Error(w, "session revoked", http.StatusUnauthorized)
+			return
+		}
+
+		if h.lastSeen.due(claims.DeviceID, time.Now()) {
+			_, err := h.DB.Exec(`UPDATE devices SET last_seen = now() WHERE device_id = $1`, claims.DeviceID)
+			if err != nil {
+				log.Printf("failed to update last_seen for device %s: %v", claims.DeviceID, err)
+			}
+		}
+
 		// Add claims to context
 		ctx := r.Context()
 		ctx = setUserID(ctx, claims.UserID)
 		ctx = setDeviceID(ctx, claims.DeviceID)
+		ctx = setSessionID(ctx, claims.ID)
+		ctx = setMFAEnabled(ctx, session.mfaEnabled)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireTwoFactor blocks users who haven't enrolled a second factor when the
+// server requires 2FA for everyone. Routes needed to enroll must stay outside it.
+func (h *Handler) RequireTwoFactor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.Require2FA && !getMFAEnabled(r.Context()) {
+			http.Error(w, "two-factor authentication enrollment required", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// VaultAccess resolves the vault a request works on and stores it in the context with
+// the user's effective role in it: the {vaultID} route parameter, or the user's default
+// vault on the older /api/vault routes. The role is the strongest of ownership, direct
+// membership and team grants (see the vault_access view). Vaults the user can't access
+// are reported as not found.
+func (h *Handler) VaultAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := getUserID(r.Context())
+
+		var vaultID, role string
+		var err error
+		if id := chi.URLParam(r, "vaultID"); id != "" {
+			err = h.DB.QueryRow(`
+				SELECT vault_id, role FROM vault_access
+				WHERE vault_id::text = $1 AND user_id = $2
+				ORDER BY rank DESC LIMIT 1`,
+				id, userID,
+			).Scan(&vaultID, &role)
+		} else {
+			vaultID, err = defaultVaultID(h.DB, userID)
+			role = vaultOwner
+		}
+		if err == sql.ErrNoRows {
+			http.Error(w, "vault not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
 
+		ctx := setVaultID(r.Context(), vaultID)
+		ctx = setVaultRole(ctx, role)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// RequireVaultWrite blocks read-only members from changing a vault's entries.
+// It must run after VaultAccess.
+func (h *Handler) RequireVaultWrite(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if role := getVaultRole(r.Context()); role != vaultOwner && role != vaultWrite {
+			http.Error(w, "read-only access to this vault", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireVaultOwner limits vault management (keys, members, renaming) to the owner.
+// It must run after VaultAccess.
+func (h *Handler) RequireVaultOwner(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if getVaultRole(r.Context()) != vaultOwner {
+			http.Error(w, "only the vault owner can do this", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// OrgAccess resolves the {orgID} route parameter and stores the organization and the
+// user's role in it in the context. Organizations the user doesn't belong to are
+// reported as not found.
+func (h *Handler) OrgAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var orgID, role string
+		err := h.DB.QueryRow(`
+			SELECT org_id, role FROM org_members WHERE org_id::text = $1 AND user_id = $2`,
+			chi.URLParam(r, "orgID"), getUserID(r.Context()),
+		).Scan(&orgID, &role)
+		if err == sql.ErrNoRows {
+			http.Error(w, "organization not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		ctx := setOrgID(r.Context(), orgID)
+		ctx = setOrgRole(ctx, role)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireOrgAdmin limits organization management to owners and admins.
+// It must run after OrgAccess.
+func (h *Handler) RequireOrgAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if role := getOrgRole(r.Context()); role != orgOwner && role != orgAdmin {
+			http.Error(w, "only organization owners and admins can do this", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,737 @@
+package handlers
+
+import (
+	"backend/pswd/internal/models"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Organization roles. Owners and admins manage members and teams; only owners can
+// grant or take away the owner role or delete the organization.
+const (
+	orgOwner  = "owner"
+	orgAdmin  = "admin"
+	orgMember = "member"
+)
+
+// errMissingTeamKey is returned by rotateTeamKeys when a team would be left on the old key
+var errMissingTeamKey = errors.New("every team needs an envelope for the new key")
+
+// validOrgRole reports whether role is an organization role
+func validOrgRole(role string) bool {
+	return role == orgOwner || role == orgAdmin || role == orgMember
+}
+
+// CreateOrgHandler creates an organization with the current user as its owner
+func (h *Handler) CreateOrgHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+
+	var req models.CreateOrgRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || req.WrappedOrgKey == "" {
+		http.Error(w, "name and wrapped_org_key are required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	resp := models.OrgResponse{Name: req.Name, Role: orgOwner, WrappedOrgKey: req.WrappedOrgKey}
+	err = tx.QueryRow(`
+		INSERT INTO organizations (name, created_by) VALUES ($1, $2)
+		RETURNING org_id, created_at`,
+		req.Name, userID,
+	).Scan(&resp.OrgID, &resp.CreatedAt)
+	if err != nil {
+		http.Error(w, "failed to create organization", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO org_members (org_id, user_id, role, wrapped_org_key) VALUES ($1, $2, $3, $4)`,
+		resp.OrgID, userID, orgOwner, req.WrappedOrgKey,
+	)
+	if err != nil {
+		http.Error(w, "failed to create organization", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to create organization", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ListOrgsHandler returns the organizations the current user belongs to
+func (h *Handler) ListOrgsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+
+	rows, err := h.DB.Query(`
+		SELECT o.org_id, o.name, m.role, m.wrapped_org_key, o.created_at
+		FROM org_members m
+		JOIN organizations o ON o.org_id = m.org_id
+		WHERE m.user_id = $1
+		ORDER BY o.name`,
+		userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to fetch organizations", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	orgs := []models.OrgResponse{}
+	for rows.Next() {
+		var o models.OrgResponse
+		if err := rows.Scan(&o.OrgID, &o.Name, &o.Role, &o.WrappedOrgKey, &o.CreatedAt); err != nil {
+			http.Error(w, "failed to read organizations", http.StatusInternalServerError)
+			return
+		}
+		orgs = append(orgs, o)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(orgs)
+}
+
+// GetOrgHandler describes an organization, with the user's copy of the org key
+func (h *Handler) GetOrgHandler(w http.ResponseWriter, r *http.Request) {
+	o := models.OrgResponse{Role: getOrgRole(r.Context())}
+	err := h.DB.QueryRow(`
+		SELECT o.org_id, o.name, m.wrapped_org_key, o.created_at
+		FROM organizations o
+		JOIN org_members m ON m.org_id = o.org_id
+		WHERE o.org_id = $1 AND m.user_id = $2`,
+		getOrgID(r.Context()), getUserID(r.Context()),
+	).Scan(&o.OrgID, &o.Name, &o.WrappedOrgKey, &o.CreatedAt)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(o)
+}
+
+// RenameOrgHandler renames an organization (owners and admins)
+func (h *Handler) RenameOrgHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateOrgRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	_, err := h.DB.Exec(`UPDATE organizations SET name = $1 WHERE org_id = $2`, req.Name, getOrgID(r.Context()))
+	if err != nil {
+		http.Error(w, "failed to rename organization", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteOrgHandler deletes an organization with its teams (owners only). Vaults assigned
+// to its teams are kept; only the team grants go.
+func (h *Handler) DeleteOrgHandler(w http.ResponseWriter, r *http.Request) {
+	if getOrgRole(r.Context()) != orgOwner {
+		http.Error(w, "only organization owners can do this", http.StatusForbidden)
+		return
+	}
+
+	_, err := h.DB.Exec(`DELETE FROM organizations WHERE org_id = $1`, getOrgID(r.Context()))
+	if err != nil {
+		http.Error(w, "failed to delete organization", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListOrgMembersHandler returns the members of an organization
+func (h *Handler) ListOrgMembersHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query(`
+		SELECT u.user_id, u.username, m.role, m.created_at
+		FROM org_members m
+		JOIN users u ON u.user_id = m.user_id
+		WHERE m.org_id = $1
+		ORDER BY m.created_at`,
+		getOrgID(r.Context()),
+	)
+	if err != nil {
+		http.Error(w, "failed to fetch members", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	members := []models.OrgMemberResponse{}
+	for rows.Next() {
+		var m models.OrgMemberResponse
+		if err := rows.Scan(&m.UserID, &m.Username, &m.Role, &m.CreatedAt); err != nil {
+			http.Error(w, "failed to read members", http.StatusInternalServerError)
+			return
+		}
+		members = append(members, m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// AddOrgMemberHandler adds a user to an organization (owners and admins). The caller
+// seals the org key to the new member's pk_encrypt (see GetUserKeysHandler).
+func (h *Handler) AddOrgMemberHandler(w http.ResponseWriter, r *http.Request) {
+	orgID := getOrgID(r.Context())
+
+	var req models.AddOrgMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	if req.Username == "" || req.WrappedOrgKey == "" {
+		http.Error(w, "username and wrapped_org_key are required", http.StatusBadRequest)
+		return
+	}
+	if !validOrgRole(req.Role) {
+		http.Error(w, "role must be owner, admin or member", http.StatusBadRequest)
+		return
+	}
+	if req.Role == orgOwner && getOrgRole(r.Context()) != orgOwner {
+		http.Error(w, "only organization owners can add owners", http.StatusForbidden)
+		return
+	}
+
+	resp := models.OrgMemberResponse{Username: req.Username, Role: req.Role}
+	err := h.DB.QueryRow(`
+		INSERT INTO org_members (org_id, user_id, role, wrapped_org_key)
+		SELECT $1, user_id, $3, $4 FROM users WHERE username = $2
+		ON CONFLICT (org_id, user_id) DO NOTHING
+		RETURNING user_id, created_at`,
+		orgID, req.Username, req.Role, req.WrappedOrgKey,
+	).Scan(&resp.UserID, &resp.CreatedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "user not found or already a member", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to add member", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// UpdateOrgMemberHandler changes a member's role (owners and admins). Only owners can
+// touch the owner role, and the last owner can't be demoted.
+func (h *Handler) UpdateOrgMemberHandler(w http.ResponseWriter, r *http.Request) {
+	orgID := getOrgID(r.Context())
+	memberID := chi.URLParam(r, "userID")
+
+	var req models.UpdateOrgMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	if !validOrgRole(req.Role) {
+		http.Error(w, "role must be owner, admin or member", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	currentRole, ok := lockOrgMember(w, tx, orgID, memberID)
+	if !ok {
+		return
+	}
+	if (currentRole == orgOwner || req.Role == orgOwner) && getOrgRole(r.Context()) != orgOwner {
+		http.Error(w, "only organization owners can change the owner role", http.StatusForbidden)
+		return
+	}
+	if currentRole == orgOwner && req.Role != orgOwner && !hasOtherOrgOwner(w, tx, orgID, memberID) {
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE org_members SET role = $1 WHERE org_id = $2 AND user_id::text = $3`,
+		req.Role, orgID, memberID,
+	)
+	if err != nil {
+		http.Error(w, "failed to update member", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to update member", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveOrgMemberHandler removes a member from an organization and its teams. Owners and
+// admins can remove others (only owners can remove owners); anyone can leave, except
+// the last owner. The removed member may still know the org key.
+func (h *Handler) RemoveOrgMemberHandler(w http.ResponseWriter, r *http.Request) {
+	orgID := getOrgID(r.Context())
+	memberID := chi.URLParam(r, "userID")
+	callerRole := getOrgRole(r.Context())
+
+	if memberID != getUserID(r.Context()) && callerRole != orgOwner && callerRole != orgAdmin {
+		http.Error(w, "only organization owners and admins can do this", http.StatusForbidden)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	currentRole, ok := lockOrgMember(w, tx, orgID, memberID)
+	if !ok {
+		return
+	}
+	if currentRole == orgOwner {
+		if memberID != getUserID(r.Context()) && callerRole != orgOwner {
+			http.Error(w, "only organization owners can remove owners", http.StatusForbidden)
+			return
+		}
+		if !hasOtherOrgOwner(w, tx, orgID, memberID) {
+			return
+		}
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM team_members tm
+		USING teams t
+		WHERE t.team_id = tm.team_id AND t.org_id = $1 AND tm.user_id::text = $2`,
+		orgID, memberID,
+	)
+	if err != nil {
+		http.Error(w, "failed to remove member", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(`DELETE FROM org_members WHERE org_id = $1 AND user_id::text = $2`, orgID, memberID)
+	if err != nil {
+		http.Error(w, "failed to remove member", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to remove member", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lockOrgMember locks a membership row for a role change and returns its current role.
+// It writes the error response and returns false if the member doesn't exist.
+func lockOrgMember(w http.ResponseWriter, tx *sql.Tx, orgID, memberID string) (string, bool) {
+	var role string
+	err := tx.QueryRow(`
+		SELECT role FROM org_members WHERE org_id = $1 AND user_id::text = $2
+		FOR UPDATE`,
+		orgID, memberID,
+	).Scan(&role)
+	if err == sql.ErrNoRows {
+		http.Error(w, "member not found", http.StatusNotFound)
+		return "", false
+	}
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return "", false
+	}
+	return role, true
+}
+
+// hasOtherOrgOwner reports whether an organization keeps an owner besides memberID.
+// It writes the error response and returns false otherwise.
+func hasOtherOrgOwner(w http.ResponseWriter, tx *sql.Tx, orgID, memberID string) bool {
+	var others bool
+	err := tx.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM org_members WHERE org_id = $1 AND role = 'owner' AND user_id::text <> $2
+		)`,
+		orgID, memberID,
+	).Scan(&others)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return false
+	}
+	if !others {
+		http.Error(w, "an organization needs at least one owner", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// ListTeamsHandler returns the teams of an organization
+func (h *Handler) ListTeamsHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query(`
+		SELECT t.team_id, t.name,
+			(SELECT count(*) FROM team_members tm WHERE tm.team_id = t.team_id),
+			(SELECT count(*) FROM team_vaults tv WHERE tv.team_id = t.team_id),
+			t.created_at
+		FROM teams t
+		WHERE t.org_id = $1
+		ORDER BY t.name`,
+		getOrgID(r.Context()),
+	)
+	if err != nil {
+		http.Error(w, "failed to fetch teams", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	teams := []models.TeamResponse{}
+	for rows.Next() {
+		var t models.TeamResponse
+		if err := rows.Scan(&t.TeamID, &t.Name, &t.MemberCount, &t.VaultCount, &t.CreatedAt); err != nil {
+			http.Error(w, "failed to read teams", http.StatusInternalServerError)
+			return
+		}
+		teams = append(teams, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(teams)
+}
+
+// CreateTeamHandler creates a team (owners and admins)
+func (h *Handler) CreateTeamHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.TeamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	resp := models.TeamResponse{Name: req.Name}
+	err := h.DB.QueryRow(`
+		INSERT INTO teams (org_id, name) VALUES ($1, $2)
+		RETURNING team_id, created_at`,
+		getOrgID(r.Context()), req.Name,
+	).Scan(&resp.TeamID, &resp.CreatedAt)
+	if err != nil {
+		http.Error(w, "failed to create team", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// DeleteTeamHandler deletes a team and its vault grants (owners and admins)
+func (h *Handler) DeleteTeamHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := h.DB.Exec(`
+		DELETE FROM teams WHERE team_id::text = $1 AND org_id = $2`,
+		chi.URLParam(r, "teamID"), getOrgID(r.Context()),
+	)
+	if err != nil {
+		http.Error(w, "failed to delete team", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "team not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListTeamMembersHandler returns the members of a team
+func (h *Handler) ListTeamMembersHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query(`
+		SELECT u.user_id, u.username, tm.created_at
+		FROM team_members tm
+		JOIN teams t ON t.team_id = tm.team_id
+		JOIN users u ON u.user_id = tm.user_id
+		WHERE tm.team_id::text = $1 AND t.org_id = $2
+		ORDER BY u.username`,
+		chi.URLParam(r, "teamID"), getOrgID(r.Context()),
+	)
+	if err != nil {
+		http.Error(w, "failed to fetch team members", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	members := []models.TeamMemberResponse{}
+	for rows.Next() {
+		var m models.TeamMemberResponse
+		if err := rows.Scan(&m.UserID, &m.Username, &m.CreatedAt); err != nil {
+			http.Error(w, "failed to read team members", http.StatusInternalServerError)
+			return
+		}
+		members = append(members, m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// AddTeamMemberHandler puts an organization member in a team (owners and admins)
+func (h *Handler) AddTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := h.DB.Exec(`
+		INSERT INTO team_members (team_id, user_id)
+		SELECT t.team_id, m.user_id
+		FROM teams t
+		JOIN org_members m ON m.org_id = t.org_id
+		WHERE t.team_id::text = $1 AND t.org_id = $2 AND m.user_id::text = $3
+		ON CONFLICT (team_id, user_id) DO NOTHING`,
+		chi.URLParam(r, "teamID"), getOrgID(r.Context()), chi.URLParam(r, "userID"),
+	)
+	if err != nil {
+		http.Error(w, "failed to add team member", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "team or organization member not found, or already in the team", http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveTeamMemberHandler takes a user out of a team (owners and admins)
+func (h *Handler) RemoveTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := h.DB.Exec(`
+		DELETE FROM team_members tm
+		USING teams t
+		WHERE t.team_id = tm.team_id AND tm.team_id::text = $1 AND t.org_id = $2 AND tm.user_id::text = $3`,
+		chi.URLParam(r, "teamID"), getOrgID(r.Context()), chi.URLParam(r, "userID"),
+	)
+	if err != nil {
+		http.Error(w, "failed to remove team member", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "team member not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListTeamVaultsHandler returns the vaults a team can access
+func (h *Handler) ListTeamVaultsHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query(`
+		SELECT t.team_id, t.name, t.org_id, v.vault_id, v.name, tv.role, tv.created_at
+		FROM team_vaults tv
+		JOIN teams t ON t.team_id = tv.team_id
+		JOIN vaults v ON v.vault_id = tv.vault_id
+		WHERE tv.team_id::text = $1 AND t.org_id = $2
+		ORDER BY v.name`,
+		chi.URLParam(r, "teamID"), getOrgID(r.Context()),
+	)
+	if err != nil {
+		http.Error(w, "failed to fetch team vaults", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	vaults := []models.TeamVaultResponse{}
+	for rows.Next() {
+		var v models.TeamVaultResponse
+		if err := rows.Scan(&v.TeamID, &v.TeamName, &v.OrgID, &v.VaultID, &v.VaultName, &v.Role, &v.CreatedAt); err != nil {
+			http.Error(w, "failed to read team vaults", http.StatusInternalServerError)
+			return
+		}
+		vaults = append(vaults, v)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(vaults)
+}
+
+// ListVaultTeamsHandler returns the teams a vault is assigned to
+func (h *Handler) ListVaultTeamsHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query(`
+		SELECT t.team_id, t.name, t.org_id, v.vault_id, v.name, tv.role, tv.created_at
+		FROM team_vaults tv
+		JOIN teams t ON t.team_id = tv.team_id
+		JOIN vaults v ON v.vault_id = tv.vault_id
+		WHERE tv.vault_id = $1
+		ORDER BY t.name`,
+		getVaultID(r.Context()),
+	)
+	if err != nil {
+		http.Error(w, "failed to fetch vault teams", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	teams := []models.TeamVaultResponse{}
+	for rows.Next() {
+		var t models.TeamVaultResponse
+		if err := rows.Scan(&t.TeamID, &t.TeamName, &t.OrgID, &t.VaultID, &t.VaultName, &t.Role, &t.CreatedAt); err != nil {
+			http.Error(w, "failed to read vault teams", http.StatusInternalServerError)
+			return
+		}
+		teams = append(teams, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(teams)
+}
+
+// AssignTeamVaultHandler gives a team access to a vault, or changes its role. The caller
+// must own the vault and be an owner or admin of the team's organization, and provides
+// the vault key encrypted with the org key. Access is enforced by the server; the
+// cryptography only keeps the vault away from people outside the organization.
+func (h *Handler) AssignTeamVaultHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	vaultID := getVaultID(r.Context())
+
+	var req models.AssignTeamVaultRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	if req.Role != vaultRead && req.Role != vaultWrite {
+		http.Error(w, "role must be read or write", http.StatusBadRequest)
+		return
+	}
+	if req.TeamID == "" || req.WrappedKey == "" {
+		http.Error(w, "team_id and wrapped_key are required", http.StatusBadRequest)
+		return
+	}
+
+	var orgRole string
+	var keyVersion int
+	err := h.DB.QueryRow(`
+		SELECT m.role, v.key_version
+		FROM teams t
+		JOIN org_members m ON m.org_id = t.org_id AND m.user_id = $2
+		JOIN vaults v ON v.vault_id = $3
+		WHERE t.team_id::text = $1`,
+		req.TeamID, userID, vaultID,
+	).Scan(&orgRole, &keyVersion)
+	if err == sql.ErrNoRows {
+		http.Error(w, "team not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if orgRole != orgOwner && orgRole != orgAdmin {
+		http.Error(w, "only organization owners and admins can do this", http.StatusForbidden)
+		return
+	}
+	if req.KeyVersion != keyVersion {
+		http.Error(w, "key_version is not the current vault key version", http.StatusConflict)
+		return
+	}
+
+	_, err = h.DB.Exec(`
+		INSERT INTO team_vaults (team_id, vault_id, role, wrapped_key, key_version)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (team_id, vault_id) DO UPDATE
+		SET role = EXCLUDED.role, wrapped_key = EXCLUDED.wrapped_key, key_version = EXCLUDED.key_version`,
+		req.TeamID, vaultID, req.Role, req.WrappedKey, req.KeyVersion,
+	)
+	if err != nil {
+		http.Error(w, "failed to assign vault", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnassignTeamVaultHandler takes a vault away from a team (vault owner only)
+func (h *Handler) UnassignTeamVaultHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := h.DB.Exec(`
+		DELETE FROM team_vaults WHERE vault_id = $1 AND team_id::text = $2`,
+		getVaultID(r.Context()), chi.URLParam(r, "teamID"),
+	)
+	if err != nil {
+		http.Error(w, "failed to unassign vault", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "team not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rotateTeamKeys replaces every team's envelope during a key rotation
+func rotateTeamKeys(tx *sql.Tx, vaultID string, keyVersion int, envelopes []models.TeamKeyEnvelope) error {
+	var teamCount int
+	err := tx.QueryRow(`SELECT count(*) FROM team_vaults WHERE vault_id = $1`, vaultID).Scan(&teamCount)
+	if err != nil {
+		return err
+	}
+	if len(envelopes) != teamCount {
+		return errMissingTeamKey
+	}
+
+	for _, envelope := range envelopes {
+		result, err := tx.Exec(`
+			UPDATE team_vaults SET wrapped_key = $1, key_version = $2
+			WHERE vault_id = $3 AND team_id::text = $4 AND key_version <> $2`,
+			envelope.WrappedKey, keyVersion, vaultID, envelope.TeamID,
+		)
+		if err != nil {
+			return err
+		}
+		rowsAffected, _ := result.RowsAffected()
+		if rowsAffected == 0 {
+			return errMissingTeamKey
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,470 @@
+package handlers
+
+import (
+	"backend/pswd/internal/auth"
+	"backend/pswd/internal/events"
+	"backend/pswd/internal/models"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Relay limits per pairing session. The key exchange needs a handful of small messages.
+const (
+	maxPairingMessages    = 32
+	maxPairingPayloadSize = 16 * 1024
+)
+
+// pairingTokenHeader carries the pairing token on relay requests
+const pairingTokenHeader = "X-Pairing-Token"
+
+// CreatePairingHandler opens a pairing session from the master device (the protocol is
+// described in the auth package). The returned code is typed or scanned on the new device.
+func (h *Handler) CreatePairingHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.requireMaster(w, r) {
+		return
+	}
+	userID := getUserID(r.Context())
+	deviceID := getDeviceID(r.Context())
+
+	token, err := auth.GenerateNonce()
+	if err != nil {
+		http.Error(w, "failed to create pairing session", http.StatusInternalServerError)
+		return
+	}
+
+	resp := models.PairingSessionResponse{PairingToken: token}
+
+	// Retry on the rare collision with another open session's code
+	for attempt := 0; attempt < 3; attempt++ {
+		resp.Code, err = auth.GeneratePairingCode()
+		if err != nil {
+			http.Error(w, "failed to create pairing session", http.StatusInternalServerError)
+			return
+		}
+
+		err = h.DB.QueryRow(`
+			INSERT INTO pairing_sessions (user_id, initiator_device_id, code, initiator_token_hash, expires_at)
+			VALUES ($1, $2, $3, $4, now() + ($5 * interval '1 second'))
+			ON CONFLICT DO NOTHING
+			RETURNING pairing_id, expires_at`,
+			userID, deviceID, resp.Code, auth.HashToken(token), int64(auth.PairingTTL.Seconds()),
+		).Scan(&resp.PairingID, &resp.ExpiresAt)
+		if err != sql.ErrNoRows {
+			break
+		}
+	}
+	if err != nil {
+		http.Error(w, "failed to create pairing session", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// JoinPairingHandler is called by the new device with the short code. The device is
+// recorded as pending and gets a token for its side of the relay.
+func (h *Handler) JoinPairingHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.PairingJoinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	req.DeviceName = strings.TrimSpace(req.DeviceName)
+	if req.DeviceName == "" || req.DeviceFingerprint == "" {
+		http.Error(w, "device_name and device_fingerprint are required", http.StatusBadRequest)
+		return
+	}
+	if err := validateDeviceKeys(req.PkDevice, req.PkDeviceSign); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, err := auth.GenerateNonce()
+	if err != nil {
+		http.Error(w, "failed to join pairing session", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var userID string
+	resp := models.PairingJoinResponse{PairingToken: token}
+	err = tx.QueryRow(`
+		SELECT pairing_id, user_id, expires_at FROM pairing_sessions
+		WHERE code = $1 AND status = 'open' AND expires_at > now()
+		FOR UPDATE`,
+		auth.NormalizePairingCode(req.Code),
+	).Scan(&resp.PairingID, &userID, &resp.ExpiresAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "invalid or expired pairing code", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Like enrollment, a rejected or revoked device may come back; other fingerprints are taken
+	err = tx.QueryRow(`
+		INSERT INTO devices (user_id, device_name, device_fingerprint, pk_device, pk_device_sign, is_master, status)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), false, 'pending')
+		ON CONFLICT (device_fingerprint) DO UPDATE
+		SET device_name = EXCLUDED.device_name,
+			pk_device = EXCLUDED.pk_device,
+			pk_device_sign = EXCLUDED.pk_device_sign,
+			status = 'pending',
+			created_at = now()
+		WHERE devices.status IN ('rejected', 'revoked') AND devices.user_id = EXCLUDED.user_id
+		RETURNING device_id`,
+		userID, req.DeviceName, req.DeviceFingerprint, req.PkDevice, req.PkDeviceSign,
+	).Scan(&resp.DeviceID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "device already registered", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to register device", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE pairing_sessions
+		SET status = 'joined', joiner_device_id = $1, joiner_token_hash = $2
+		WHERE pairing_id = $3`,
+		resp.DeviceID, auth.HashToken(token), resp.PairingID,
+	)
+	if err != nil {
+		http.Error(w, "failed to join pairing session", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to join pairing session", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// pairingParty identifies which side ("initiator" or "joiner") of a live pairing session
+// a relay request comes from, using the X-Pairing-Token header. It returns "" if the
+// token doesn't match.
+func (h *Handler) pairingParty(r *http.Request, pairingID string) (party, status string, err error) {
+	tokenHash := auth.HashToken(r.Header.Get(pairingTokenHeader))
+	err = h.DB.QueryRow(`
+		SELECT CASE WHEN initiator_token_hash = $2 THEN 'initiator' ELSE 'joiner' END, status
+		FROM pairing_sessions
+		WHERE pairing_id::text = $1 AND $2 IN (initiator_token_hash, joiner_token_hash)
+		AND status <> 'cancelled' AND expires_at > now()`,
+		pairingID, tokenHash,
+	).Scan(&party, &status)
+	if err == sql.ErrNoRows {
+		return "", "", nil
+	}
+	return party, status, err
+}
+
+// PostPairingMessageHandler relays a message to the other device. The server stores
+// the payload as-is and never interprets it.
+func (h *Handler) PostPairingMessageHandler(w http.ResponseWriter, r *http.Request) {
+	pairingID := chi.URLParam(r, "pairingID")
+
+	party, status, err := h.pairingParty(r, pairingID)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if party == "" {
+		http.Error(w, "invalid pairing token", http.StatusUnauthorized)
+		return
+	}
+	if status != "joined" {
+		http.Error(w, "pairing session is not accepting messages", http.StatusConflict)
+		return
+	}
+
+	var req models.PairingMessageRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*maxPairingPayloadSize)).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if req.Payload == "" || len(req.Payload) > maxPairingPayloadSize {
+		http.Error(w, "payload must be between 1 byte and 16 KiB", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the session so concurrent messages are counted one at a time against the limit
+	err = tx.QueryRow(`
+		SELECT status FROM pairing_sessions WHERE pairing_id::text = $1 FOR UPDATE`,
+		pairingID,
+	).Scan(&status)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if status != "joined" {
+		http.Error(w, "pairing session is not accepting messages", http.StatusConflict)
+		return
+	}
+
+	var msg models.PairingMessage
+	err = tx.QueryRow(`
+		INSERT INTO pairing_messages (pairing_id, sender, payload)
+		SELECT $1::uuid, $2, $3
+		WHERE (SELECT count(*) FROM pairing_messages WHERE pairing_id::text = $1) < $4
+		RETURNING message_id, sender, payload, created_at`,
+		pairingID, party, req.Payload, maxPairingMessages,
+	).Scan(&msg.MessageID, &msg.Sender, &msg.Payload, &msg.CreatedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "too many messages in pairing session", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to relay message", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to relay message", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(msg)
+}
+
+// GetPairingMessagesHandler returns the session status and the other device's messages
+// newer than ?after=<message_id>, for polling
+func (h *Handler) GetPairingMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	pairingID := chi.URLParam(r, "pairingID")
+
+	var after int64
+	if s := r.URL.Query().Get("after"); s != "" {
+		var err error
+		after, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			http.Error(w, "after must be a message id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	party, status, err := h.pairingParty(r, pairingID)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if party == "" {
+		http.Error(w, "invalid pairing token", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT message_id, sender, payload, created_at
+		FROM pairing_messages
+		WHERE pairing_id::text = $1 AND sender <> $2 AND message_id > $3
+		ORDER BY message_id`,
+		pairingID, party, after,
+	)
+	if err != nil {
+		http.Error(w, "failed to fetch messages", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	resp := models.PairingMessagesResponse{Status: status, Messages: []models.PairingMessage{}}
+	for rows.Next() {
+		var msg models.PairingMessage
+		if err := rows.Scan(&msg.MessageID, &msg.Sender, &msg.Payload, &msg.CreatedAt); err != nil {
+			http.Error(w, "failed to read messages", http.StatusInternalServerError)
+			return
+		}
+		resp.Messages = append(resp.Messages, msg)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// CompletePairingHandler is called by the initiating device once the user has confirmed
+// that both devices show the same short authentication string. It activates the new device.
+func (h *Handler) CompletePairingHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.requireMaster(w, r) {
+		return
+	}
+	userID := getUserID(r.Context())
+	deviceID := getDeviceID(r.Context())
+	pairingID := chi.URLParam(r, "pairingID")
+
+	var req models.PairingCompleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var joinerDeviceID string
+	err = tx.QueryRow(`
+		UPDATE pairing_sessions SET status = 'completed', completed_at = now()
+		WHERE pairing_id::text = $1 AND user_id = $2 AND initiator_device_id = $3
+		AND status = 'joined' AND expires_at > now()
+		RETURNING joiner_device_id`,
+		pairingID, userID, deviceID,
+	).Scan(&joinerDeviceID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "pairing session not found or not joined", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to complete pairing", http.StatusInternalServerError)
+		return
+	}
+
+	result, err := tx.Exec(`
+		UPDATE devices SET status = 'active'
+		WHERE device_id = $1 AND user_id = $2 AND status = 'pending'`,
+		joinerDeviceID, userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to complete pairing", http.StatusInternalServerError)
+		return
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "the new device is no longer pending", http.StatusConflict)
+		return
+	}
+
+	if req.WrappedVaultKey != "" {
+		vaultID, err := defaultVaultID(tx, userID)
+		if err != nil {
+			http.Error(w, "failed to complete pairing", http.StatusInternalServerError)
+			return
+		}
+		if err := putVaultKey(tx, vaultID, joinerDeviceID, req.WrappedVaultKey, 0); err != nil {
+			http.Error(w, "failed to complete pairing", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to complete pairing", http.StatusInternalServerError)
+		return
+	}
+
+	h.publishUserEvent(getSessionID(r.Context()), userID, events.Event{Type: events.DeviceChanged, DeviceID: joinerDeviceID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PairingLoginHandler lets the newly paired device exchange its pairing token for a
+// session, once, so it never needs the password. The master device vouched for it
+// from an authenticated session, so no second factor is asked for.
+func (h *Handler) PairingLoginHandler(w http.ResponseWriter, r *http.Request) {
+	pairingID := chi.URLParam(r, "pairingID")
+
+	var resp models.LoginResponse
+	err := h.DB.QueryRow(`
+		UPDATE pairing_sessions SET session_issued_at = now()
+		WHERE pairing_id::text = $1 AND joiner_token_hash = $2
+		AND status = 'completed' AND session_issued_at IS NULL AND expires_at > now()
+		RETURNING user_id, joiner_device_id`,
+		pairingID, auth.HashToken(r.Header.Get(pairingTokenHeader)),
+	).Scan(&resp.UserID, &resp.DeviceID)
+	if err != nil {
+		http.Error(w, "invalid pairing token", http.StatusUnauthorized)
+		return
+	}
+
+	err = h.DB.QueryRow(`
+		SELECT u.username, d.is_master
+		FROM users u
+		JOIN devices d ON d.user_id = u.user_id
+		WHERE u.user_id = $1 AND d.device_id = $2 AND d.status = 'active'`,
+		resp.UserID, resp.DeviceID,
+	).Scan(&resp.Username, &resp.IsMaster)
+	if err != nil {
+		http.Error(w, "invalid pairing token", http.StatusUnauthorized)
+		return
+	}
+
+	h.issueLogin(w, r, resp)
+}
+
+// CancelPairingHandler aborts a pairing session from the initiating device. A device
+// that already joined is rejected.
+func (h *Handler) CancelPairingHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	deviceID := getDeviceID(r.Context())
+	pairingID := chi.URLParam(r, "pairingID")
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var joinerDeviceID sql.NullString
+	err = tx.QueryRow(`
+		UPDATE pairing_sessions SET status = 'cancelled'
+		WHERE pairing_id::text = $1 AND user_id = $2 AND initiator_device_id = $3
+		AND status IN ('open', 'joined')
+		RETURNING joiner_device_id`,
+		pairingID, userID, deviceID,
+	).Scan(&joinerDeviceID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "pairing session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to cancel pairing", http.StatusInternalServerError)
+		return
+	}
+
+	if joinerDeviceID.Valid {
+		_, err = tx.Exec(`
+			UPDATE devices SET status = 'rejected'
+			WHERE device_id = $1 AND status = 'pending'`,
+			joinerDeviceID.String,
+		)
+		if err != nil {
+			http.Error(w, "failed to cancel pairing", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to cancel pairing", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
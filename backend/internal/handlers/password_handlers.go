@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"backend/pswd/internal/auth"
+	"backend/pswd/internal/events"
+	"backend/pswd/internal/models"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Errors from rekeyOwnVaults that are the client's fault
+var (
+	errIncompleteReencryption = errors.New("every vault without device keys, and its entries and revisions, must be re-encrypted")
+	errInvalidEncryptedData   = errors.New("each entry needs its revision and encrypted data")
+	errStaleKeyVersion        = errors.New("key_version must be the current version + 1")
+)
+
+// ChangePasswordHandler replaces the account's password after checking the old one (or an
+// SRP proof) and the second factor, if enabled. The new hash or verifier, the private
+// keys encrypted for the new password and the re-keyed vaults (see rekeyOwnVaults) are
+// stored in one transaction, so a failure half-way leaves everything readable with the
+// old password. Every other session is signed out.
+func (h *Handler) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	deviceID := getDeviceID(r.Context())
+	sessionID := getSessionID(r.Context())
+
+	var req models.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	if req.EncryptedPrivateKeys == "" {
+		http.Error(w, "encrypted_private_keys is required", http.StatusBadRequest)
+		return
+	}
+
+	var passwordHash, srpVerifier sql.NullString
+	var secondFactor bool
+	err := h.DB.QueryRow(`
+		SELECT u.password_hash, u.srp_verifier, u.totp_enabled OR EXISTS (
+			SELECT 1 FROM webauthn_credentials wc WHERE wc.user_id = u.user_id
+		)
+		FROM users u
+		WHERE u.user_id = $1`,
+		userID,
+	).Scan(&passwordHash, &srpVerifier, &secondFactor)
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	// The new credential is of the same kind as the old one; moving to SRP is a separate
+	// step (POST /api/auth/srp/enroll)
+	var newHash sql.NullString
+	if passwordHash.Valid {
+		if req.NewPassword == "" || req.SRPSalt != "" || req.SRPVerifier != "" {
+			http.Error(w, "new_password is required", http.StatusBadRequest)
+			return
+		}
+		if !auth.VerifyPassword(req.OldPassword, passwordHash.String) {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+
+		hash, err := auth.HashPassword(req.NewPassword)
+		if err != nil {
+			http.Error(w, "failed to process password", http.StatusInternalServerError)
+			return
+		}
+		newHash = sql.NullString{String: hash, Valid: true}
+	} else {
+		if req.NewPassword != "" {
+			http.Error(w, "srp_salt and srp_verifier are required", http.StatusBadRequest)
+			return
+		}
+		if err := auth.ValidateSRPVerifier(req.SRPSalt, req.SRPVerifier); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		server, handshakeUserID, _, _, err := h.consumeSRPHandshake(req.SRPHandshakeID)
+		if err != nil || handshakeUserID != userID {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		if _, err := server.VerifyClientProof(req.SRPM1); err != nil {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if secondFactor {
+		ok, err := h.verifySecondFactor(tx, userID, req.TwoFactorCodeRequest)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "invalid two-factor code", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// Only replace the credential that was just checked, in case of a concurrent change
+	result, err := tx.Exec(`
+		UPDATE users
+		SET password_hash = $1, srp_salt = NULLIF($2, ''), srp_verifier = NULLIF($3, ''),
+			encrypted_private_keys = $4
+		WHERE user_id = $5 AND password_hash IS NOT DISTINCT FROM $6 AND srp_verifier IS NOT DISTINCT FROM $7`,
+		newHash, req.SRPSalt, req.SRPVerifier, req.EncryptedPrivateKeys, userID, passwordHash, srpVerifier,
+	)
+	if err != nil {
+		http.Error(w, "failed to change password", http.StatusInternalServerError)
+		return
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "password was changed by another session", http.StatusConflict)
+		return
+	}
+
+	rekeyedVaults, current, err := rekeyOwnVaults(tx, userID, req)
+	if err == errIncompleteReencryption || err == errStaleKeyVersion || err == errMissingMemberKey || err == errMissingTeamKey {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err == errInvalidEncryptedData || err == errInvalidShareSignature {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err == errStaleRevision {
+		writeRevisionConflict(w, current)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to change password", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE sessions SET revoked_at = now()
+		WHERE user_id = $1 AND session_id <> $2 AND revoked_at IS NULL`,
+		userID, sessionID,
+	)
+	if err != nil {
+		http.Error(w, "failed to change password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := notify(tx, userID, notifyPasswordChanged, deviceID, ""); err != nil {
+		http.Error(w, "failed to change password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to change password", http.StatusInternalServerError)
+		return
+	}
+
+	for _, vaultID := range rekeyedVaults {
+		h.publishVaultEvent(sessionID, events.Event{Type: events.VaultChanged, VaultID: vaultID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "password changed; other sessions signed out"})
+}
+
+// rekeyOwnVaults re-keys the user's own vaults that have no device envelopes (see
+// vault_keys), whose key depends on the password. The server decides which vaults those
+// are, and each is handled like a rotation: every one of their entries must be given at
+// the revision it was read at (errStaleRevision with the current one otherwise), every
+// stored revision re-encrypted, and every member and team given an envelope for the next
+// key version. It returns the vaults that changed.
+func rekeyOwnVaults(tx *sql.Tx, userID string, req models.ChangePasswordRequest) ([]string, int, error) {
+	rows, err := tx.Query(`
+		SELECT v.vault_id FROM vaults v
+		WHERE v.user_id = $1 AND NOT EXISTS (SELECT 1 FROM vault_keys vk WHERE vk.vault_id = v.vault_id)
+		ORDER BY v.vault_id`,
+		userID,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	var vaultIDs []string
+	for rows.Next() {
+		var vaultID string
+		if err := rows.Scan(&vaultID); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		vaultIDs = append(vaultIDs, vaultID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// Lock the vaults in a fixed order before touching their entries
+	seqs := make(map[string]int64, len(vaultIDs))
+	for _, vaultID := range vaultIDs {
+		seq, err := nextChangeSeq(tx, vaultID)
+		if err != nil {
+			return nil, 0, err
+		}
+		seqs[vaultID] = seq
+	}
+
+	if len(req.Vaults) != len(vaultIDs) {
+		return nil, 0, errIncompleteReencryption
+	}
+	keyVersions := make(map[string]int, len(req.Vaults))
+	for _, vault := range req.Vaults {
+		if _, ok := seqs[vault.VaultID]; !ok || keyVersions[vault.VaultID] != 0 {
+			return nil, 0, errIncompleteReencryption
+		}
+
+		var currentVersion int
+		err := tx.QueryRow(`SELECT key_version FROM vaults WHERE vault_id = $1`, vault.VaultID).Scan(&currentVersion)
+		if err != nil {
+			return nil, 0, err
+		}
+		if vault.KeyVersion != currentVersion+1 {
+			return nil, 0, errStaleKeyVersion
+		}
+		keyVersions[vault.VaultID] = vault.KeyVersion
+	}
+
+	var entryCount, revisionCount int
+	for _, vaultID := range vaultIDs {
+		var entries, revisions int
+		err := tx.QueryRow(`
+			SELECT (SELECT count(*) FROM vault_entries WHERE vault_id = $1),
+				(SELECT count(*) FROM vault_entry_revisions WHERE vault_id = $1)`,
+			vaultID,
+		).Scan(&entries, &revisions)
+		if err != nil {
+			return nil, 0, err
+		}
+		entryCount += entries
+		revisionCount += revisions
+	}
+	if len(req.Entries) != entryCount || len(req.Revisions) != revisionCount {
+		return nil, 0, errIncompleteReencryption
+	}
+
+	seen := make(map[string]bool, len(req.Entries))
+	for _, entry := range req.Entries {
+		if seen[entry.EntryID] {
+			return nil, 0, errIncompleteReencryption
+		}
+		seen[entry.EntryID] = true
+
+		encryptedData, err := base64.StdEncoding.DecodeString(entry.EncryptedData)
+		if err != nil || entry.Revision < 1 {
+			return nil, 0, errInvalidEncryptedData
+		}
+
+		var vaultID string
+		err = tx.QueryRow(`SELECT vault_id FROM vault_entries WHERE entry_id::text = $1`, entry.EntryID).Scan(&vaultID)
+		if err == sql.ErrNoRows || (err == nil && keyVersions[vaultID] == 0) {
+			return nil, 0, errIncompleteReencryption
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		current, err := reencryptEntry(tx, vaultID, seqs[vaultID], entry, encryptedData)
+		if err == sql.ErrNoRows {
+			return nil, 0, errIncompleteReencryption
+		}
+		if err != nil {
+			return nil, current, err
+		}
+	}
+
+	seen = make(map[string]bool, len(req.Revisions))
+	for _, revision := range req.Revisions {
+		if seen[revision.RevisionID] {
+			return nil, 0, errIncompleteReencryption
+		}
+		seen[revision.RevisionID] = true
+
+		encryptedData, err := base64.StdEncoding.DecodeString(revision.EncryptedData)
+		if err != nil {
+			return nil, 0, errInvalidEncryptedData
+		}
+
+		var vaultID string
+		err = tx.QueryRow(`SELECT vault_id FROM vault_entry_revisions WHERE revision_id::text = $1`, revision.RevisionID).Scan(&vaultID)
+		if err == sql.ErrNoRows || (err == nil && keyVersions[vaultID] == 0) {
+			return nil, 0, errIncompleteReencryption
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		_, err = tx.Exec(`
+			UPDATE vault_entry_revisions SET encrypted_data = $1, key_version = $2
+			WHERE revision_id::text = $3`,
+			encryptedData, keyVersions[vaultID], revision.RevisionID,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	for _, vault := range req.Vaults {
+		if err := rotateMemberKeys(tx, userID, vault.VaultID, vault.KeyVersion, vault.Members); err != nil {
+			return nil, 0, err
+		}
+		if err := rotateTeamKeys(tx, vault.VaultID, vault.KeyVersion, vault.Teams); err != nil {
+			return nil, 0, err
+		}
+		_, err := tx.Exec(`UPDATE vaults SET key_version = $1 WHERE vault_id = $2`, vault.KeyVersion, vault.VaultID)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return vaultIDs, 0, nil
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"backend/pswd/internal/events"
+	"backend/pswd/internal/models"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// lockVaultEntry locks an entry that isn't in the trash until tx ends and returns its
+// canonical ID and current revision. It returns sql.ErrNoRows if there is no such entry.
+func lockVaultEntry(tx *sql.Tx, vaultID, entryID string) (string, int, error) {
+	var lockedID string
+	var revision int
+	err := tx.QueryRow(`
+		SELECT entry_id, revision FROM vault_entries
+		WHERE entry_id::text = $1 AND vault_id = $2 AND deleted_at IS NULL
+		FOR UPDATE`,
+		entryID, vaultID,
+	).Scan(&lockedID, &revision)
+	return lockedID, revision, err
+}
+
+// saveEntryRevision locks an entry and copies its current version into
+// vault_entry_revisions before it is overwritten, then drops revisions beyond the
+// retention count. It returns the entry's revision, or sql.ErrNoRows if the entry isn't
+// in the vault or is in the trash.
+func (h *Handler) saveEntryRevision(tx *sql.Tx, vaultID, entryID, deviceID string) (int, error) {
+	lockedID, revision, err := lockVaultEntry(tx, vaultID, entryID)
+	if err != nil {
+		return 0, err
+	}
+
+	if h.EntryRevisionRetention == 0 {
+		return revision, nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO vault_entry_revisions (entry_id, vault_id, title, encrypted_data, entry_type, key_version, replaced_by)
+		SELECT e.entry_id, e.vault_id, e.title, e.encrypted_data, e.entry_type, v.key_version, $2
+		FROM vault_entries e
+		JOIN vaults v ON v.vault_id = e.vault_id
+		WHERE e.entry_id = $1`,
+		lockedID, deviceID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM vault_entry_revisions
+		WHERE entry_id = $1 AND revision_id NOT IN (
+			SELECT revision_id FROM vault_entry_revisions
+			WHERE entry_id = $1
+			ORDER BY seq DESC
+			LIMIT $2
+		)`,
+		lockedID, h.EntryRevisionRetention,
+	)
+	return revision, err
+}
+
+// ListEntryRevisionsHandler returns the previous versions of an entry, newest first
+func (h *Handler) ListEntryRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	vaultID := getVaultID(r.Context())
+	entryID := chi.URLParam(r, "entryID")
+
+	var exists bool
+	err := h.DB.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM vault_entries WHERE entry_id::text = $1 AND vault_id = $2 AND deleted_at IS NULL
+		)`,
+		entryID, vaultID,
+	).Scan(&exists)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "entry not found", http.StatusNotFound)
+		return
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT revision_id, entry_id, title, encrypted_data, entry_type, key_version, replaced_by, created_at
+		FROM vault_entry_revisions
+		WHERE entry_id::text = $1 AND vault_id = $2
+		ORDER BY seq DESC`,
+		entryID, vaultID,
+	)
+	if err != nil {
+		http.Error(w, "failed to fetch revisions", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	revisions := []models.EntryRevisionResponse{}
+	for rows.Next() {
+		var rev models.EntryRevisionResponse
+		var encryptedData []byte
+		err := rows.Scan(&rev.RevisionID, &rev.EntryID, &rev.Title, &encryptedData,
+			&rev.EntryType, &rev.KeyVersion, &rev.ReplacedBy, &rev.CreatedAt)
+		if err != nil {
+			http.Error(w, "failed to read revisions", http.StatusInternalServerError)
+			return
+		}
+		rev.EncryptedData = base64.StdEncoding.EncodeToString(encryptedData)
+		revisions = append(revisions, rev)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// RestoreEntryRevisionHandler puts a previous version of an entry back. The version being
+// replaced becomes a revision itself, so a restore can be undone the same way. Like an
+// edit, it needs the entry's current revision in If-Match.
+func (h *Handler) RestoreEntryRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	vaultID := getVaultID(r.Context())
+	entryID := chi.URLParam(r, "entryID")
+
+	expected, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var title, entryType string
+	var encryptedData []byte
+	err = tx.QueryRow(`
+		SELECT title, encrypted_data, entry_type
+		FROM vault_entry_revisions
+		WHERE revision_id::text = $1 AND entry_id::text = $2 AND vault_id = $3`,
+		chi.URLParam(r, "revisionID"), entryID, vaultID,
+	).Scan(&title, &encryptedData, &entryType)
+	if err == sql.ErrNoRows {
+		http.Error(w, "revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	seq, err := nextChangeSeq(tx, vaultID)
+	if err != nil {
+		http.Error(w, "failed to restore revision", http.StatusInternalServerError)
+		return
+	}
+
+	current, err := h.saveEntryRevision(tx, vaultID, entryID, getDeviceID(r.Context()))
+	if err == sql.ErrNoRows {
+		http.Error(w, "entry not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to restore revision", http.StatusInternalServerError)
+		return
+	}
+	if expected != anyRevision && current != expected {
+		writeRevisionConflict(w, current)
+		return
+	}
+
+	entry := models.VaultEntryResponse{
+		EntryID:       entryID,
+		VaultID:       vaultID,
+		Title:         title,
+		EncryptedData: base64.StdEncoding.EncodeToString(encryptedData),
+		EntryType:     entryType,
+	}
+	err = tx.QueryRow(`
+		UPDATE vault_entries
+		SET title = $1, encrypted_data = $2, entry_type = $3, revision = revision + 1, change_seq = $4, updated_at = now()
+		WHERE entry_id::text = $5 AND vault_id = $6
+		RETURNING created_at, updated_at, revision`,
+		title, encryptedData, entryType, seq, entryID, vaultID,
+	).Scan(&entry.CreatedAt, &entry.UpdatedAt, &entry.Revision)
+	if err != nil {
+		http.Error(w, "failed to restore revision", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to restore revision", http.StatusInternalServerError)
+		return
+	}
+
+	h.publishVaultEvent(getSessionID(r.Context()), events.Event{Type: events.EntryUpdated, VaultID: vaultID, EntryID: entryID})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", entryETag(entry.Revision))
+	json.NewEncoder(w).Encode(entry)
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"backend/pswd/internal/auth"
+	"backend/pswd/internal/models"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// startSession opens a session for a freshly authenticated device, stores the first
+// refresh token of its family and sets both auth cookies. The session and its token are
+// stored together, so a failure can't leave a session nothing can refresh.
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, userID, username, deviceID string) (token, refreshToken string, err error) {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	sessionID, err := createSession(tx, r, userID, deviceID)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, err = storeRefreshToken(tx, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	token, err = auth.GenerateToken(userID, username, deviceID, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", "", err
+	}
+
+	setAuthCookies(w, token, refreshToken)
+	return token, refreshToken, nil
+}
+
+// createSession records a new server-side session for a freshly authenticated device
+func createSession(db dbExecutor, r *http.Request, userID, deviceID string) (string, error) {
+	var sessionID string
+	err := db.QueryRow(`
+		INSERT INTO sessions (user_id, device_id, user_agent, ip_address, expires_at)
+		VALUES ($1, $2, $3, $4, now() + ($5 * interval '1 second'))
+		RETURNING session_id`,
+		userID, deviceID, r.UserAgent(), clientIP(r), int64(auth.RefreshTokenTTL.Seconds()),
+	).Scan(&sessionID)
+	return sessionID, err
+}
+
+// storeRefreshToken generates a refresh token for a session and stores its hash
+func storeRefreshToken(db dbExecutor, sessionID string) (string, error) {
+	refreshToken, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO refresh_tokens (session_id, token_hash, expires_at)
+		VALUES ($1, $2, now() + ($3 * interval '1 second'))`,
+		sessionID, auth.HashToken(refreshToken), int64(auth.RefreshTokenTTL.Seconds()),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return refreshToken, nil
+}
+
+// setAuthCookies sets the access token and refresh token cookies for browser clients
+func setAuthCookies(w http.ResponseWriter, token, refreshToken string) {
+	auth.SetAuthCookie(w, token, int(auth.AccessTokenTTL.Seconds()))
+	auth.SetRefreshCookie(w, refreshToken, int(auth.RefreshTokenTTL.Seconds()))
+}
+
+// sessionState is what AuthMiddleware needs to know about the session behind a token
+type sessionState struct {
+	active       bool // Exists, belongs to the user and device and is neither revoked nor expired
+	deviceActive bool // The token's device hasn't been revoked
+	mfaEnabled   bool // The user has enrolled a second factor
+}
+
+// loadSession looks up the session and device behind a token
+func (h *Handler) loadSession(sessionID, userID, deviceID string) (sessionState, error) {
+	var state sessionState
+	err := h.DB.QueryRow(`
+		SELECT u.totp_enabled OR EXISTS (
+			SELECT 1 FROM webauthn_credentials wc WHERE wc.user_id = u.user_id
+		), EXISTS (
+			SELECT 1 FROM sessions s
+			WHERE s.session_id = $1 AND s.user_id = u.user_id AND s.device_id::text = $3
+			AND s.revoked_at IS NULL AND s.expires_at > now()
+		), EXISTS (
+			SELECT 1 FROM devices d
+			WHERE d.device_id::text = $3 AND d.user_id = u.user_id AND d.status = 'active'
+		)
+		FROM users u
+		WHERE u.user_id = $2`,
+		sessionID, userID, deviceID,
+	).Scan(&state.mfaEnabled, &state.active, &state.deviceActive)
+	if err == sql.ErrNoRows {
+		return sessionState{}, nil
+	}
+	return state, err
+}
+
+// ListSessionsHandler returns the active sessions of the current user
+func (h *Handler) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	currentSessionID := getSessionID(r.Context())
+
+	rows, err := h.DB.Query(`
+		SELECT s.session_id, s.device_id, d.device_name, COALESCE(s.user_agent, ''),
+			COALESCE(s.ip_address, ''), s.created_at, s.expires_at
+		FROM sessions s
+		JOIN devices d ON d.device_id = s.device_id
+		WHERE s.user_id = $1 AND s.revoked_at IS NULL AND s.expires_at > now()
+		ORDER BY s.created_at DESC`,
+		userID,
+	)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	sessions := []models.SessionResponse{}
+	for rows.Next() {
+		var session models.SessionResponse
+		err := rows.Scan(&session.SessionID, &session.DeviceID, &session.DeviceName,
+			&session.UserAgent, &session.IPAddress, &session.CreatedAt, &session.ExpiresAt)
+		if err != nil {
+			continue
+		}
+
+		session.Current = session.SessionID == currentSessionID
+		sessions = append(sessions, session)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// RevokeSessionHandler revokes a single session of the current user
+func (h *Handler) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	sessionID := chi.URLParam(r, "sessionID")
+
+	result, err := h.DB.Exec(`
+		UPDATE sessions SET revoked_at = now()
+		WHERE session_id = $1 AND user_id = $2 AND revoked_at IS NULL`,
+		sessionID, userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	if sessionID == getSessionID(r.Context()) {
+		auth.ClearAuthCookie(w)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAllHandler revokes every session of the current user, including the current one
+func (h *Handler) LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+
+	_, err := h.DB.Exec(`
+		UPDATE sessions SET revoked_at = now()
+		WHERE user_id = $1 AND revoked_at IS NULL`,
+		userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	auth.ClearAuthCookie(w)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "logged out from all sessions"})
+}
+
+// clientIP returns the client address, honouring X-Forwarded-For like the rate limiter does
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"backend/pswd/internal/auth"
+	"backend/pswd/internal/events"
+	"backend/pswd/internal/models"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Vault roles. The owner is the user the vault belongs to; members are read or write.
+const (
+	vaultOwner = "owner"
+	vaultWrite = "write"
+	vaultRead  = "read"
+)
+
+// Errors from rotateMemberKeys that are the client's fault
+var (
+	errMissingMemberKey      = errors.New("every member needs an envelope for the new key")
+	errInvalidShareSignature = errors.New("invalid member envelope signature")
+)
+
+// GetUserKeysHandler returns a user's public keys, so the vault key can be sealed to
+// their pk_encrypt before inviting them
+func (h *Handler) GetUserKeysHandler(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+
+	var resp models.UserKeysResponse
+	err := h.DB.QueryRow(`
+		SELECT user_id, username, pk_encrypt, pk_sign FROM users WHERE username = $1`,
+		username,
+	).Scan(&resp.UserID, &resp.Username, &resp.PkEncrypt, &resp.PkSign)
+	if err == sql.ErrNoRows {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ShareVaultHandler invites another user to a vault (owner only). The owner seals the
+// vault key to the invitee's pk_encrypt and signs it with pk_sign; the signature is
+// checked here and again by the invitee before accepting.
+func (h *Handler) ShareVaultHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	vaultID := getVaultID(r.Context())
+
+	var req models.ShareVaultRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	if req.Role != vaultRead && req.Role != vaultWrite {
+		http.Error(w, "role must be read or write", http.StatusBadRequest)
+		return
+	}
+	if req.Username == "" || req.WrappedKey == "" || req.Signature == "" {
+		http.Error(w, "username, wrapped_key and signature are required", http.StatusBadRequest)
+		return
+	}
+
+	var inviteeID, ownerKey string
+	var keyVersion int
+	err := h.DB.QueryRow(`
+		SELECT i.user_id, o.pk_sign, v.key_version
+		FROM vaults v
+		JOIN users o ON o.user_id = v.user_id
+		JOIN users i ON i.username = $1
+		WHERE o.user_id = $2 AND v.vault_id = $3`,
+		req.Username, userID, vaultID,
+	).Scan(&inviteeID, &ownerKey, &keyVersion)
+	if err == sql.ErrNoRows {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if inviteeID == userID {
+		http.Error(w, "you already own this vault", http.StatusBadRequest)
+		return
+	}
+	if req.KeyVersion != keyVersion {
+		http.Error(w, "key_version is not the current vault key version", http.StatusConflict)
+		return
+	}
+
+	message := auth.VaultShareMessage(vaultID, inviteeID, req.KeyVersion, req.WrappedKey)
+	if !auth.VerifySignature(ownerKey, message, req.Signature) {
+		http.Error(w, "invalid signature", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	resp := models.VaultMemberResponse{UserID: inviteeID, Username: req.Username, Role: req.Role}
+	err = tx.QueryRow(`
+		INSERT INTO vault_members (vault_id, user_id, role, invited_by, wrapped_key, key_version, signature)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (vault_id, user_id) DO NOTHING
+		RETURNING status, created_at`,
+		vaultID, inviteeID, req.Role, userID, req.WrappedKey, req.KeyVersion, req.Signature,
+	).Scan(&resp.Status, &resp.CreatedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "user is already a member or invited", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to share vault", http.StatusInternalServerError)
+		return
+	}
+
+	if err := notify(tx, inviteeID, notifyVaultShared, "", vaultID); err != nil {
+		http.Error(w, "failed to share vault", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to share vault", http.StatusInternalServerError)
+		return
+	}
+
+	h.publishUserEvent("", inviteeID, events.Event{Type: events.ShareInvited, VaultID: vaultID})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ListVaultMembersHandler returns the owner and members of a vault, including pending invites
+func (h *Handler) ListVaultMembersHandler(w http.ResponseWriter, r *http.Request) {
+	vaultID := getVaultID(r.Context())
+
+	// The vault predates its invites, so the owner sorts first
+	rows, err := h.DB.Query(`
+		SELECT u.user_id, u.username, 'owner', 'accepted', v.created_at AS created_at, v.created_at AS accepted_at
+		FROM vaults v
+		JOIN users u ON u.user_id = v.user_id
+		WHERE v.vault_id = $1
+		UNION ALL
+		SELECT u.user_id, u.username, m.role, m.status, m.created_at, m.accepted_at
+		FROM vault_members m
+		JOIN users u ON u.user_id = m.user_id
+		WHERE m.vault_id = $1
+		ORDER BY created_at`,
+		vaultID,
+	)
+	if err != nil {
+		http.Error(w, "failed to fetch members", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	members := []models.VaultMemberResponse{}
+	for rows.Next() {
+		var m models.VaultMemberResponse
+		if err := rows.Scan(&m.UserID, &m.Username, &m.Role, &m.Status, &m.CreatedAt, &m.AcceptedAt); err != nil {
+			http.Error(w, "failed to read members", http.StatusInternalServerError)
+			return
+		}
+		members = append(members, m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// UpdateVaultMemberHandler changes a member's role (owner only)
+func (h *Handler) UpdateVaultMemberHandler(w http.ResponseWriter, r *http.Request) {
+	vaultID := getVaultID(r.Context())
+	memberID := chi.URLParam(r, "userID")
+
+	var req models.UpdateVaultMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	if req.Role != vaultRead && req.Role != vaultWrite {
+		http.Error(w, "role must be read or write", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.DB.Exec(`
+		UPDATE vault_members SET role = $1 WHERE vault_id = $2 AND user_id::text = $3`,
+		req.Role, vaultID, memberID,
+	)
+	if err != nil {
+		http.Error(w, "failed to update member", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "member not found", http.StatusNotFound)
+		return
+	}
+
+	h.publishVaultEvent(getSessionID(r.Context()), events.Event{Type: events.ShareChanged, VaultID: vaultID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveVaultMemberHandler removes a member or withdraws an invite. The owner can remove
+// anyone; a member can leave. A removed member may have kept the key, so the owner
+// should rotate it afterwards.
+func (h *Handler) RemoveVaultMemberHandler(w http.ResponseWriter, r *http.Request) {
+	vaultID := getVaultID(r.Context())
+	memberID := chi.URLParam(r, "userID")
+
+	if memberID != getUserID(r.Context()) && getVaultRole(r.Context()) != vaultOwner {
+		http.Error(w, "only the vault owner can do this", http.StatusForbidden)
+		return
+	}
+
+	result, err := h.DB.Exec(`
+		DELETE FROM vault_members WHERE vault_id = $1 AND user_id::text = $2`,
+		vaultID, memberID,
+	)
+	if err != nil {
+		http.Error(w, "failed to remove member", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "member not found", http.StatusNotFound)
+		return
+	}
+
+	// The removed member can no longer reach the vault, so tell them separately
+	e := events.Event{Type: events.ShareChanged, VaultID: vaultID}
+	h.publishVaultEvent(getSessionID(r.Context()), e)
+	h.publishUserEvent(getSessionID(r.Context()), memberID, e)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListVaultInvitesHandler returns the vault invites waiting for the current user
+func (h *Handler) ListVaultInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+
+	rows, err := h.DB.Query(`
+		SELECT v.vault_id, v.name, o.user_id, o.username, o.pk_sign,
+			m.role, m.wrapped_key, m.key_version, m.signature, m.created_at
+		FROM vault_members m
+		JOIN vaults v ON v.vault_id = m.vault_id
+		JOIN users o ON o.user_id = v.user_id
+		WHERE m.user_id = $1 AND m.status = 'pending'
+		ORDER BY m.created_at`,
+		userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to fetch invites", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	invites := []models.VaultInviteResponse{}
+	for rows.Next() {
+		var i models.VaultInviteResponse
+		if err := rows.Scan(&i.VaultID, &i.VaultName, &i.OwnerID, &i.OwnerUsername, &i.OwnerPkSign,
+			&i.Role, &i.WrappedKey, &i.KeyVersion, &i.Signature, &i.CreatedAt); err != nil {
+			http.Error(w, "failed to read invites", http.StatusInternalServerError)
+			return
+		}
+		invites = append(invites, i)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+// AcceptVaultInviteHandler accepts an invite. The client should have checked the
+// owner's signature over the envelope first.
+func (h *Handler) AcceptVaultInviteHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	vaultID := chi.URLParam(r, "vaultID")
+
+	result, err := h.DB.Exec(`
+		UPDATE vault_members SET status = 'accepted', accepted_at = now()
+		WHERE vault_id::text = $1 AND user_id = $2 AND status = 'pending'`,
+		vaultID, userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to accept invite", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "invite not found", http.StatusNotFound)
+		return
+	}
+
+	h.publishVaultEvent(getSessionID(r.Context()), events.Event{Type: events.ShareChanged, VaultID: vaultID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeclineVaultInviteHandler declines an invite, deleting it and its envelope
+func (h *Handler) DeclineVaultInviteHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	vaultID := chi.URLParam(r, "vaultID")
+
+	result, err := h.DB.Exec(`
+		DELETE FROM vault_members
+		WHERE vault_id::text = $1 AND user_id = $2 AND status = 'pending'`,
+		vaultID, userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to decline invite", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "invite not found", http.StatusNotFound)
+		return
+	}
+
+	h.publishVaultEvent(getSessionID(r.Context()), events.Event{Type: events.ShareChanged, VaultID: vaultID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rotateMemberKeys replaces every member's envelope during a key rotation. Each new
+// envelope must be signed by the owner, and no member may be left on the old key.
+func rotateMemberKeys(tx *sql.Tx, ownerID, vaultID string, keyVersion int, envelopes []models.MemberKeyEnvelope) error {
+	var ownerKey string
+	var memberCount int
+	err := tx.QueryRow(`
+		SELECT u.pk_sign, (SELECT count(*) FROM vault_members m WHERE m.vault_id = $2)
+		FROM users u WHERE u.user_id = $1`,
+		ownerID, vaultID,
+	).Scan(&ownerKey, &memberCount)
+	if err != nil {
+		return err
+	}
+	if len(envelopes) != memberCount {
+		return errMissingMemberKey
+	}
+
+	for _, envelope := range envelopes {
+		message := auth.VaultShareMessage(vaultID, envelope.UserID, keyVersion, envelope.WrappedKey)
+		if !auth.VerifySignature(ownerKey, message, envelope.Signature) {
+			return errInvalidShareSignature
+		}
+
+		result, err := tx.Exec(`
+			UPDATE vault_members SET wrapped_key = $1, key_version = $2, signature = $3
+			WHERE vault_id = $4 AND user_id::text = $5 AND key_version <> $2`,
+			envelope.WrappedKey, keyVersion, envelope.Signature, vaultID, envelope.UserID,
+		)
+		if err != nil {
+			return err
+		}
+		rowsAffected, _ := result.RowsAffected()
+		if rowsAffected == 0 {
+			return errMissingMemberKey
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"backend/pswd/internal/auth"
+	"backend/pswd/internal/models"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// SRPInitHandler starts an SRP-6a login. Unknown users, unknown devices and users
+// without an SRP verifier get a decoy handshake that can never succeed, so the
+// response doesn't reveal which accounts exist.
+func (h *Handler) SRPInitHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.SRPInitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	if err := auth.ValidateSRPEphemeral(req.A); err != nil {
+		http.Error(w, "invalid srp parameters", http.StatusBadRequest)
+		return
+	}
+
+	var userID, deviceID, salt, verifier string
+	err := h.DB.QueryRow(`
+		SELECT u.user_id, d.device_id, u.srp_salt, u.srp_verifier
+		FROM users u
+		JOIN devices d ON d.user_id = u.user_id
+		WHERE u.username = $1 AND d.device_fingerprint = $2 AND d.status = 'active'
+		AND u.srp_verifier IS NOT NULL`,
+		req.Username, req.DeviceFingerprint,
+	).Scan(&userID, &deviceID, &salt, &verifier)
+
+	if err == sql.ErrNoRows {
+		fakeB, err := auth.FakeSRPEphemeral()
+		if err != nil {
+			http.Error(w, "failed to start handshake", http.StatusInternalServerError)
+			return
+		}
+		writeSRPInit(w, models.SRPInitResponse{
+			HandshakeID: uuid.NewString(),
+			Salt:        auth.FakeSRPSalt(req.Username),
+			B:           fakeB,
+		})
+		return
+	}
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	// A is already valid, so this only fails on a bad stored verifier
+	server, err := auth.NewSRPServer(req.Username, salt, verifier, req.A)
+	if err != nil {
+		http.Error(w, "failed to start handshake", http.StatusInternalServerError)
+		return
+	}
+
+	var handshakeID string
+	err = h.DB.QueryRow(`
+		INSERT INTO srp_handshakes (user_id, device_id, a_pub, b_pub, b_secret, expires_at)
+		VALUES ($1, $2, $3, $4, $5, now() + ($6 * interval '1 second'))
+		RETURNING handshake_id`,
+		userID, deviceID, server.A, server.B, server.SecretEphemeral(), int64(auth.SRPHandshakeTTL.Seconds()),
+	).Scan(&handshakeID)
+	if err != nil {
+		http.Error(w, "failed to start handshake", http.StatusInternalServerError)
+		return
+	}
+
+	writeSRPInit(w, models.SRPInitResponse{
+		HandshakeID: handshakeID,
+		Salt:        salt,
+		B:           server.B,
+	})
+}
+
+func writeSRPInit(w http.ResponseWriter, resp models.SRPInitResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// SRPVerifyHandler checks the client proof of an SRP handshake and, on success,
+// logs the device in and returns the server proof
+func (h *Handler) SRPVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.SRPVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	server, userID, deviceID, isMaster, err := h.consumeSRPHandshake(req.HandshakeID)
+	if err != nil {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	serverProof, err := server.VerifyClientProof(req.M1)
+	if err != nil {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	h.completeLogin(w, r, models.LoginResponse{
+		UserID:      userID,
+		Username:    server.Username,
+		DeviceID:    deviceID,
+		IsMaster:    isMaster,
+		ServerProof: serverProof,
+	})
+}
+
+// consumeSRPHandshake loads a pending handshake and marks it used, so every
+// handshake gets exactly one proof attempt
+func (h *Handler) consumeSRPHandshake(handshakeID string) (*auth.SRPServer, string, string, bool, error) {
+	var userID, deviceID, aPub, bPub, bSecret string
+	err := h.DB.QueryRow(`
+		UPDATE srp_handshakes SET used_at = now()
+		WHERE handshake_id::text = $1 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id, device_id, a_pub, b_pub, b_secret`,
+		handshakeID,
+	).Scan(&userID, &deviceID, &aPub, &bPub, &bSecret)
+	if err != nil {
+		return nil, "", "", false, err
+	}
+
+	var username, salt, verifier string
+	var isMaster bool
+	err = h.DB.QueryRow(`
+		SELECT u.username, u.srp_salt, u.srp_verifier, d.is_master
+		FROM users u
+		JOIN devices d ON d.user_id = u.user_id
+		WHERE u.user_id = $1 AND d.device_id = $2 AND d.status = 'active'
+		AND u.srp_verifier IS NOT NULL`,
+		userID, deviceID,
+	).Scan(&username, &salt, &verifier, &isMaster)
+	if err != nil {
+		return nil, "", "", false, err
+	}
+
+	server, err := auth.ResumeSRPServer(username, salt, verifier, aPub, bPub, bSecret)
+	if err != nil {
+		return nil, "", "", false, err
+	}
+
+	return server, userID, deviceID, isMaster, nil
+}
+
+// SRPEnrollHandler migrates the current password account to SRP: the password is
+// verified one last time, the SRP verifier is stored and the password hash is deleted
+func (h *Handler) SRPEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+
+	var req models.SRPEnrollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	if err := auth.ValidateSRPVerifier(req.SRPSalt, req.SRPVerifier); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var passwordHash sql.NullString
+	err := h.DB.QueryRow(`
+		SELECT password_hash FROM users WHERE user_id = $1`,
+		userID,
+	).Scan(&passwordHash)
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	if !passwordHash.Valid {
+		http.Error(w, "account already uses srp", http.StatusConflict)
+		return
+	}
+	if !auth.VerifyPassword(req.Password, passwordHash.String) {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	_, err = h.DB.Exec(`
+		UPDATE users
+		SET srp_salt = $1, srp_verifier = $2, password_hash = NULL
+		WHERE user_id = $3`,
+		req.SRPSalt, req.SRPVerifier, userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to enroll srp", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "srp enrolled; password login disabled"})
+}
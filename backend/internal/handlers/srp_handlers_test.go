@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// srpHandshakeStore fakes one srp_handshakes row and the user and device it belongs to
+type srpHandshakeStore struct {
+	handshakeID string
+	used        bool
+	expired     bool
+}
+
+func (s *srpHandshakeStore) handle(query string, args []driver.Value) (fakeResult, error) {
+	switch {
+	case strings.Contains(query, "UPDATE srp_handshakes SET used_at"):
+		result := fakeResult{columns: []string{"user_id", "device_id", "a_pub", "b_pub", "b_secret"}}
+		if args[0] != s.handshakeID || s.used || s.expired {
+			return result, nil
+		}
+		s.used = true
+		result.rows = [][]driver.Value{{"user", "device", "0a", "0b", "0c"}}
+		return result, nil
+
+	case strings.Contains(query, "SELECT u.username, u.srp_salt, u.srp_verifier, d.is_master"):
+		return fakeResult{
+			columns: []string{"username", "srp_salt", "srp_verifier", "is_master"},
+			rows:    [][]driver.Value{{"alice", "00112233445566778899aabbccddeeff", "0d", true}},
+		}, nil
+	}
+	return fakeResult{}, fmt.Errorf("unexpected statement: %s", query)
+}
+
+func TestConsumeSRPHandshakeOnce(t *testing.T) {
+	store := &srpHandshakeStore{handshakeID: "hs"}
+	h := &Handler{DB: openFakeDB(t, store.handle)}
+
+	server, userID, deviceID, isMaster, err := h.consumeSRPHandshake("hs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != "user" || deviceID != "device" || !isMaster {
+		t.Errorf("got user %q, device %q, master %v", userID, deviceID, isMaster)
+	}
+	if server.Username != "alice" || server.A != "0a" || server.B != "0b" {
+		t.Errorf("handshake was not resumed from its row: %+v", server)
+	}
+
+	// A replayed handshake finds no unused row, whatever proof comes with it
+	if _, _, _, _, err := h.consumeSRPHandshake("hs"); err != sql.ErrNoRows {
+		t.Errorf("replayed handshake: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestConsumeSRPHandshakeExpired(t *testing.T) {
+	store := &srpHandshakeStore{handshakeID: "hs", expired: true}
+	h := &Handler{DB: openFakeDB(t, store.handle)}
+
+	if _, _, _, _, err := h.consumeSRPHandshake("hs"); err != sql.ErrNoRows {
+		t.Errorf("expired handshake: got %v, want sql.ErrNoRows", err)
+	}
+	if _, _, _, _, err := h.consumeSRPHandshake("other"); err != sql.ErrNoRows {
+		t.Errorf("unknown handshake: got %v, want sql.ErrNoRows", err)
+	}
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"backend/pswd/internal/models"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// nextChangeSeq takes the next change number of a vault for a write to its entries. Every
+// insert, update or delete of an entry stamps it on the row or its tombstone. The vault
+// row stays locked until the transaction ends, so a vault's changes commit in sequence
+// order and a cursor never skips one that commits late. Call it before locking entries.
+func nextChangeSeq(db dbExecutor, vaultID string) (int64, error) {
+	var seq int64
+	err := db.QueryRow(`
+		UPDATE vaults SET change_seq = change_seq + 1 WHERE vault_id = $1
+		RETURNING change_seq`,
+		vaultID,
+	).Scan(&seq)
+	return seq, err
+}
+
+// purgeEntries permanently deletes the entries of a vault that have been in the trash for
+// at least minAge, only entryID's if it isn't empty, leaving tombstones for sync. It
+// returns how many were deleted.
+func purgeEntries(tx *sql.Tx, vaultID, entryID string, minAge time.Duration) (int64, error) {
+	seq, err := nextChangeSeq(tx, vaultID)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec(`
+		WITH purged AS (
+			DELETE FROM vault_entries
+			WHERE vault_id = $1 AND ($2 = '' OR entry_id::text = $2)
+				AND deleted_at <= now() - ($3 * interval '1 second')
+			RETURNING entry_id, vault_id
+		)
+		INSERT INTO vault_entry_tombstones (entry_id, vault_id, change_seq)
+		SELECT entry_id, vault_id, $4 FROM purged`,
+		vaultID, entryID, int(minAge.Seconds()), seq,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// purgeTombstones deletes the tombstones older than h.TombstoneRetention and records, per
+// vault, the newest change number dropped, so SyncHandler can turn away cursors that
+// would miss those deletions
+func (h *Handler) purgeTombstones() (int64, error) {
+	result, err := h.DB.Exec(`
+		WITH purged AS (
+			DELETE FROM vault_entry_tombstones
+			WHERE created_at <= now() - ($1 * interval '1 second')
+			RETURNING vault_id, change_seq
+		)
+		UPDATE vaults v SET tombstones_purged_seq = p.change_seq
+		FROM (SELECT vault_id, max(change_seq) AS change_seq FROM purged GROUP BY vault_id) p
+		WHERE v.vault_id = p.vault_id AND v.tombstones_purged_seq < p.change_seq`,
+		int(h.TombstoneRetention.Seconds()),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// decodeSyncCursor reads the vault_id to change_seq map a sync cursor stands for. An
+// empty cursor means nothing has been synced yet.
+func decodeSyncCursor(cursor string) (map[string]int64, error) {
+	since := map[string]int64{}
+	if cursor == "" {
+		return since, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &since); err != nil {
+		return nil, err
+	}
+	return since, nil
+}
+
+// encodeSyncCursor turns a vault_id to change_seq map into an opaque cursor
+func encodeSyncCursor(seqs map[string]int64) string {
+	raw, _ := json.Marshal(seqs)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// SyncHandler returns what changed in the user's vaults since a cursor: entries created
+// or updated (trashed ones have deleted_at set), tombstones for entries deleted for good,
+// and vaults the user no longer has access to. Without since, it returns every entry.
+// Tombstones are kept for TombstoneRetention; a cursor older than that gets 410 Gone,
+// and the client must sync from scratch.
+//
+// Changes are numbered per vault, since a shared vault's changes belong to all of its
+// users; the cursor carries the last number seen for each vault the user can reach.
+func (h *Handler) SyncHandler(w http.ResponseWriter, r *http.Request) {
+	since, err := decodeSyncCursor(r.URL.Query().Get("since"))
+	if err != nil {
+		http.Error(w, "invalid cursor", http.StatusBadRequest)
+		return
+	}
+
+	// One snapshot for the whole response, so the cursor matches the changes returned
+	tx, err := h.DB.BeginTx(r.Context(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	seqs, purged, err := accessibleVaultSeqs(r.Context(), tx, getUserID(r.Context()))
+	if err != nil {
+		http.Error(w, "failed to sync", http.StatusInternalServerError)
+		return
+	}
+
+	// Deletions the cursor hasn't seen may have lost their tombstones
+	for vaultID, last := range since {
+		if last > 0 && last < purged[vaultID] {
+			http.Error(w, "cursor expired; sync again without since", http.StatusGone)
+			return
+		}
+	}
+
+	resp := models.SyncResponse{
+		Entries:       []models.VaultEntryResponse{},
+		Tombstones:    []models.EntryTombstone{},
+		RemovedVaults: []string{},
+	}
+	for vaultID := range since {
+		if _, ok := seqs[vaultID]; !ok {
+			resp.RemovedVaults = append(resp.RemovedVaults, vaultID)
+		}
+	}
+
+	for vaultID, seq := range seqs {
+		// A first sync of a vault takes every entry, whatever its change number
+		last, seen := since[vaultID]
+		if !seen {
+			last = -1
+		}
+		if seq <= last {
+			continue
+		}
+
+		entries, err := changedEntries(r.Context(), tx, vaultID, last)
+		if err != nil {
+			http.Error(w, "failed to sync", http.StatusInternalServerError)
+			return
+		}
+		resp.Entries = append(resp.Entries, entries...)
+
+		// A first sync of a vault has nothing to delete locally
+		if last <= 0 {
+			continue
+		}
+		tombstones, err := changedTombstones(r.Context(), tx, vaultID, last)
+		if err != nil {
+			http.Error(w, "failed to sync", http.StatusInternalServerError)
+			return
+		}
+		resp.Tombstones = append(resp.Tombstones, tombstones...)
+	}
+
+	resp.Cursor = encodeSyncCursor(seqs)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// accessibleVaultSeqs returns the latest change number of every vault the user can reach,
+// and the newest one whose tombstone has been purged (see purgeTombstones)
+func accessibleVaultSeqs(ctx context.Context, tx *sql.Tx, userID string) (map[string]int64, map[string]int64, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT v.vault_id, v.change_seq, v.tombstones_purged_seq
+		FROM vaults v
+		WHERE v.vault_id IN (SELECT vault_id FROM vault_access WHERE user_id = $1)`,
+		userID,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	seqs := map[string]int64{}
+	purged := map[string]int64{}
+	for rows.Next() {
+		var vaultID string
+		var seq, purgedSeq int64
+		if err := rows.Scan(&vaultID, &seq, &purgedSeq); err != nil {
+			return nil, nil, err
+		}
+		seqs[vaultID] = seq
+		purged[vaultID] = purgedSeq
+	}
+	return seqs, purged, rows.Err()
+}
+
+// changedEntries returns a vault's entries changed after change number since, trash
+// included
+func changedEntries(ctx context.Context, tx *sql.Tx, vaultID string, since int64) ([]models.VaultEntryResponse, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT entry_id, vault_id, title, encrypted_data, entry_type, revision, created_at, updated_at, deleted_at
+		FROM vault_entries
+		WHERE vault_id = $1 AND change_seq > $2
+		ORDER BY change_seq`,
+		vaultID, since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.VaultEntryResponse
+	for rows.Next() {
+		var entry models.VaultEntryResponse
+		var encryptedData []byte
+		err := rows.Scan(&entry.EntryID, &entry.VaultID, &entry.Title, &encryptedData,
+			&entry.EntryType, &entry.Revision, &entry.CreatedAt, &entry.UpdatedAt, &entry.DeletedAt)
+		if err != nil {
+			return nil, err
+		}
+		entry.EncryptedData = base64.StdEncoding.EncodeToString(encryptedData)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// changedTombstones returns the entries of a vault deleted for good after change number
+// since
+func changedTombstones(ctx context.Context, tx *sql.Tx, vaultID string, since int64) ([]models.EntryTombstone, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT entry_id, vault_id, created_at
+		FROM vault_entry_tombstones
+		WHERE vault_id = $1 AND change_seq > $2
+		ORDER BY change_seq`,
+		vaultID, since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tombstones []models.EntryTombstone
+	for rows.Next() {
+		var t models.EntryTombstone
+		if err := rows.Scan(&t.EntryID, &t.VaultID, &t.DeletedAt); err != nil {
+			return nil, err
+		}
+		tombstones = append(tombstones, t)
+	}
+	return tombstones, rows.Err()
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"backend/pswd/internal/events"
+	"backend/pswd/internal/models"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ListTrashHandler returns the vault's deleted entries, most recently deleted first
+func (h *Handler) ListTrashHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query(`
+		SELECT entry_id, vault_id, title, encrypted_data, entry_type, revision, created_at, updated_at, deleted_at
+		FROM vault_entries
+		WHERE vault_id = $1 AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC`,
+		getVaultID(r.Context()),
+	)
+	if err != nil {
+		http.Error(w, "failed to fetch trash", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	entries := []models.VaultEntryResponse{}
+	for rows.Next() {
+		var entry models.VaultEntryResponse
+		var encryptedData []byte
+		err := rows.Scan(&entry.EntryID, &entry.VaultID, &entry.Title, &encryptedData,
+			&entry.EntryType, &entry.Revision, &entry.CreatedAt, &entry.UpdatedAt, &entry.DeletedAt)
+		if err != nil {
+			http.Error(w, "failed to read trash", http.StatusInternalServerError)
+			return
+		}
+		entry.EncryptedData = base64.StdEncoding.EncodeToString(encryptedData)
+		entries = append(entries, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// RestoreTrashEntryHandler moves an entry out of the trash
+func (h *Handler) RestoreTrashEntryHandler(w http.ResponseWriter, r *http.Request) {
+	vaultID := getVaultID(r.Context())
+	entryID := chi.URLParam(r, "entryID")
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	seq, err := nextChangeSeq(tx, vaultID)
+	if err != nil {
+		http.Error(w, "failed to restore entry", http.StatusInternalServerError)
+		return
+	}
+
+	result, err := tx.Exec(`
+		UPDATE vault_entries SET deleted_at = NULL, revision = revision + 1, change_seq = $1
+		WHERE entry_id::text = $2 AND vault_id = $3 AND deleted_at IS NOT NULL`,
+		seq, entryID, vaultID,
+	)
+	if err != nil {
+		http.Error(w, "failed to restore entry", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "entry not found in trash", http.StatusNotFound)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to restore entry", http.StatusInternalServerError)
+		return
+	}
+
+	h.publishVaultEvent(getSessionID(r.Context()), events.Event{Type: events.EntryUpdated, VaultID: vaultID, EntryID: entryID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PurgeTrashEntryHandler permanently deletes an entry from the trash, with its revisions
+func (h *Handler) PurgeTrashEntryHandler(w http.ResponseWriter, r *http.Request) {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	vaultID := getVaultID(r.Context())
+	entryID := chi.URLParam(r, "entryID")
+	purged, err := purgeEntries(tx, vaultID, entryID, 0)
+	if err != nil {
+		http.Error(w, "failed to delete entry", http.StatusInternalServerError)
+		return
+	}
+	if purged == 0 {
+		http.Error(w, "entry not found in trash", http.StatusNotFound)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to delete entry", http.StatusInternalServerError)
+		return
+	}
+
+	h.publishVaultEvent(getSessionID(r.Context()), events.Event{Type: events.EntryPurged, VaultID: vaultID, EntryID: entryID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EmptyTrashHandler permanently deletes every entry in the vault's trash
+func (h *Handler) EmptyTrashHandler(w http.ResponseWriter, r *http.Request) {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	vaultID := getVaultID(r.Context())
+	purged, err := purgeEntries(tx, vaultID, "", 0)
+	if err != nil {
+		http.Error(w, "failed to empty trash", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to empty trash", http.StatusInternalServerError)
+		return
+	}
+
+	if purged > 0 {
+		h.publishVaultEvent(getSessionID(r.Context()), events.Event{Type: events.EntryPurged, VaultID: vaultID})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StartTrashPurger permanently deletes, every interval, the entries that have been in the
+// trash for longer than TrashRetention, and the sync tombstones older than
+// TombstoneRetention
+func (h *Handler) StartTrashPurger(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			vaultIDs, err := h.vaultsWithExpiredTrash()
+			if err != nil {
+				log.Println("❌ Failed to purge trash:", err)
+				continue
+			}
+
+			var total int64
+			for _, vaultID := range vaultIDs {
+				purged, err := h.purgeVaultTrash(vaultID)
+				if err != nil {
+					log.Println("❌ Failed to purge trash:", err)
+					continue
+				}
+				total += purged
+			}
+			if total > 0 {
+				log.Printf("🗑️  Purged %d vault entries from the trash\n", total)
+			}
+
+			vaults, err := h.purgeTombstones()
+			if err != nil {
+				log.Println("❌ Failed to purge tombstones:", err)
+			} else if vaults > 0 {
+				log.Printf("🗑️  Purged expired tombstones of %d vaults\n", vaults)
+			}
+		}
+	}()
+}
+
+// vaultsWithExpiredTrash lists the vaults holding entries due for purging
+func (h *Handler) vaultsWithExpiredTrash() ([]string, error) {
+	rows, err := h.DB.Query(`
+		SELECT DISTINCT vault_id FROM vault_entries
+		WHERE deleted_at <= now() - ($1 * interval '1 second')`,
+		int(h.TrashRetention.Seconds()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vaultIDs []string
+	for rows.Next() {
+		var vaultID string
+		if err := rows.Scan(&vaultID); err != nil {
+			return nil, err
+		}
+		vaultIDs = append(vaultIDs, vaultID)
+	}
+	return vaultIDs, rows.Err()
+}
+
+// purgeVaultTrash purges one vault's expired trash in its own transaction
+func (h *Handler) purgeVaultTrash(vaultID string) (int64, error) {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	purged, err := purgeEntries(tx, vaultID, "", h.TrashRetention)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	if purged > 0 {
+		h.publishVaultEvent("", events.Event{Type: events.EntryPurged, VaultID: vaultID})
+	}
+	return purged, nil
+}
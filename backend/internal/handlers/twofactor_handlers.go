@@ -0,0 +1,464 @@
+package handlers
+
+import (
+	"backend/pswd/internal/auth"
+	"backend/pswd/internal/models"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// maxMFAAttempts is how many wrong codes an MFA challenge tolerates before it is burned
+const maxMFAAttempts = 5
+
+// totpIssuer is the account issuer shown in authenticator apps
+const totpIssuer = "pswd"
+
+// beginSecondFactor parks a successful first-factor login behind an MFA challenge
+func (h *Handler) beginSecondFactor(w http.ResponseWriter, resp models.LoginResponse, methods []string) {
+	mfaToken, err := auth.GenerateNonce()
+	if err != nil {
+		http.Error(w, "failed to start two-factor login", http.StatusInternalServerError)
+		return
+	}
+
+	var expiresAt time.Time
+	err = h.DB.QueryRow(`
+		INSERT INTO mfa_challenges (token_hash, user_id, device_id, expires_at)
+		VALUES ($1, $2, $3, now() + ($4 * interval '1 second'))
+		RETURNING expires_at`,
+		auth.HashToken(mfaToken), resp.UserID, resp.DeviceID, int64(auth.MFAChallengeTTL.Seconds()),
+	).Scan(&expiresAt)
+	if err != nil {
+		http.Error(w, "failed to start two-factor login", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.MFARequiredResponse{
+		MFARequired: true,
+		MFAToken:    mfaToken,
+		Methods:     methods,
+		ExpiresAt:   expiresAt,
+		ServerProof: resp.ServerProof,
+	})
+}
+
+// TwoFactorLoginHandler completes a login parked by beginSecondFactor
+func (h *Handler) TwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.TwoFactorLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var challengeID string
+	var attempts int
+	var resp models.LoginResponse
+	err = tx.QueryRow(`
+		SELECT c.challenge_id, c.attempts, c.user_id, u.username, c.device_id, d.is_master
+		FROM mfa_challenges c
+		JOIN users u ON u.user_id = c.user_id
+		JOIN devices d ON d.device_id = c.device_id
+		WHERE c.token_hash = $1 AND c.used_at IS NULL AND c.expires_at > now()
+		AND d.status = 'active'
+		FOR UPDATE OF c`,
+		auth.HashToken(req.MFAToken),
+	).Scan(&challengeID, &attempts, &resp.UserID, &resp.Username, &resp.DeviceID, &resp.IsMaster)
+	if err != nil {
+		http.Error(w, "invalid or expired mfa token", http.StatusUnauthorized)
+		return
+	}
+
+	ok, err := h.verifySecondFactor(tx, resp.UserID, req.TwoFactorCodeRequest)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	if !ok {
+		// Count the failure; the challenge is burned once the limit is reached
+		_, err = tx.Exec(`
+			UPDATE mfa_challenges
+			SET attempts = attempts + 1,
+				used_at = CASE WHEN attempts + 1 >= $1 THEN now() ELSE used_at END
+			WHERE challenge_id = $2`,
+			maxMFAAttempts, challengeID,
+		)
+		if err != nil || tx.Commit() != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "invalid two-factor code", http.StatusUnauthorized)
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE mfa_challenges SET used_at = now() WHERE challenge_id = $1`,
+		challengeID,
+	)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	h.issueLogin(w, r, resp)
+}
+
+// verifySecondFactor checks a TOTP code, a WebAuthn assertion or consumes a recovery code,
+// whichever is given. Accepted TOTP steps are recorded so the same code can't be used twice.
+func (h *Handler) verifySecondFactor(db dbExecutor, userID string, req models.TwoFactorCodeRequest) (bool, error) {
+	if req.WebAuthn != nil {
+		challenge, err := consumeWebAuthnChallenge(db, req.WebAuthn.ChallengeID, webAuthnMFA)
+		if err == sql.ErrNoRows || (err == nil && challenge.userID != userID) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		ok, _, err := verifyWebAuthnAssertion(db, challenge, req.WebAuthn.Credential, false)
+		return ok, err
+	}
+
+	if req.Code != "" {
+		var secret sql.NullString
+		var lastStep sql.NullInt64
+		err := db.QueryRow(`
+			SELECT totp_secret, totp_last_step FROM users
+			WHERE user_id = $1 AND totp_enabled`,
+			userID,
+		).Scan(&secret, &lastStep)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		step, ok := auth.ValidateTOTP(secret.String, req.Code, h.now(), lastStep.Int64)
+		if !ok {
+			return false, nil
+		}
+
+		result, err := db.Exec(`
+			UPDATE users SET totp_last_step = $1
+			WHERE user_id = $2 AND (totp_last_step IS NULL OR totp_last_step < $1)`,
+			step, userID,
+		)
+		if err != nil {
+			return false, err
+		}
+		rowsAffected, _ := result.RowsAffected()
+		return rowsAffected == 1, nil
+	}
+
+	if req.RecoveryCode != "" {
+		result, err := db.Exec(`
+			UPDATE recovery_codes SET used_at = now()
+			WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+			userID, auth.HashRecoveryCode(req.RecoveryCode),
+		)
+		if err != nil {
+			return false, err
+		}
+		rowsAffected, _ := result.RowsAffected()
+		return rowsAffected == 1, nil
+	}
+
+	return false, nil
+}
+
+// verifyReauth checks that the user behind a session is present before a change that
+// would outlive it: with a second factor if one is enrolled, otherwise with the password,
+// or a fresh SRP proof on SRP accounts
+func (h *Handler) verifyReauth(tx *sql.Tx, userID string, req models.ReauthRequest) (bool, error) {
+	enrolled, err := hasSecondFactor(tx, userID)
+	if err != nil {
+		return false, err
+	}
+	if enrolled {
+		return h.verifySecondFactor(tx, userID, req.TwoFactorCodeRequest)
+	}
+
+	var passwordHash sql.NullString
+	err = tx.QueryRow(`SELECT password_hash FROM users WHERE user_id = $1`, userID).Scan(&passwordHash)
+	if err != nil {
+		return false, err
+	}
+	if passwordHash.Valid {
+		return auth.VerifyPassword(req.Password, passwordHash.String), nil
+	}
+
+	server, handshakeUserID, _, _, err := h.consumeSRPHandshake(req.SRPHandshakeID)
+	if err != nil || handshakeUserID != userID {
+		return false, nil
+	}
+	_, err = server.VerifyClientProof(req.SRPM1)
+	return err == nil, nil
+}
+
+// GetTwoFactorStatusHandler describes the current user's second-factor setup
+func (h *Handler) GetTwoFactorStatusHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+
+	resp := models.TwoFactorStatusResponse{Required: h.Require2FA}
+	err := h.DB.QueryRow(`
+		SELECT u.totp_enabled,
+			(SELECT count(*) FROM webauthn_credentials wc WHERE wc.user_id = u.user_id),
+			(SELECT count(*) FROM recovery_codes rc WHERE rc.user_id = u.user_id AND rc.used_at IS NULL)
+		FROM users u
+		WHERE u.user_id = $1`,
+		userID,
+	).Scan(&resp.TOTPEnabled, &resp.WebAuthnCredentials, &resp.RecoveryCodesRemaining)
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// SetupTOTPHandler generates a TOTP secret for the current user. It only takes
+// effect once confirmed with a valid code (see ConfirmTOTPHandler).
+func (h *Handler) SetupTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		http.Error(w, "failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+
+	var username string
+	err = h.DB.QueryRow(`
+		UPDATE users SET totp_secret = $1, totp_last_step = NULL
+		WHERE user_id = $2 AND NOT totp_enabled
+		RETURNING username`,
+		secret, userID,
+	).Scan(&username)
+	if err == sql.ErrNoRows {
+		http.Error(w, "totp already enabled", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to store secret", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.TOTPSetupResponse{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(totpIssuer, username, secret),
+	})
+}
+
+// ConfirmTOTPHandler enables TOTP once the user proves their app produces valid
+// codes, and issues the recovery codes
+func (h *Handler) ConfirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+
+	var req models.TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var secret sql.NullString
+	var enabled bool
+	err = tx.QueryRow(`
+		SELECT totp_secret, totp_enabled FROM users WHERE user_id = $1 FOR UPDATE`,
+		userID,
+	).Scan(&secret, &enabled)
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	if enabled {
+		http.Error(w, "totp already enabled", http.StatusConflict)
+		return
+	}
+	if !secret.Valid {
+		http.Error(w, "totp setup not started", http.StatusBadRequest)
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(secret.String, req.Code, h.now(), 0)
+	if !ok {
+		http.Error(w, "invalid two-factor code", http.StatusUnauthorized)
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users SET totp_enabled = true, totp_last_step = $1 WHERE user_id = $2`,
+		step, userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to enable totp", http.StatusInternalServerError)
+		return
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		http.Error(w, "failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to enable totp", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTOTPHandler turns off TOTP after checking a current code or a recovery code.
+// Not allowed while the server requires 2FA for everyone.
+func (h *Handler) DisableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+
+	if h.Require2FA {
+		http.Error(w, "two-factor authentication is required on this server", http.StatusForbidden)
+		return
+	}
+
+	var req models.TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	ok, err := h.verifySecondFactor(tx, userID, req)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "invalid two-factor code", http.StatusUnauthorized)
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users SET totp_enabled = false, totp_secret = NULL, totp_last_step = NULL
+		WHERE user_id = $1`,
+		userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to disable totp", http.StatusInternalServerError)
+		return
+	}
+
+	// Recovery codes stay valid while a WebAuthn credential remains enrolled
+	_, err = tx.Exec(`
+		DELETE FROM recovery_codes
+		WHERE user_id = $1 AND NOT EXISTS (
+			SELECT 1 FROM webauthn_credentials WHERE user_id = $1
+		)`,
+		userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to disable totp", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to disable totp", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodesHandler replaces all recovery codes after checking the second factor
+func (h *Handler) RegenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+
+	var req models.TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	ok, err := h.verifySecondFactor(tx, userID, req)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "invalid two-factor code", http.StatusUnauthorized)
+		return
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		http.Error(w, "failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// replaceRecoveryCodes deletes a user's recovery codes and stores the hashes of a fresh set
+func replaceRecoveryCodes(db dbExecutor, userID string) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		_, err := db.Exec(`
+			INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`,
+			userID, auth.HashRecoveryCode(code),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
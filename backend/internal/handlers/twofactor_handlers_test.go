@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"backend/pswd/internal/auth"
+	"backend/pswd/internal/models"
+	"crypto/hmac"
+	"crypto/sha1"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// twoFactorStore fakes the users and recovery_codes rows verifySecondFactor reads and
+// updates
+type twoFactorStore struct {
+	totpSecret    string
+	totpLastStep  *int64
+	recoveryCodes map[string]bool // code hash -> used
+}
+
+func (s *twoFactorStore) db(t *testing.T) *sql.DB {
+	return openFakeDB(t, s.handle)
+}
+
+func (s *twoFactorStore) handle(query string, args []driver.Value) (fakeResult, error) {
+	switch {
+	case strings.Contains(query, "SELECT totp_secret, totp_last_step FROM users"):
+		result := fakeResult{columns: []string{"totp_secret", "totp_last_step"}}
+		if s.totpSecret == "" {
+			return result, nil
+		}
+		var lastStep driver.Value
+		if s.totpLastStep != nil {
+			lastStep = *s.totpLastStep
+		}
+		result.rows = [][]driver.Value{{s.totpSecret, lastStep}}
+		return result, nil
+
+	case strings.Contains(query, "UPDATE users SET totp_last_step"):
+		step := args[0].(int64)
+		if s.totpLastStep != nil && *s.totpLastStep >= step {
+			return fakeResult{}, nil
+		}
+		s.totpLastStep = &step
+		return fakeResult{affected: 1}, nil
+
+	case strings.Contains(query, "UPDATE recovery_codes SET used_at"):
+		hash := args[1].(string)
+		used, ok := s.recoveryCodes[hash]
+		if !ok || used {
+			return fakeResult{}, nil
+		}
+		s.recoveryCodes[hash] = true
+		return fakeResult{affected: 1}, nil
+	}
+	return fakeResult{}, fmt.Errorf("unexpected statement: %s", query)
+}
+
+// totpAt computes the 6-digit code for key at t, independently of the auth package
+func totpAt(key []byte, t time.Time) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(t.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+func TestVerifySecondFactorTOTP(t *testing.T) {
+	key := []byte("12345678901234567890")
+	store := &twoFactorStore{
+		totpSecret: base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key),
+	}
+	db := store.db(t)
+
+	clock := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	h := &Handler{Now: func() time.Time { return clock }}
+
+	verify := func(code string) bool {
+		t.Helper()
+		ok, err := h.verifySecondFactor(db, "user", models.TwoFactorCodeRequest{Code: code})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	if verify(totpAt(key, clock.Add(5*time.Minute))) {
+		t.Fatal("future code was accepted")
+	}
+
+	code := totpAt(key, clock)
+	if !verify(code) {
+		t.Fatal("current code was rejected")
+	}
+	if store.totpLastStep == nil || *store.totpLastStep != clock.Unix()/30 {
+		t.Fatalf("totp_last_step was not recorded: %v", store.totpLastStep)
+	}
+
+	// Replaying the code within its step, or the previous step's code, fails
+	clock = clock.Add(10 * time.Second)
+	if verify(code) {
+		t.Error("code was accepted twice")
+	}
+	if verify(totpAt(key, clock.Add(-30*time.Second))) {
+		t.Error("previous code was accepted after a newer one")
+	}
+
+	// The code is checked against the injected clock, not the system one
+	clock = clock.Add(30 * time.Second)
+	if !verify(totpAt(key, clock)) {
+		t.Error("next code was rejected")
+	}
+	clock = clock.Add(10 * time.Minute)
+	if verify(totpAt(key, clock.Add(-2*time.Minute))) {
+		t.Error("code outside the window was accepted")
+	}
+}
+
+func TestVerifySecondFactorTOTPNotEnabled(t *testing.T) {
+	db := (&twoFactorStore{}).db(t)
+
+	h := &Handler{}
+	ok, err := h.verifySecondFactor(db, "user", models.TwoFactorCodeRequest{Code: "123456"})
+	if err != nil || ok {
+		t.Errorf("got %v, %v; want a rejection", ok, err)
+	}
+}
+
+func TestVerifySecondFactorRecoveryCodeSingleUse(t *testing.T) {
+	codes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &twoFactorStore{recoveryCodes: map[string]bool{}}
+	for _, code := range codes {
+		store.recoveryCodes[auth.HashRecoveryCode(code)] = false
+	}
+	db := store.db(t)
+
+	h := &Handler{}
+	verify := func(code string) bool {
+		t.Helper()
+		ok, err := h.verifySecondFactor(db, "user", models.TwoFactorCodeRequest{RecoveryCode: code})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	if !verify(codes[0]) {
+		t.Fatal("recovery code was rejected")
+	}
+	if verify(codes[0]) {
+		t.Error("recovery code was accepted twice")
+	}
+	// Typed differently, it is still the same code
+	if verify(strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))) {
+		t.Error("reformatted recovery code was accepted again")
+	}
+	if !verify(codes[1]) {
+		t.Error("another recovery code was rejected")
+	}
+	if verify("aaaaa-aaaaa") {
+		t.Error("unknown recovery code was accepted")
+	}
+}
+
+func TestVerifySecondFactorWithoutCode(t *testing.T) {
+	db := (&twoFactorStore{}).db(t)
+
+	h := &Handler{}
+	ok, err := h.verifySecondFactor(db, "user", models.TwoFactorCodeRequest{})
+	if err != nil || ok {
+		t.Errorf("got %v, %v; want a rejection", ok, err)
+	}
+}
@@ -12,12 +12,13 @@ func (h *Handler) GetUserInfoHandler(w http.ResponseWriter, r *http.Request) {
 
 	var user models.User
 	err := h.DB.QueryRow(`
-		SELECT user_id, username, email, pk_encrypt, pk_sign, is_master_device_registered, created_at
+		SELECT user_id, username, email, pk_encrypt, pk_sign, COALESCE(encrypted_private_keys, ''),
+			is_master_device_registered, created_at
 		FROM users
 		WHERE user_id = $1`,
 		userID,
 	).Scan(&user.UserID, &user.Username, &user.Email, &user.PkEncrypt,
-		&user.PkSign, &user.IsMasterDeviceRegistered, &user.CreatedAt)
+		&user.PkSign, &user.EncryptedPrivateKeys, &user.IsMasterDeviceRegistered, &user.CreatedAt)
 
 	if err != nil {
 		http.Error(w, "user not found", http.StatusNotFound)
@@ -35,6 +36,28 @@ func (h *Handler) EraseDBDataHandler(w http.ResponseWriter, r *http.Request) {
 		TRUNCATE TABLE devices CASCADE;
 		TRUNCATE TABLE vaults CASCADE;
 		TRUNCATE TABLE vault_entries CASCADE;
+		TRUNCATE TABLE sessions CASCADE;
+		TRUNCATE TABLE refresh_tokens CASCADE;
+		TRUNCATE TABLE auth_challenges CASCADE;
+		TRUNCATE TABLE srp_handshakes CASCADE;
+		TRUNCATE TABLE recovery_codes CASCADE;
+		TRUNCATE TABLE mfa_challenges CASCADE;
+		TRUNCATE TABLE webauthn_credentials CASCADE;
+		TRUNCATE TABLE webauthn_challenges CASCADE;
+		TRUNCATE TABLE master_transfers CASCADE;
+		TRUNCATE TABLE master_recoveries CASCADE;
+		TRUNCATE TABLE account_notifications CASCADE;
+		TRUNCATE TABLE pairing_sessions CASCADE;
+		TRUNCATE TABLE pairing_messages CASCADE;
+		TRUNCATE TABLE vault_keys CASCADE;
+		TRUNCATE TABLE vault_members CASCADE;
+		TRUNCATE TABLE organizations CASCADE;
+		TRUNCATE TABLE org_members CASCADE;
+		TRUNCATE TABLE teams CASCADE;
+		TRUNCATE TABLE team_members CASCADE;
+		TRUNCATE TABLE team_vaults CASCADE;
+		TRUNCATE TABLE vault_entry_revisions CASCADE;
+		TRUNCATE TABLE vault_entry_tombstones CASCADE;
 	`)
 	if err != nil {
 		http.Error(w, "failed to erase database data", http.StatusInternalServerError)
@@ -1,17 +1,201 @@
 package handlers
 
 import (
+	"backend/pswd/internal/events"
 	"backend/pswd/internal/models"
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 )
 
+// defaultVaultName is the name of the vault created at registration
+const defaultVaultName = "Personal"
+
+// errStaleRevision is returned when a write's revision precondition doesn't match
+var errStaleRevision = errors.New("entry was changed by someone else")
+
+// Expected revisions for If-Match values that aren't a revision ETag: "*" matches
+// whatever revision the entry is at, and a weak ETag matches none, since If-Match uses
+// the strong comparison (RFC 9110, 13.1.1)
+const (
+	anyRevision  = 0
+	weakRevision = -1
+)
+
+// ListVaultsHandler returns the user's own vaults, default vault first, followed by the
+// vaults shared with them directly or through a team
+func (h *Handler) ListVaultsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	deviceID := getDeviceID(r.Context())
+
+	rows, err := h.DB.Query(`
+		SELECT v.vault_id, v.name, v.is_default AND a.role = 'owner', a.role, v.key_version,
+			(SELECT count(*) FROM vault_entries e WHERE e.vault_id = v.vault_id AND e.deleted_at IS NULL),
+			a.role <> 'owner' OR EXISTS (
+				SELECT 1 FROM vault_keys vk WHERE vk.vault_id = v.vault_id AND vk.device_id = $2
+			),
+			v.created_at
+		FROM (
+			SELECT DISTINCT ON (vault_id) vault_id, role, rank FROM vault_access
+			WHERE user_id = $1
+			ORDER BY vault_id, rank DESC
+		) a
+		JOIN vaults v ON v.vault_id = a.vault_id
+		ORDER BY a.role = 'owner' DESC, v.is_default DESC, v.created_at`,
+		userID, deviceID,
+	)
+	if err != nil {
+		http.Error(w, "failed to fetch vaults", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	vaults := []models.VaultResponse{}
+	for rows.Next() {
+		var v models.VaultResponse
+		if err := rows.Scan(&v.VaultID, &v.Name, &v.IsDefault, &v.Role, &v.KeyVersion, &v.EntryCount, &v.HasKey, &v.CreatedAt); err != nil {
+			http.Error(w, "failed to read vaults", http.StatusInternalServerError)
+			return
+		}
+		vaults = append(vaults, v)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(vaults)
+}
+
+// CreateVaultHandler creates a vault. The client generates its key and seals it to the
+// current device; other devices get envelopes through PutVaultKeyHandler.
+func (h *Handler) CreateVaultHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	deviceID := getDeviceID(r.Context())
+
+	var req models.CreateVaultRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || req.WrappedKey == "" {
+		http.Error(w, "name and wrapped_key are required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	resp := models.VaultResponse{Name: req.Name, Role: vaultOwner, HasKey: true}
+	err = tx.QueryRow(`
+		INSERT INTO vaults (user_id, name) VALUES ($1, $2)
+		RETURNING vault_id, key_version, created_at`,
+		userID, req.Name,
+	).Scan(&resp.VaultID, &resp.KeyVersion, &resp.CreatedAt)
+	if err != nil {
+		http.Error(w, "failed to create vault", http.StatusInternalServerError)
+		return
+	}
+
+	if err := putVaultKey(tx, resp.VaultID, deviceID, req.WrappedKey, 0); err != nil {
+		http.Error(w, "failed to create vault", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to create vault", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetVaultHandler describes a single vault
+func (h *Handler) GetVaultHandler(w http.ResponseWriter, r *http.Request) {
+	vaultID := getVaultID(r.Context())
+	deviceID := getDeviceID(r.Context())
+
+	v := models.VaultResponse{Role: getVaultRole(r.Context())}
+	err := h.DB.QueryRow(`
+		SELECT v.vault_id, v.name, v.is_default AND $3 = 'owner', v.key_version,
+			(SELECT count(*) FROM vault_entries e WHERE e.vault_id = v.vault_id AND e.deleted_at IS NULL),
+			$3 <> 'owner' OR EXISTS (
+				SELECT 1 FROM vault_keys vk WHERE vk.vault_id = v.vault_id AND vk.device_id = $2
+			),
+			v.created_at
+		FROM vaults v
+		WHERE v.vault_id = $1`,
+		vaultID, deviceID, v.Role,
+	).Scan(&v.VaultID, &v.Name, &v.IsDefault, &v.KeyVersion, &v.EntryCount, &v.HasKey, &v.CreatedAt)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// RenameVaultHandler renames a vault (owner only)
+func (h *Handler) RenameVaultHandler(w http.ResponseWriter, r *http.Request) {
+	vaultID := getVaultID(r.Context())
+
+	var req models.UpdateVaultRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	_, err := h.DB.Exec(`UPDATE vaults SET name = $1 WHERE vault_id = $2`, req.Name, vaultID)
+	if err != nil {
+		http.Error(w, "failed to rename vault", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteVaultHandler deletes a vault with its entries, key envelopes and members (owner
+// only). The default vault can't be deleted.
+func (h *Handler) DeleteVaultHandler(w http.ResponseWriter, r *http.Request) {
+	vaultID := getVaultID(r.Context())
+
+	result, err := h.DB.Exec(`DELETE FROM vaults WHERE vault_id = $1 AND NOT is_default`, vaultID)
+	if err != nil {
+		http.Error(w, "failed to delete vault", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "the default vault can't be deleted", http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // CreateVaultEntryHandler creates a new vault entry
 func (h *Handler) CreateVaultEntryHandler(w http.ResponseWriter, r *http.Request) {
 	userID := getUserID(r.Context())
+	vaultID := getVaultID(r.Context())
 
 	var req models.VaultEntryRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -26,65 +210,77 @@ func (h *Handler) CreateVaultEntryHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	var entryID string
-	err = h.DB.QueryRow(`
-		INSERT INTO vault_entries (user_id, title, encrypted_data, entry_type)
-		VALUES ($1, $2, $3, $4)
-		RETURNING entry_id`,
-		userID, req.Title, encryptedData, req.EntryType,
-	).Scan(&entryID)
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	seq, err := nextChangeSeq(tx, vaultID)
+	if err != nil {
+		http.Error(w, "failed to create entry", http.StatusInternalServerError)
+		return
+	}
 
+	entryID, revision, err := insertEntry(tx, vaultID, userID, seq, req, encryptedData)
 	if err != nil {
 		http.Error(w, "failed to create entry", http.StatusInternalServerError)
 		return
 	}
 
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to create entry", http.StatusInternalServerError)
+		return
+	}
+
+	h.publishVaultEvent(getSessionID(r.Context()), events.Event{Type: events.EntryCreated, VaultID: vaultID, EntryID: entryID})
+
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", entryETag(revision))
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"entry_id": entryID})
+	json.NewEncoder(w).Encode(map[string]any{"entry_id": entryID, "revision": revision})
 }
 
-// GetVaultEntriesHandler retrieves all vault entries for a user
-func (h *Handler) GetVaultEntriesHandler(w http.ResponseWriter, r *http.Request) {
-	userID := getUserID(r.Context())
-
-	rows, err := h.DB.Query(`
-		SELECT entry_id, title, encrypted_data, entry_type, created_at, updated_at
+// GetVaultEntryHandler retrieves one entry, with its revision as the ETag
+func (h *Handler) GetVaultEntryHandler(w http.ResponseWriter, r *http.Request) {
+	var entry models.VaultEntryResponse
+	var encryptedData []byte
+	err := h.DB.QueryRow(`
+		SELECT entry_id, vault_id, title, encrypted_data, entry_type, revision, created_at, updated_at
 		FROM vault_entries
-		WHERE user_id = $1
-		ORDER BY created_at DESC`,
-		userID,
-	)
+		WHERE entry_id::text = $1 AND vault_id = $2 AND deleted_at IS NULL`,
+		chi.URLParam(r, "entryID"), getVaultID(r.Context()),
+	).Scan(&entry.EntryID, &entry.VaultID, &entry.Title, &encryptedData,
+		&entry.EntryType, &entry.Revision, &entry.CreatedAt, &entry.UpdatedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "entry not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, "database error", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var entries []models.VaultEntryResponse
-	for rows.Next() {
-		var entry models.VaultEntryResponse
-		var encryptedData []byte
 
-		err := rows.Scan(&entry.EntryID, &entry.Title, &encryptedData,
-			&entry.EntryType, &entry.CreatedAt, &entry.UpdatedAt)
-		if err != nil {
-			continue
-		}
-
-		entry.EncryptedData = base64.StdEncoding.EncodeToString(encryptedData)
-		entries = append(entries, entry)
-	}
+	entry.EncryptedData = base64.StdEncoding.EncodeToString(encryptedData)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(entries)
+	w.Header().Set("ETag", entryETag(entry.Revision))
+	json.NewEncoder(w).Encode(entry)
 }
 
-// UpdateVaultEntryHandler updates an existing vault entry
+// UpdateVaultEntryHandler updates an existing vault entry, keeping the previous version
+// as a revision. If-Match must carry the entry's current revision, so an edit based on a
+// stale copy fails with 412 instead of overwriting someone else's change.
 func (h *Handler) UpdateVaultEntryHandler(w http.ResponseWriter, r *http.Request) {
-	userID := getUserID(r.Context())
+	vaultID := getVaultID(r.Context())
 	entryID := chi.URLParam(r, "entryID")
 
+	expected, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
 	var req models.VaultEntryRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid json", http.StatusBadRequest)
@@ -97,48 +293,197 @@ func (h *Handler) UpdateVaultEntryHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	result, err := h.DB.Exec(`
-		UPDATE vault_entries
-		SET title = $1, encrypted_data = $2, entry_type = $3, updated_at = now()
-		WHERE entry_id = $4 AND user_id = $5`,
-		req.Title, encryptedData, req.EntryType, entryID, userID,
-	)
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
 
+	seq, err := nextChangeSeq(tx, vaultID)
 	if err != nil {
 		http.Error(w, "failed to update entry", http.StatusInternalServerError)
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
+	entry, current, err := h.updateEntry(tx, vaultID, entryID, getDeviceID(r.Context()), seq, expected, req, encryptedData)
+	if err == sql.ErrNoRows {
 		http.Error(w, "entry not found", http.StatusNotFound)
 		return
 	}
+	if err == errStaleRevision {
+		writeRevisionConflict(w, current)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to update entry", http.StatusInternalServerError)
+		return
+	}
 
-	w.WriteHeader(http.StatusOK)
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to update entry", http.StatusInternalServerError)
+		return
+	}
+
+	h.publishVaultEvent(getSessionID(r.Context()), events.Event{Type: events.EntryUpdated, VaultID: vaultID, EntryID: entry.EntryID})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", entryETag(entry.Revision))
+	json.NewEncoder(w).Encode(entry)
 }
 
-// DeleteVaultEntryHandler deletes a vault entry
+// DeleteVaultEntryHandler moves a vault entry to the trash. It stays restorable until
+// the trash purger removes it (see StartTrashPurger). Like updates, it needs If-Match.
 func (h *Handler) DeleteVaultEntryHandler(w http.ResponseWriter, r *http.Request) {
-	userID := getUserID(r.Context())
+	vaultID := getVaultID(r.Context())
 	entryID := chi.URLParam(r, "entryID")
 
-	result, err := h.DB.Exec(`
-		DELETE FROM vault_entries
-		WHERE entry_id = $1 AND user_id = $2`,
-		entryID, userID,
-	)
+	expected, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
 
+	seq, err := nextChangeSeq(tx, vaultID)
 	if err != nil {
 		http.Error(w, "failed to delete entry", http.StatusInternalServerError)
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
+	lockedID, current, err := trashEntry(tx, vaultID, entryID, seq, expected)
+	if err == sql.ErrNoRows {
 		http.Error(w, "entry not found", http.StatusNotFound)
 		return
 	}
+	if err == errStaleRevision {
+		writeRevisionConflict(w, current)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to delete entry", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to delete entry", http.StatusInternalServerError)
+		return
+	}
+
+	h.publishVaultEvent(getSessionID(r.Context()), events.Event{Type: events.EntryDeleted, VaultID: vaultID, EntryID: lockedID})
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// insertEntry adds an entry to a vault as part of tx and returns its ID and revision
+func insertEntry(tx *sql.Tx, vaultID, userID string, seq int64, req models.VaultEntryRequest, encryptedData []byte) (string, int, error) {
+	var entryID string
+	var revision int
+	err := tx.QueryRow(`
+		INSERT INTO vault_entries (vault_id, user_id, title, encrypted_data, entry_type, change_seq)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING entry_id, revision`,
+		vaultID, userID, req.Title, encryptedData, req.EntryType, seq,
+	).Scan(&entryID, &revision)
+	return entryID, revision, err
+}
+
+// updateEntry overwrites an entry as part of tx, keeping the previous version as a
+// revision. It returns sql.ErrNoRows if the entry doesn't exist, and errStaleRevision
+// with the current revision if it isn't at expected (unless that is anyRevision).
+func (h *Handler) updateEntry(tx *sql.Tx, vaultID, entryID, deviceID string, seq int64, expected int, req models.VaultEntryRequest, encryptedData []byte) (models.VaultEntryResponse, int, error) {
+	entry := models.VaultEntryResponse{
+		EntryID:       entryID,
+		VaultID:       vaultID,
+		Title:         req.Title,
+		EncryptedData: req.EncryptedData,
+		EntryType:     req.EntryType,
+	}
+
+	current, err := h.saveEntryRevision(tx, vaultID, entryID, deviceID)
+	if err != nil {
+		return entry, 0, err
+	}
+	if expected != anyRevision && current != expected {
+		return entry, current, errStaleRevision
+	}
+
+	err = tx.QueryRow(`
+		UPDATE vault_entries
+		SET title = $1, encrypted_data = $2, entry_type = $3, revision = revision + 1, change_seq = $4, updated_at = now()
+		WHERE entry_id::text = $5 AND vault_id = $6
+		RETURNING revision, created_at, updated_at`,
+		req.Title, encryptedData, req.EntryType, seq, entryID, vaultID,
+	).Scan(&entry.Revision, &entry.CreatedAt, &entry.UpdatedAt)
+	return entry, entry.Revision, err
+}
+
+// trashEntry moves an entry to the trash as part of tx and returns its canonical ID.
+// Errors are as for updateEntry.
+func trashEntry(tx *sql.Tx, vaultID, entryID string, seq int64, expected int) (string, int, error) {
+	lockedID, current, err := lockVaultEntry(tx, vaultID, entryID)
+	if err != nil {
+		return "", 0, err
+	}
+	if expected != anyRevision && current != expected {
+		return lockedID, current, errStaleRevision
+	}
+
+	_, err = tx.Exec(`
+		UPDATE vault_entries SET deleted_at = now(), revision = revision + 1, change_seq = $1
+		WHERE entry_id = $2`,
+		seq, lockedID,
+	)
+	return lockedID, current + 1, err
+}
+
+// entryETag formats an entry revision as a strong ETag
+func entryETag(revision int) string {
+	return `"` + strconv.Itoa(revision) + `"`
+}
+
+// requireIfMatch reads the entry revision a write is based on from If-Match, or
+// anyRevision for "*" and weakRevision for a W/ tag, which then fails with 412 like a
+// stale one. It writes 428 if the header is missing and 400 if it isn't one entity tag.
+func requireIfMatch(w http.ResponseWriter, r *http.Request) (int, bool) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" {
+		http.Error(w, "If-Match with the entry's revision is required", http.StatusPreconditionRequired)
+		return 0, false
+	}
+	if ifMatch == "*" {
+		return anyRevision, true
+	}
+
+	tag, weak := strings.CutPrefix(ifMatch, "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		http.Error(w, "If-Match must be an entry revision ETag", http.StatusBadRequest)
+		return 0, false
+	}
+	revision, err := strconv.Atoi(tag[1 : len(tag)-1])
+	if err != nil || revision < 1 {
+		http.Error(w, "If-Match must be an entry revision ETag", http.StatusBadRequest)
+		return 0, false
+	}
+	if weak {
+		return weakRevision, true
+	}
+	return revision, true
+}
+
+// writeRevisionConflict answers a stale If-Match with 412 and the server's revision, so
+// the client can fetch the entry, merge and retry
+func writeRevisionConflict(w http.ResponseWriter, current int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", entryETag(current))
+	w.WriteHeader(http.StatusPreconditionFailed)
+	json.NewEncoder(w).Encode(models.RevisionConflictResponse{
+		Error:           errStaleRevision.Error(),
+		CurrentRevision: current,
+	})
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireIfMatch(t *testing.T) {
+	tests := []struct {
+		ifMatch  string
+		revision int
+		status   int // 0 when the header is accepted
+	}{
+		{`"3"`, 3, 0},
+		{` "12" `, 12, 0},
+		{`*`, anyRevision, 0},
+		{`W/"3"`, weakRevision, 0},
+		{``, 0, http.StatusPreconditionRequired},
+		{`3`, 0, http.StatusBadRequest},
+		{`"3`, 0, http.StatusBadRequest},
+		{`"0"`, 0, http.StatusBadRequest},
+		{`"-1"`, 0, http.StatusBadRequest},
+		{`W/3`, 0, http.StatusBadRequest},
+		{`"3", "4"`, 0, http.StatusBadRequest},
+		{`"`, 0, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPut, "/", nil)
+		if tt.ifMatch != "" {
+			r.Header.Set("If-Match", tt.ifMatch)
+		}
+		w := httptest.NewRecorder()
+
+		revision, ok := requireIfMatch(w, r)
+		if ok != (tt.status == 0) {
+			t.Errorf("If-Match %q: ok = %v", tt.ifMatch, ok)
+			continue
+		}
+		if ok && revision != tt.revision {
+			t.Errorf("If-Match %q: got revision %d, want %d", tt.ifMatch, revision, tt.revision)
+		}
+		if !ok && w.Code != tt.status {
+			t.Errorf("If-Match %q: got status %d, want %d", tt.ifMatch, w.Code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package handlers
+
+import (
+	"backend/pswd/internal/events"
+	"backend/pswd/internal/models"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultVaultID returns the user's default vault
+func defaultVaultID(db dbExecutor, userID string) (string, error) {
+	var vaultID string
+	err := db.QueryRow(`SELECT vault_id FROM vaults WHERE user_id = $1 AND is_default`, userID).Scan(&vaultID)
+	return vaultID, err
+}
+
+// requireVaultKey writes 403 and returns false unless the requesting device holds an
+// envelope for the vault in the context, i.e. can read it and seal its key for others
+func (h *Handler) requireVaultKey(w http.ResponseWriter, r *http.Request) bool {
+	var holds bool
+	err := h.DB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM vault_keys WHERE vault_id = $1 AND device_id = $2)`,
+		getVaultID(r.Context()), getDeviceID(r.Context()),
+	).Scan(&holds)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return false
+	}
+	if !holds {
+		http.Error(w, "this device has no key for the vault", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// putVaultKey stores a device's envelope for the vault's current key, replacing any
+// older one. keyVersion 0 means the current version; any other value must match it,
+// otherwise sql.ErrNoRows is returned.
+func putVaultKey(db dbExecutor, vaultID, deviceID, wrappedKey string, keyVersion int) error {
+	var stored int
+	return db.QueryRow(`
+		INSERT INTO vault_keys (vault_id, device_id, wrapped_key, key_version)
+		SELECT vault_id, $2, $3, key_version FROM vaults
+		WHERE vault_id = $1 AND ($4 = 0 OR key_version = $4)
+		ON CONFLICT (vault_id, device_id) DO UPDATE
+		SET wrapped_key = EXCLUDED.wrapped_key, key_version = EXCLUDED.key_version, created_at = now()
+		RETURNING key_version`,
+		vaultID, deviceID, wrappedKey, keyVersion,
+	).Scan(&stored)
+}
+
+// GetVaultKeyHandler returns the requesting device's own envelope for the vault or, for
+// a vault shared with the user, their member envelope or a team envelope
+func (h *Handler) GetVaultKeyHandler(w http.ResponseWriter, r *http.Request) {
+	vaultID := getVaultID(r.Context())
+	deviceID := getDeviceID(r.Context())
+
+	var resp models.VaultKeyResponse
+	var err error
+	if getVaultRole(r.Context()) == vaultOwner {
+		resp.SealedTo = "pk_device"
+		err = h.DB.QueryRow(`
+			SELECT vault_id, device_id, wrapped_key, key_version, created_at
+			FROM vault_keys
+			WHERE vault_id = $1 AND device_id = $2`,
+			vaultID, deviceID,
+		).Scan(&resp.VaultID, &resp.DeviceID, &resp.WrappedKey, &resp.KeyVersion, &resp.CreatedAt)
+	} else {
+		resp.SealedTo = "pk_encrypt"
+		err = h.DB.QueryRow(`
+			SELECT vault_id, wrapped_key, key_version, signature, created_at
+			FROM vault_members
+			WHERE vault_id = $1 AND user_id = $2 AND status = 'accepted'`,
+			vaultID, getUserID(r.Context()),
+		).Scan(&resp.VaultID, &resp.WrappedKey, &resp.KeyVersion, &resp.Signature, &resp.CreatedAt)
+	}
+	if err == sql.ErrNoRows && getVaultRole(r.Context()) != vaultOwner {
+		// No direct membership, so access comes through a team
+		resp.SealedTo = "org_key"
+		err = h.DB.QueryRow(`
+			SELECT tv.vault_id, t.org_id, tv.wrapped_key, tv.key_version, tv.created_at
+			FROM team_vaults tv
+			JOIN teams t ON t.team_id = tv.team_id
+			JOIN team_members tm ON tm.team_id = tv.team_id
+			WHERE tv.vault_id = $1 AND tm.user_id = $2
+			ORDER BY tv.created_at LIMIT 1`,
+			vaultID, getUserID(r.Context()),
+		).Scan(&resp.VaultID, &resp.OrgID, &resp.WrappedKey, &resp.KeyVersion, &resp.CreatedAt)
+	}
+	if err == sql.ErrNoRows {
+		http.Error(w, "no vault key for this device", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ListVaultKeysHandler shows which active devices hold an envelope for the vault, so a
+// device that has the key can seal it for devices that are missing one
+func (h *Handler) ListVaultKeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+
+	resp := models.VaultKeysResponse{Devices: []models.VaultKeyDeviceResponse{}}
+	err := h.DB.QueryRow(`
+		SELECT vault_id, key_version FROM vaults WHERE vault_id = $1`,
+		getVaultID(r.Context()),
+	).Scan(&resp.VaultID, &resp.KeyVersion)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT d.device_id, d.device_name, d.pk_device, d.is_master, vk.key_version
+		FROM devices d
+		LEFT JOIN vault_keys vk ON vk.device_id = d.device_id AND vk.vault_id = $2
+		WHERE d.user_id = $1 AND d.status = 'active'
+		ORDER BY d.is_master DESC, d.created_at`,
+		userID, resp.VaultID,
+	)
+	if err != nil {
+		http.Error(w, "failed to fetch vault keys", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d models.VaultKeyDeviceResponse
+		var keyVersion sql.NullInt64
+		if err := rows.Scan(&d.DeviceID, &d.DeviceName, &d.PkDevice, &d.IsMaster, &keyVersion); err != nil {
+			http.Error(w, "failed to read vault keys", http.StatusInternalServerError)
+			return
+		}
+		d.HasEnvelope = keyVersion.Valid
+		d.KeyVersion = int(keyVersion.Int64)
+		resp.Devices = append(resp.Devices, d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// PutVaultKeyHandler adds or replaces the envelope of one of the user's active devices.
+// Only a device that already holds the vault key can do this.
+func (h *Handler) PutVaultKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.requireVaultKey(w, r) {
+		return
+	}
+	userID := getUserID(r.Context())
+	vaultID := getVaultID(r.Context())
+	deviceID := chi.URLParam(r, "deviceID")
+
+	var req models.PutVaultKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	if req.WrappedKey == "" || req.KeyVersion <= 0 {
+		http.Error(w, "wrapped_key and key_version are required", http.StatusBadRequest)
+		return
+	}
+
+	var exists bool
+	err := h.DB.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM devices WHERE device_id::text = $1 AND user_id = $2 AND status = 'active'
+		)`,
+		deviceID, userID,
+	).Scan(&exists)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+
+	err = putVaultKey(h.DB, vaultID, deviceID, req.WrappedKey, req.KeyVersion)
+	if err == sql.ErrNoRows {
+		http.Error(w, "key_version is not the current vault key version", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to store vault key", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteVaultKeyHandler removes a device's envelope for the vault. A device can drop
+// its own; the master device can drop any. The device may still have the key cached,
+// so removing access for good takes a rotation (see RotateVaultKeyHandler).
+func (h *Handler) DeleteVaultKeyHandler(w http.ResponseWriter, r *http.Request) {
+	vaultID := getVaultID(r.Context())
+	deviceID := chi.URLParam(r, "deviceID")
+
+	if deviceID != getDeviceID(r.Context()) && !h.requireMaster(w, r) {
+		return
+	}
+
+	result, err := h.DB.Exec(`
+		DELETE FROM vault_keys WHERE vault_id = $1 AND device_id::text = $2`,
+		vaultID, deviceID,
+	)
+	if err != nil {
+		http.Error(w, "failed to delete vault key", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "vault key not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RotateVaultKeyHandler re-keys a vault, typically after a device is revoked or a member
+// removed. The client generates a new key, re-encrypts every entry (those in the trash
+// too) and seals the key to each device that keeps access and wraps it for every member
+// and team; all of it is swapped in one transaction and the old envelopes and entry
+// revisions are dropped. Each entry names the revision it was read at, and the rotation
+// fails with 412 if any has changed since. For the default vault, PRF-wrapped keys and
+// the recovery copy go too.
+func (h *Handler) RotateVaultKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.requireVaultKey(w, r) {
+		return
+	}
+	userID := getUserID(r.Context())
+	deviceID := getDeviceID(r.Context())
+
+	var req models.RotateVaultKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	// The rotating device must keep the key, or nobody could seal it for new devices
+	hasOwnEnvelope := false
+	for _, envelope := range req.Envelopes {
+		if envelope.DeviceID == "" || envelope.WrappedKey == "" {
+			http.Error(w, "each envelope needs device_id and wrapped_key", http.StatusBadRequest)
+			return
+		}
+		if envelope.DeviceID == deviceID {
+			hasOwnEnvelope = true
+		}
+	}
+	if !hasOwnEnvelope {
+		http.Error(w, "envelopes must include this device", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	resp := models.RotateVaultKeyResponse{VaultID: getVaultID(r.Context()), KeyVersion: req.KeyVersion}
+	var currentVersion int
+	var isDefault bool
+	err = tx.QueryRow(`
+		SELECT key_version, is_default FROM vaults WHERE vault_id = $1
+		FOR UPDATE`,
+		resp.VaultID,
+	).Scan(&currentVersion, &isDefault)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if req.KeyVersion != currentVersion+1 {
+		http.Error(w, "key_version must be the current version + 1", http.StatusConflict)
+		return
+	}
+
+	seq, err := nextChangeSeq(tx, resp.VaultID)
+	if err != nil {
+		http.Error(w, "failed to rotate vault key", http.StatusInternalServerError)
+		return
+	}
+
+	// Every entry must be re-encrypted, or it would be unreadable with the new key
+	var entryCount int
+	if err := tx.QueryRow(`SELECT count(*) FROM vault_entries WHERE vault_id = $1`, resp.VaultID).Scan(&entryCount); err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if len(req.Entries) != entryCount {
+		http.Error(w, "every vault entry must be re-encrypted", http.StatusConflict)
+		return
+	}
+	// With duplicates the count could match while another entry is left out
+	seen := make(map[string]bool, len(req.Entries))
+	for _, entry := range req.Entries {
+		if seen[entry.EntryID] {
+			http.Error(w, "every vault entry must be re-encrypted", http.StatusConflict)
+			return
+		}
+		seen[entry.EntryID] = true
+
+		encryptedData, err := base64.StdEncoding.DecodeString(entry.EncryptedData)
+		if err != nil || entry.Revision < 1 {
+			http.Error(w, "each entry needs its revision and encrypted data", http.StatusBadRequest)
+			return
+		}
+
+		// An edit committed after the client read the entry would otherwise be lost
+		current, err := reencryptEntry(tx, resp.VaultID, seq, entry, encryptedData)
+		if err == sql.ErrNoRows {
+			http.Error(w, "every vault entry must be re-encrypted", http.StatusConflict)
+			return
+		}
+		if err == errStaleRevision {
+			writeRevisionConflict(w, current)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to rotate vault key", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Old revisions are encrypted with the key being retired; keeping them would leave
+	// data readable by whoever the rotation is meant to lock out
+	_, err = tx.Exec(`DELETE FROM vault_entry_revisions WHERE vault_id = $1`, resp.VaultID)
+	if err != nil {
+		http.Error(w, "failed to rotate vault key", http.StatusInternalServerError)
+		return
+	}
+
+	if err := rotateMemberKeys(tx, userID, resp.VaultID, req.KeyVersion, req.Members); err != nil {
+		if err == errMissingMemberKey {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err == errInvalidShareSignature {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "failed to rotate vault key", http.StatusInternalServerError)
+		return
+	}
+
+	if err := rotateTeamKeys(tx, resp.VaultID, req.KeyVersion, req.Teams); err != nil {
+		if err == errMissingTeamKey {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "failed to rotate vault key", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(`UPDATE vaults SET key_version = $1 WHERE vault_id = $2`, req.KeyVersion, resp.VaultID)
+	if err != nil {
+		http.Error(w, "failed to rotate vault key", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = tx.Exec(`DELETE FROM vault_keys WHERE vault_id = $1`, resp.VaultID)
+	if err != nil {
+		http.Error(w, "failed to rotate vault key", http.StatusInternalServerError)
+		return
+	}
+
+	for _, envelope := range req.Envelopes {
+		result, err := tx.Exec(`
+			INSERT INTO vault_keys (vault_id, device_id, wrapped_key, key_version)
+			SELECT $1, device_id, $2, $3 FROM devices
+			WHERE device_id::text = $4 AND user_id = $5 AND status = 'active'
+			ON CONFLICT (vault_id, device_id) DO NOTHING`,
+			resp.VaultID, envelope.WrappedKey, req.KeyVersion, envelope.DeviceID, userID,
+		)
+		if err != nil {
+			http.Error(w, "failed to rotate vault key", http.StatusInternalServerError)
+			return
+		}
+		rowsAffected, _ := result.RowsAffected()
+		if rowsAffected == 0 {
+			http.Error(w, "envelopes must be for distinct active devices", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Copies of the old default vault key wrapped by other means are useless now
+	if isDefault {
+		_, err = tx.Exec(`
+			UPDATE webauthn_credentials SET wrapped_vault_key = NULL WHERE user_id = $1`,
+			userID,
+		)
+		if err != nil {
+			http.Error(w, "failed to rotate vault key", http.StatusInternalServerError)
+			return
+		}
+
+		_, err = tx.Exec(`
+			UPDATE users SET recovery_wrapped_vault_key = NULLIF($1, '') WHERE user_id = $2`,
+			req.RecoveryWrappedVaultKey, userID,
+		)
+		if err != nil {
+			http.Error(w, "failed to rotate vault key", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := notify(tx, userID, notifyVaultKeyRotated, deviceID, resp.VaultID); err != nil {
+		http.Error(w, "failed to rotate vault key", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to rotate vault key", http.StatusInternalServerError)
+		return
+	}
+
+	h.publishVaultEvent(getSessionID(r.Context()), events.Event{Type: events.VaultRekeyed, VaultID: resp.VaultID})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// reencryptEntry stores an entry re-encrypted under a new key as part of tx, trash
+// included, if it is still at the revision the client re-encrypted. It returns
+// sql.ErrNoRows if the vault has no such entry, and errStaleRevision with the current
+// revision if it changed since. The caller must hold the vault's change_seq lock.
+func reencryptEntry(tx *sql.Tx, vaultID string, seq int64, entry models.RotatedVaultEntry, encryptedData []byte) (int, error) {
+	result, err := tx.Exec(`
+		UPDATE vault_entries SET encrypted_data = $1, revision = revision + 1, change_seq = $2, updated_at = now()
+		WHERE entry_id::text = $3 AND vault_id = $4 AND revision = $5`,
+		encryptedData, seq, entry.EntryID, vaultID, entry.Revision,
+	)
+	if err != nil {
+		return 0, err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 1 {
+		return entry.Revision + 1, nil
+	}
+
+	var current int
+	err = tx.QueryRow(`
+		SELECT revision FROM vault_entries WHERE entry_id::text = $1 AND vault_id = $2`,
+		entry.EntryID, vaultID,
+	).Scan(&current)
+	if err != nil {
+		return 0, err
+	}
+	return current, errStaleRevision
+}
@@ -0,0 +1,658 @@
+package handlers
+
+import (
+	"backend/pswd/internal/auth"
+	"backend/pswd/internal/models"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// WebAuthn ceremony purposes, stored with each challenge so a challenge issued
+// for one flow can't be answered in another
+const (
+	webAuthnRegister = "register"
+	webAuthnLogin    = "login"
+	webAuthnMFA      = "mfa"
+)
+
+// WebAuthnRegisterBeginHandler returns creation options for a new credential on the current device
+func (h *Handler) WebAuthnRegisterBeginHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	deviceID := getDeviceID(r.Context())
+
+	var username string
+	err := h.DB.QueryRow(`SELECT username FROM users WHERE user_id = $1`, userID).Scan(&username)
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	existing, _, err := h.webAuthnAllowList(userID, "")
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	prfSalt, err := auth.GenerateNonce()
+	if err != nil {
+		http.Error(w, "failed to start registration", http.StatusInternalServerError)
+		return
+	}
+
+	challengeID, challenge, err := h.newWebAuthnChallenge(userID, deviceID, webAuthnRegister, prfSalt)
+	if err != nil {
+		http.Error(w, "failed to start registration", http.StatusInternalServerError)
+		return
+	}
+
+	rpID, rpName := auth.WebAuthnRP()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.WebAuthnRegisterBeginResponse{
+		ChallengeID: challengeID,
+		PublicKey: models.PublicKeyCredentialCreationOptions{
+			Challenge: challenge,
+			RP:        models.RelyingParty{ID: rpID, Name: rpName},
+			User: models.WebAuthnUserEntity{
+				ID:          auth.EncodeWebAuthnID([]byte(userID)),
+				Name:        username,
+				DisplayName: username,
+			},
+			PubKeyCredParams: []models.PublicKeyCredentialParameter{
+				{Type: "public-key", Alg: auth.COSEAlgEdDSA},
+				{Type: "public-key", Alg: auth.COSEAlgES256},
+			},
+			Timeout:            auth.WebAuthnChallengeTTL.Milliseconds(),
+			ExcludeCredentials: existing,
+			AuthenticatorSelection: models.AuthenticatorSelection{
+				ResidentKey:      "preferred",
+				UserVerification: "preferred",
+			},
+			Attestation: "none",
+			Extensions: models.WebAuthnExtensions{
+				PRF: &models.PRFExtensionInput{Eval: &models.PRFValues{First: prfSalt}},
+			},
+		},
+	})
+}
+
+// WebAuthnRegisterFinishHandler verifies a new credential and links it to the current device.
+// A passkey signs in on its own, so adding one needs the user to re-authenticate (see
+// verifyReauth); a stolen session alone can't plant one. The first second factor a user
+// enrolls also gets them a set of recovery codes.
+func (h *Handler) WebAuthnRegisterFinishHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	deviceID := getDeviceID(r.Context())
+
+	var req models.WebAuthnRegisterFinishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		req.Name = "Passkey"
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	challenge, err := consumeWebAuthnChallenge(tx, req.ChallengeID, webAuthnRegister)
+	if err != nil || challenge.userID != userID || challenge.deviceID != deviceID {
+		http.Error(w, "invalid or expired challenge", http.StatusBadRequest)
+		return
+	}
+
+	ok, err := h.verifyReauth(tx, userID, req.ReauthRequest)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	clientDataJSON, err1 := auth.DecodeWebAuthnID(req.Credential.Response.ClientDataJSON)
+	attestationObject, err2 := auth.DecodeWebAuthnID(req.Credential.Response.AttestationObject)
+	if err1 != nil || err2 != nil {
+		http.Error(w, "invalid credential encoding", http.StatusBadRequest)
+		return
+	}
+
+	credential, err := auth.VerifyWebAuthnRegistration(challenge.challenge, clientDataJSON, attestationObject, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hadSecondFactor, err := hasSecondFactor(tx, userID)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	prfEnabled := req.Credential.ClientExtensionResults.PRF != nil && req.Credential.ClientExtensionResults.PRF.Enabled
+
+	resp := models.WebAuthnRegisterFinishResponse{
+		Credential: models.WebAuthnCredentialResponse{
+			CredentialID:       auth.EncodeWebAuthnID(credential.ID),
+			DeviceID:           deviceID,
+			Name:               req.Name,
+			PRFSalt:            challenge.prfSalt,
+			PRFEnabled:         prfEnabled,
+			HasWrappedVaultKey: req.WrappedVaultKey != "",
+		},
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO webauthn_credentials
+			(credential_id, user_id, device_id, name, public_key, alg, sign_count, prf_salt, prf_enabled, wrapped_vault_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''))
+		ON CONFLICT (credential_id) DO NOTHING
+		RETURNING created_at`,
+		resp.Credential.CredentialID, userID, deviceID, req.Name, auth.EncodeWebAuthnID(credential.PublicKey),
+		credential.Alg, credential.SignCount, challenge.prfSalt, prfEnabled, req.WrappedVaultKey,
+	).Scan(&resp.Credential.CreatedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "credential already registered", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to store credential", http.StatusInternalServerError)
+		return
+	}
+
+	if !hadSecondFactor {
+		resp.RecoveryCodes, err = replaceRecoveryCodes(tx, userID)
+		if err != nil {
+			http.Error(w, "failed to generate recovery codes", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := notify(tx, userID, notifyPasskeyAdded, deviceID, ""); err != nil {
+		http.Error(w, "failed to store credential", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to store credential", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ListWebAuthnCredentialsHandler returns the current user's credentials
+func (h *Handler) ListWebAuthnCredentialsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+
+	rows, err := h.DB.Query(`
+		SELECT credential_id, device_id, name, prf_salt, prf_enabled,
+			wrapped_vault_key IS NOT NULL, created_at, last_used_at
+		FROM webauthn_credentials
+		WHERE user_id = $1
+		ORDER BY created_at`,
+		userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to fetch credentials", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	credentials := []models.WebAuthnCredentialResponse{}
+	for rows.Next() {
+		var c models.WebAuthnCredentialResponse
+		if err := rows.Scan(&c.CredentialID, &c.DeviceID, &c.Name, &c.PRFSalt, &c.PRFEnabled,
+			&c.HasWrappedVaultKey, &c.CreatedAt, &c.LastUsedAt); err != nil {
+			http.Error(w, "failed to read credentials", http.StatusInternalServerError)
+			return
+		}
+		credentials = append(credentials, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(credentials)
+}
+
+// SetWebAuthnPRFKeyHandler stores the vault key wrapped with a credential's PRF output,
+// for authenticators that only return PRF results during an assertion
+func (h *Handler) SetWebAuthnPRFKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	credentialID := chi.URLParam(r, "credentialID")
+
+	var req models.WebAuthnPRFKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	if req.WrappedVaultKey == "" {
+		http.Error(w, "wrapped_vault_key is required", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.DB.Exec(`
+		UPDATE webauthn_credentials SET wrapped_vault_key = $1, prf_enabled = true
+		WHERE credential_id = $2 AND user_id = $3`,
+		req.WrappedVaultKey, credentialID, userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to store wrapped key", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "credential not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteWebAuthnCredentialHandler removes one of the current user's credentials after
+// the user re-authenticates (see verifyReauth), like DisableTOTPHandler. The last second
+// factor can't be removed while the server requires 2FA.
+func (h *Handler) DeleteWebAuthnCredentialHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r.Context())
+	credentialID := chi.URLParam(r, "credentialID")
+
+	var req models.ReauthRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	ok, err := h.verifyReauth(tx, userID, req)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	result, err := tx.Exec(`
+		DELETE FROM webauthn_credentials WHERE credential_id = $1 AND user_id = $2`,
+		credentialID, userID,
+	)
+	if err != nil {
+		http.Error(w, "failed to delete credential", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		http.Error(w, "credential not found", http.StatusNotFound)
+		return
+	}
+
+	enrolled, err := hasSecondFactor(tx, userID)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if !enrolled {
+		if h.Require2FA {
+			http.Error(w, "two-factor authentication is required on this server", http.StatusForbidden)
+			return
+		}
+		if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+			http.Error(w, "failed to delete credential", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := notify(tx, userID, notifyPasskeyRemoved, getDeviceID(r.Context()), ""); err != nil {
+		http.Error(w, "failed to delete credential", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to delete credential", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WebAuthnLoginBeginHandler starts a passwordless login with a passkey registered on
+// the given device. Unknown users and devices get decoy options, like ChallengeHandler.
+func (h *Handler) WebAuthnLoginBeginHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.WebAuthnLoginBeginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	var userID, deviceID string
+	err := h.DB.QueryRow(`
+		SELECT u.user_id, d.device_id
+		FROM users u
+		JOIN devices d ON d.user_id = u.user_id
+		WHERE u.username = $1 AND d.device_fingerprint = $2 AND d.status = 'active'`,
+		req.Username, req.DeviceFingerprint,
+	).Scan(&userID, &deviceID)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	var allowed []models.CredentialDescriptor
+	var salts map[string]models.PRFValues
+	if err == nil {
+		allowed, salts, err = h.webAuthnAllowList(userID, deviceID)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if len(allowed) == 0 {
+		challenge, err := auth.GenerateNonce()
+		if err != nil {
+			http.Error(w, "failed to start login", http.StatusInternalServerError)
+			return
+		}
+		fakeID, fakeSalt := auth.FakeWebAuthnCredential(req.Username, req.DeviceFingerprint)
+		writeWebAuthnAssertionOptions(w, uuid.NewString(), challenge, "required",
+			[]models.CredentialDescriptor{{Type: "public-key", ID: fakeID}},
+			map[string]models.PRFValues{fakeID: {First: fakeSalt}})
+		return
+	}
+
+	challengeID, challenge, err := h.newWebAuthnChallenge(userID, deviceID, webAuthnLogin, "")
+	if err != nil {
+		http.Error(w, "failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	writeWebAuthnAssertionOptions(w, challengeID, challenge, "required", allowed, salts)
+}
+
+// WebAuthnLoginFinishHandler logs a device in with a user-verified passkey assertion.
+// A passkey that verified the user (PIN or biometric) already combines two factors,
+// so this skips the TOTP step. The response carries the PRF-wrapped vault key, if any.
+func (h *Handler) WebAuthnLoginFinishHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.WebAuthnAssertionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	challenge, err := consumeWebAuthnChallenge(tx, req.ChallengeID, webAuthnLogin)
+	if err != nil {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	ok, wrappedVaultKey, err := verifyWebAuthnAssertion(tx, challenge, req.Credential, true)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	resp := models.LoginResponse{
+		UserID:             challenge.userID,
+		DeviceID:           challenge.deviceID,
+		PRFWrappedVaultKey: wrappedVaultKey,
+	}
+	err = tx.QueryRow(`
+		SELECT u.username, d.is_master
+		FROM users u
+		JOIN devices d ON d.user_id = u.user_id
+		WHERE u.user_id = $1 AND d.device_id = $2 AND d.status = 'active'`,
+		challenge.userID, challenge.deviceID,
+	).Scan(&resp.Username, &resp.IsMaster)
+	if err != nil {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	h.issueLogin(w, r, resp)
+}
+
+// WebAuthnMFABeginHandler returns assertion options for a login parked at the second factor
+func (h *Handler) WebAuthnMFABeginHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.WebAuthnMFABeginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	var userID string
+	err := h.DB.QueryRow(`
+		SELECT user_id FROM mfa_challenges
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()`,
+		auth.HashToken(req.MFAToken),
+	).Scan(&userID)
+	if err != nil {
+		http.Error(w, "invalid or expired mfa token", http.StatusUnauthorized)
+		return
+	}
+
+	h.beginWebAuthnMFA(w, userID)
+}
+
+// WebAuthnAssertBeginHandler returns assertion options for re-authenticating the
+// current user with a passkey, e.g. before regenerating recovery codes
+func (h *Handler) WebAuthnAssertBeginHandler(w http.ResponseWriter, r *http.Request) {
+	h.beginWebAuthnMFA(w, getUserID(r.Context()))
+}
+
+func (h *Handler) beginWebAuthnMFA(w http.ResponseWriter, userID string) {
+	allowed, salts, err := h.webAuthnAllowList(userID, "")
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if len(allowed) == 0 {
+		http.Error(w, "no webauthn credentials registered", http.StatusBadRequest)
+		return
+	}
+
+	challengeID, challenge, err := h.newWebAuthnChallenge(userID, "", webAuthnMFA, "")
+	if err != nil {
+		http.Error(w, "failed to start webauthn", http.StatusInternalServerError)
+		return
+	}
+
+	writeWebAuthnAssertionOptions(w, challengeID, challenge, "preferred", allowed, salts)
+}
+
+func writeWebAuthnAssertionOptions(w http.ResponseWriter, challengeID, challenge, userVerification string,
+	allowed []models.CredentialDescriptor, salts map[string]models.PRFValues) {
+	rpID, _ := auth.WebAuthnRP()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.WebAuthnAssertionBeginResponse{
+		ChallengeID: challengeID,
+		PublicKey: models.PublicKeyCredentialRequestOptions{
+			Challenge:        challenge,
+			RPID:             rpID,
+			Timeout:          auth.WebAuthnChallengeTTL.Milliseconds(),
+			AllowCredentials: allowed,
+			UserVerification: userVerification,
+			Extensions: models.WebAuthnExtensions{
+				PRF: &models.PRFExtensionInput{EvalByCredential: salts},
+			},
+		},
+	})
+}
+
+// newWebAuthnChallenge stores a fresh challenge for a ceremony. deviceID is empty
+// for ceremonies that may use a credential from any of the user's devices.
+func (h *Handler) newWebAuthnChallenge(userID, deviceID, purpose, prfSalt string) (challengeID, challenge string, err error) {
+	challenge, err = auth.GenerateNonce()
+	if err != nil {
+		return "", "", err
+	}
+
+	err = h.DB.QueryRow(`
+		INSERT INTO webauthn_challenges (user_id, device_id, purpose, challenge, prf_salt, expires_at)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, $4, NULLIF($5, ''), now() + ($6 * interval '1 second'))
+		RETURNING challenge_id`,
+		userID, deviceID, purpose, challenge, prfSalt, int64(auth.WebAuthnChallengeTTL.Seconds()),
+	).Scan(&challengeID)
+	return challengeID, challenge, err
+}
+
+type webAuthnChallenge struct {
+	userID    string
+	deviceID  string // empty unless the ceremony is bound to a device
+	challenge string
+	prfSalt   string
+}
+
+// consumeWebAuthnChallenge marks a pending challenge used and returns it, so every
+// challenge gets exactly one answer
+func consumeWebAuthnChallenge(db dbExecutor, challengeID, purpose string) (webAuthnChallenge, error) {
+	var c webAuthnChallenge
+	var deviceID, prfSalt sql.NullString
+	err := db.QueryRow(`
+		UPDATE webauthn_challenges SET used_at = now()
+		WHERE challenge_id::text = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id, device_id, challenge, prf_salt`,
+		challengeID, purpose,
+	).Scan(&c.userID, &deviceID, &c.challenge, &prfSalt)
+	c.deviceID = deviceID.String
+	c.prfSalt = prfSalt.String
+	return c, err
+}
+
+// verifyWebAuthnAssertion checks an assertion against the challenge's user (and device,
+// if bound) and advances the credential's signature counter. Returns false for any
+// verification failure; err is only set for database errors.
+func verifyWebAuthnAssertion(db dbExecutor, challenge webAuthnChallenge, credential models.PublicKeyCredentialJSON, requireUV bool) (bool, string, error) {
+	rawID, err := auth.DecodeWebAuthnID(credential.RawID)
+	if err != nil {
+		return false, "", nil
+	}
+	clientDataJSON, err1 := auth.DecodeWebAuthnID(credential.Response.ClientDataJSON)
+	authenticatorData, err2 := auth.DecodeWebAuthnID(credential.Response.AuthenticatorData)
+	signature, err3 := auth.DecodeWebAuthnID(credential.Response.Signature)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return false, "", nil
+	}
+
+	credentialID := auth.EncodeWebAuthnID(rawID)
+	var publicKey string
+	var signCount int64
+	var wrappedVaultKey sql.NullString
+	err = db.QueryRow(`
+		SELECT public_key, sign_count, wrapped_vault_key
+		FROM webauthn_credentials
+		WHERE credential_id = $1 AND user_id = $2
+		AND ($3 = '' OR device_id::text = $3)
+		FOR UPDATE`,
+		credentialID, challenge.userID, challenge.deviceID,
+	).Scan(&publicKey, &signCount, &wrappedVaultKey)
+	if err == sql.ErrNoRows {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+
+	coseKey, err := auth.DecodeWebAuthnID(publicKey)
+	if err != nil {
+		return false, "", nil
+	}
+
+	newCount, err := auth.VerifyWebAuthnAssertion(challenge.challenge, coseKey, uint32(signCount),
+		clientDataJSON, authenticatorData, signature, requireUV)
+	if err != nil {
+		return false, "", nil
+	}
+
+	_, err = db.Exec(`
+		UPDATE webauthn_credentials SET sign_count = $1, last_used_at = now()
+		WHERE credential_id = $2`,
+		int64(newCount), credentialID,
+	)
+	if err != nil {
+		return false, "", err
+	}
+
+	return true, wrappedVaultKey.String, nil
+}
+
+// webAuthnAllowList returns a user's credentials (on one device, if deviceID is set)
+// as descriptors, along with their PRF salts for assertion options
+func (h *Handler) webAuthnAllowList(userID, deviceID string) ([]models.CredentialDescriptor, map[string]models.PRFValues, error) {
+	rows, err := h.DB.Query(`
+		SELECT credential_id, prf_salt FROM webauthn_credentials
+		WHERE user_id = $1 AND ($2 = '' OR device_id::text = $2)
+		ORDER BY created_at`,
+		userID, deviceID,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	allowed := []models.CredentialDescriptor{}
+	salts := map[string]models.PRFValues{}
+	for rows.Next() {
+		var id, salt string
+		if err := rows.Scan(&id, &salt); err != nil {
+			return nil, nil, err
+		}
+		allowed = append(allowed, models.CredentialDescriptor{Type: "public-key", ID: id})
+		salts[id] = models.PRFValues{First: salt}
+	}
+	return allowed, salts, rows.Err()
+}
+
+// hasSecondFactor reports whether a user has TOTP enabled or a WebAuthn credential registered
+func hasSecondFactor(db dbExecutor, userID string) (bool, error) {
+	var enrolled bool
+	err := db.QueryRow(`
+		SELECT u.totp_enabled OR EXISTS (
+			SELECT 1 FROM webauthn_credentials wc WHERE wc.user_id = u.user_id
+		)
+		FROM users u
+		WHERE u.user_id = $1`,
+		userID,
+	).Scan(&enrolled)
+	return enrolled, err
+}
@@ -0,0 +1,189 @@
+package keystore
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A key directory holds one "<kid>.key" file per signing key and an "active" file
+// naming the key used to sign new tokens. Keys that are not active are kept only
+// to verify tokens signed before a rotation. A "decoy.secret" file, created on first
+// use and never rotated, keys the fake values handed out for unknown accounts.
+
+const (
+	keySuffix  = ".key"
+	activeFile = "active"
+	decoyFile  = "decoy.secret"
+)
+
+var kidPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidateKID checks that a key ID is safe to use as a file name and JWT header value
+func ValidateKID(kid string) error {
+	if !kidPattern.MatchString(kid) {
+		return fmt.Errorf("invalid key id %q: use 1-64 letters, digits, '-' or '_'", kid)
+	}
+	return nil
+}
+
+// Load reads every key in dir and returns them by key ID along with the active key ID
+func Load(dir string) (map[string][]byte, string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read key directory: %w", err)
+	}
+
+	keys := make(map[string][]byte)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, keySuffix) {
+			continue
+		}
+
+		kid := strings.TrimSuffix(name, keySuffix)
+		if err := ValidateKID(kid); err != nil {
+			return nil, "", err
+		}
+
+		material, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read key %s: %w", kid, err)
+		}
+		keys[kid] = []byte(strings.TrimSpace(string(material)))
+	}
+
+	active, err := os.ReadFile(filepath.Join(dir, activeFile))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read active key id: %w", err)
+	}
+
+	activeKID := strings.TrimSpace(string(active))
+	if _, ok := keys[activeKID]; !ok {
+		return nil, "", fmt.Errorf("active key %q not found in %s", activeKID, dir)
+	}
+
+	return keys, activeKID, nil
+}
+
+// List returns the key IDs in dir in lexical order, plus the active key ID (empty if unset)
+func List(dir string) ([]string, string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read key directory: %w", err)
+	}
+
+	var kids []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), keySuffix) {
+			kids = append(kids, strings.TrimSuffix(entry.Name(), keySuffix))
+		}
+	}
+	sort.Strings(kids)
+
+	active, err := os.ReadFile(filepath.Join(dir, activeFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, "", fmt.Errorf("failed to read active key id: %w", err)
+	}
+
+	return kids, strings.TrimSpace(string(active)), nil
+}
+
+// Add writes a new key to dir without activating it
+func Add(dir, kid string, material []byte) error {
+	if err := ValidateKID(kid); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+
+	path := filepath.Join(dir, kid+keySuffix)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create key %s: %w", kid, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(material); err != nil {
+		return fmt.Errorf("failed to write key %s: %w", kid, err)
+	}
+	return f.Close()
+}
+
+// Promote makes kid the key used to sign new tokens. The previously active key
+// stays in the directory so tokens it signed keep verifying until they expire.
+func Promote(dir, kid string) error {
+	if err := ValidateKID(kid); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(dir, kid+keySuffix)); err != nil {
+		return fmt.Errorf("key %s not found: %w", kid, err)
+	}
+
+	// Write then rename so a running server never reads a half-written file
+	tmp := filepath.Join(dir, activeFile+".tmp")
+	if err := os.WriteFile(tmp, []byte(kid+"\n"), 0o600); err != nil {
+		return fmt.Errorf("failed to write active key id: %w", err)
+	}
+	return os.Rename(tmp, filepath.Join(dir, activeFile))
+}
+
+// Remove deletes a retired key. The active key cannot be removed.
+func Remove(dir, kid string) error {
+	if err := ValidateKID(kid); err != nil {
+		return err
+	}
+
+	_, active, err := List(dir)
+	if err != nil {
+		return err
+	}
+	if kid == active {
+		return errors.New("cannot remove the active key; promote another key first")
+	}
+
+	return os.Remove(filepath.Join(dir, kid+keySuffix))
+}
+
+// DecoySecret returns the directory's decoy secret, creating it if it doesn't exist yet.
+// Unlike signing keys it must stay the same for the life of the deployment: fake salts
+// derived from it would otherwise change for unknown usernames only.
+func DecoySecret(dir string) ([]byte, error) {
+	path := filepath.Join(dir, decoyFile)
+
+	secret, err := os.ReadFile(path)
+	if err == nil {
+		return []byte(strings.TrimSpace(string(secret))), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read decoy secret: %w", err)
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("failed to generate decoy secret: %w", err)
+	}
+	secret = []byte(hex.EncodeToString(raw))
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		// Another process created it first
+		return DecoySecret(dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create decoy secret: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(secret); err != nil {
+		return nil, fmt.Errorf("failed to write decoy secret: %w", err)
+	}
+	return secret, f.Close()
+}
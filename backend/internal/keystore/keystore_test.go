@@ -0,0 +1,155 @@
+package keystore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeDir creates a key directory holding files (name -> content)
+func writeDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	dir := writeDir(t, map[string]string{
+		"2025-01.key":  "old secret\n",
+		"2025-06.key":  "  new secret  ",
+		"active":       "2025-06\n",
+		"decoy.secret": "not a signing key",
+		"README":       "ignored",
+	})
+	if err := os.Mkdir(filepath.Join(dir, "backup.key"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, active, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active != "2025-06" {
+		t.Errorf("got active key %q, want 2025-06", active)
+	}
+	if len(keys) != 2 || string(keys["2025-01"]) != "old secret" || string(keys["2025-06"]) != "new secret" {
+		t.Errorf("got keys %q", keys)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{"no active marker", map[string]string{"a.key": "secret"}, "failed to read active key id"},
+		{"active key missing", map[string]string{"a.key": "secret", "active": "b"}, `active key "b" not found`},
+		{"empty active marker", map[string]string{"a.key": "secret", "active": "\n"}, `active key "" not found`},
+		{"invalid key ID", map[string]string{"a.key": "secret", "bad kid.key": "x", "active": "a"}, "invalid key id"},
+	}
+	for _, tt := range tests {
+		if _, _, err := Load(writeDir(t, tt.files)); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+		}
+	}
+
+	if _, _, err := Load(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing directory was loaded")
+	}
+}
+
+func TestAddPromoteRemove(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+
+	if err := Add(dir, "k1", []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Add(dir, "k1", []byte("again")); err == nil {
+		t.Error("existing key was overwritten")
+	}
+	if err := Add(dir, "../k2", []byte("two")); err == nil {
+		t.Error("key ID with a path was accepted")
+	}
+	if info, err := os.Stat(filepath.Join(dir, "k1.key")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("key file: %v, %v", info, err)
+	}
+
+	// No key is active until one is promoted
+	if kids, active, err := List(dir); err != nil || len(kids) != 1 || active != "" {
+		t.Errorf("got %v, %q, %v", kids, active, err)
+	}
+	if err := Promote(dir, "k2"); err == nil {
+		t.Error("unknown key was promoted")
+	}
+	if err := Promote(dir, "k1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Add(dir, "k2", []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Promote(dir, "k2"); err != nil {
+		t.Fatal(err)
+	}
+	keys, active, err := Load(dir)
+	if err != nil || active != "k2" || len(keys) != 2 {
+		t.Fatalf("after rotation: %v, %q, %v", keys, active, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "active.tmp")); !os.IsNotExist(err) {
+		t.Error("temporary active file was left behind")
+	}
+
+	// The active key stays; the retired one can go
+	if err := Remove(dir, "k2"); err == nil || !strings.Contains(err.Error(), "active key") {
+		t.Errorf("removing the active key: got %v", err)
+	}
+	if err := Remove(dir, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if kids, active, err := List(dir); err != nil || strings.Join(kids, ",") != "k2" || active != "k2" {
+		t.Errorf("after removal: %v, %q, %v", kids, active, err)
+	}
+	if err := Remove(dir, "k1"); err == nil {
+		t.Error("removing a missing key succeeded")
+	}
+}
+
+func TestList(t *testing.T) {
+	dir := writeDir(t, map[string]string{"b.key": "", "a.key": "", "c.key": "", "active": " c \n"})
+
+	kids, active, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(kids, ",") != "a,b,c" || active != "c" {
+		t.Errorf("got %v, %q", kids, active)
+	}
+}
+
+func TestDecoySecret(t *testing.T) {
+	dir := t.TempDir()
+
+	secret, err := DecoySecret(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 64 {
+		t.Errorf("got a %d-character secret, want 64 hex characters", len(secret))
+	}
+	again, err := DecoySecret(dir)
+	if err != nil || string(again) != string(secret) {
+		t.Errorf("secret changed: %q, %v", again, err)
+	}
+
+	// It is not a signing key
+	if kids, _, err := List(dir); err != nil || len(kids) != 0 {
+		t.Errorf("decoy secret listed as a key: %v, %v", kids, err)
+	}
+}
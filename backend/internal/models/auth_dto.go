@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // RegisterRequest contains the data needed for user registration
 type RegisterRequest struct {
 	Username          string `json:"username"`
@@ -10,15 +12,25 @@ type RegisterRequest struct {
 	DeviceName        string `json:"device_name"`
 	DeviceFingerprint string `json:"device_fingerprint"`
 	PkDevice          string `json:"pk_device"`
+	PkDeviceSign      string `json:"pk_device_sign,omitempty"` // Optional Ed25519 key for signature login
+	SRPSalt           string `json:"srp_salt,omitempty"`       // Hex; replaces password for SRP registrations
+	SRPVerifier       string `json:"srp_verifier,omitempty"`   // Hex
+	// Optional Ed25519 recovery key, for promoting a new master device if the master is lost
+	PkRecovery              string `json:"pk_recovery,omitempty"`
+	RecoveryWrappedVaultKey string `json:"recovery_wrapped_vault_key,omitempty"` // Vault key encrypted with the recovery secret
+	// Optional vault key sealed to pk_device, stored as the master device's envelope
+	WrappedVaultKey string `json:"wrapped_vault_key,omitempty"`
 }
 
 // RegisterResponse contains the response data after successful registration
 type RegisterResponse struct {
-	UserID   string `json:"user_id"`
-	Username string `json:"username"`
-	Token    string `json:"token"`
-	DeviceID string `json:"device_id"`
-	IsMaster bool   `json:"is_master"`
+	UserID       string `json:"user_id"`
+	Username     string `json:"username"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // Access token lifetime in seconds
+	DeviceID     string `json:"device_id"`
+	IsMaster     bool   `json:"is_master"`
 }
 
 // LoginRequest contains the data needed for user login
@@ -30,9 +42,162 @@ type LoginRequest struct {
 
 // LoginResponse contains the response data after successful login
 type LoginResponse struct {
-	UserID   string `json:"user_id"`
-	Username string `json:"username"`
-	Token    string `json:"token"`
-	DeviceID string `json:"device_id"`
-	IsMaster bool   `json:"is_master"`
+	UserID       string `json:"user_id"`
+	Username     string `json:"username"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // Access token lifetime in seconds
+	DeviceID     string `json:"device_id"`
+	IsMaster     bool   `json:"is_master"`
+	ServerProof  string `json:"server_proof,omitempty"` // SRP M2, for the client to authenticate the server
+	// The device's envelope for the default vault (vault key sealed to pk_device), if it has one
+	WrappedVaultKey string `json:"wrapped_vault_key,omitempty"`
+	// Vault key wrapped with the passkey's PRF output, after a passwordless WebAuthn login
+	PRFWrappedVaultKey string `json:"prf_wrapped_vault_key,omitempty"`
+}
+
+// ChallengeRequest asks for a nonce to sign for a signature-based login
+type ChallengeRequest struct {
+	Username          string `json:"username"`
+	DeviceFingerprint string `json:"device_fingerprint"`
+}
+
+// ChallengeResponse contains the nonce to sign; see auth.LoginChallengeMessage for the signed bytes
+type ChallengeResponse struct {
+	ChallengeID string    `json:"challenge_id"`
+	Nonce       string    `json:"nonce"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// SignatureLoginRequest answers a login challenge with a signature
+type SignatureLoginRequest struct {
+	ChallengeID string `json:"challenge_id"`
+	Key         string `json:"key"` // "device" (pk_device_sign) or "sign" (account pk_sign)
+	Signature   string `json:"signature"`
+}
+
+// SRPInitRequest starts an SRP login with the client's public ephemeral A
+type SRPInitRequest struct {
+	Username          string `json:"username"`
+	DeviceFingerprint string `json:"device_fingerprint"`
+	A                 string `json:"a"` // Hex
+}
+
+// SRPInitResponse returns the salt and the server's public ephemeral B
+type SRPInitResponse struct {
+	HandshakeID string `json:"handshake_id"`
+	Salt        string `json:"salt"` // Hex
+	B           string `json:"b"`    // Hex
+}
+
+// SRPVerifyRequest completes an SRP login with the client proof M1
+type SRPVerifyRequest struct {
+	HandshakeID string `json:"handshake_id"`
+	M1          string `json:"m1"` // Hex
+}
+
+// SRPEnrollRequest moves a password account to SRP. The password is checked one
+// last time and its hash is then deleted.
+type SRPEnrollRequest struct {
+	Password    string `json:"password"`
+	SRPSalt     string `json:"srp_salt"`
+	SRPVerifier string `json:"srp_verifier"`
+}
+
+// MFARequiredResponse is returned instead of tokens when the first factor succeeded
+// but the user has two-factor authentication enabled
+type MFARequiredResponse struct {
+	MFARequired bool      `json:"mfa_required"`
+	MFAToken    string    `json:"mfa_token"`
+	Methods     []string  `json:"methods"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	ServerProof string    `json:"server_proof,omitempty"` // SRP M2, when the first factor was SRP
+}
+
+// TwoFactorLoginRequest completes a login with a TOTP code, a WebAuthn assertion or a recovery code
+type TwoFactorLoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+	TwoFactorCodeRequest
+}
+
+// TwoFactorCodeRequest proves possession of the second factor for account changes.
+// Exactly one of the fields is expected.
+type TwoFactorCodeRequest struct {
+	Code         string                    `json:"code,omitempty"`
+	RecoveryCode string                    `json:"recovery_code,omitempty"`
+	WebAuthn     *WebAuthnAssertionRequest `json:"webauthn,omitempty"`
+}
+
+// ReauthRequest proves the user is present before a change that could keep an attacker
+// in the account, such as adding a passkey: a second factor if one is enrolled,
+// otherwise the password, or a fresh SRP proof on SRP accounts
+type ReauthRequest struct {
+	Password       string `json:"password,omitempty"`
+	SRPHandshakeID string `json:"srp_handshake_id,omitempty"`
+	SRPM1          string `json:"srp_m1,omitempty"` // Hex
+	TwoFactorCodeRequest
+}
+
+// TOTPSetupResponse contains a new, not yet confirmed TOTP secret
+type TOTPSetupResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// RecoveryCodesResponse contains freshly generated one-time recovery codes.
+// They are shown once; the server only keeps their hashes.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// TwoFactorStatusResponse describes the current user's second-factor setup
+type TwoFactorStatusResponse struct {
+	TOTPEnabled            bool `json:"totp_enabled"`
+	WebAuthnCredentials    int  `json:"webauthn_credentials"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+	Required               bool `json:"required"`
+}
+
+// RefreshRequest carries a refresh token for clients that don't use cookies
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshResponse contains a new access token and the rotated refresh token
+type RefreshResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // Access token lifetime in seconds
+}
+
+// SessionResponse describes an active session of the current user
+type SessionResponse struct {
+	SessionID  string    `json:"session_id"`
+	DeviceID   string    `json:"device_id"`
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// ChangePasswordRequest replaces the account's password. Password accounts prove the
+// old one with old_password and send new_password; SRP accounts prove it with a fresh
+// handshake (POST /api/auth/srp/init) and send a new salt and verifier.
+type ChangePasswordRequest struct {
+	OldPassword    string `json:"old_password,omitempty"`
+	SRPHandshakeID string `json:"srp_handshake_id,omitempty"`
+	SRPM1          string `json:"srp_m1,omitempty"` // Hex
+	NewPassword    string `json:"new_password,omitempty"`
+	SRPSalt        string `json:"srp_salt,omitempty"`
+	SRPVerifier    string `json:"srp_verifier,omitempty"`
+	// The private keys encrypted with a key derived from the new password
+	EncryptedPrivateKeys string `json:"encrypted_private_keys"`
+	// The user's own vaults that have no device envelope and so are keyed by the password,
+	// and every entry (trash included) and stored revision of them, re-encrypted
+	Vaults    []RekeyedVault         `json:"vaults,omitempty"`
+	Entries   []RotatedVaultEntry    `json:"entries,omitempty"`
+	Revisions []RotatedEntryRevision `json:"revisions,omitempty"`
+	TwoFactorCodeRequest
 }
@@ -9,7 +9,9 @@ type Device struct {
 	DeviceName        string    `json:"device_name" db:"device_name"`
 	DeviceFingerprint string    `json:"device_fingerprint" db:"device_fingerprint"`
 	PkDevice          string    `json:"pk_device" db:"pk_device"`
+	PkDeviceSign      string    `json:"pk_device_sign,omitempty" db:"pk_device_sign"`
 	IsMaster          bool      `json:"is_master" db:"is_master"`
+	Status            string    `json:"status" db:"status"` // active, pending, rejected or revoked
 	LastSeen          time.Time `json:"last_seen" db:"last_seen"`
 	CreatedAt         time.Time `json:"created_at" db:"created_at"`
 }
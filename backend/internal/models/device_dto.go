@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// DeviceEnrollRequest asks for a new device to be added to an account
+type DeviceEnrollRequest struct {
+	Username          string `json:"username"`
+	DeviceName        string `json:"device_name"`
+	DeviceFingerprint string `json:"device_fingerprint"`
+	PkDevice          string `json:"pk_device"`                // X25519, receives the wrapped vault key
+	PkDeviceSign      string `json:"pk_device_sign,omitempty"` // Ed25519, for signature login
+}
+
+// DeviceEnrollResponse acknowledges an enrollment request
+type DeviceEnrollResponse struct {
+	DeviceID string `json:"device_id"`
+	Status   string `json:"status"`
+}
+
+// PendingDeviceResponse is an enrollment request shown to the master device.
+// The master should compare the key fingerprint with the one shown on the new device.
+type PendingDeviceResponse struct {
+	DeviceID          string    `json:"device_id"`
+	DeviceName        string    `json:"device_name"`
+	DeviceFingerprint string    `json:"device_fingerprint"`
+	PkDevice          string    `json:"pk_device"`
+	PkDeviceSign      string    `json:"pk_device_sign,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// ApproveDeviceRequest carries the vault key sealed to the new device's pk_device,
+// stored as its envelope
+type ApproveDeviceRequest struct {
+	WrappedVaultKey string `json:"wrapped_vault_key"`
+}
+
+// DeviceResponse describes one of the user's devices
+type DeviceResponse struct {
+	DeviceID          string    `json:"device_id"`
+	DeviceName        string    `json:"device_name"`
+	DeviceFingerprint string    `json:"device_fingerprint"`
+	IsMaster          bool      `json:"is_master"`
+	Status            string    `json:"status"`
+	Current           bool      `json:"current"` // The device making the request
+	LastSeen          time.Time `json:"last_seen"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// UpdateDeviceRequest renames a device
+type UpdateDeviceRequest struct {
+	DeviceName string `json:"device_name"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// MasterTransferRequest is signed by the current master device to hand over the master role
+type MasterTransferRequest struct {
+	TargetDeviceID string `json:"target_device_id"`
+	Nonce          string `json:"nonce"`     // Random, chosen by the master device
+	Signature      string `json:"signature"` // Over auth.MasterTransferMessage, with pk_device_sign
+}
+
+// MasterTransferAcceptRequest is signed by the target device to accept the master role
+type MasterTransferAcceptRequest struct {
+	Signature string `json:"signature"` // Over auth.MasterTransferAcceptMessage, with pk_device_sign
+}
+
+// MasterTransferResponse describes a pending or finished master transfer
+type MasterTransferResponse struct {
+	TransferID   string     `json:"transfer_id"`
+	FromDeviceID string     `json:"from_device_id"`
+	ToDeviceID   string     `json:"to_device_id"`
+	Nonce        string     `json:"nonce"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+}
+
+// RecoveryKeyRequest sets or replaces the account recovery key
+type RecoveryKeyRequest struct {
+	PkRecovery              string `json:"pk_recovery"`
+	RecoveryWrappedVaultKey string `json:"recovery_wrapped_vault_key,omitempty"`
+}
+
+// MasterRecoveryRequest starts promoting a device to master with the recovery key
+type MasterRecoveryRequest struct {
+	Username          string `json:"username"`
+	DeviceFingerprint string `json:"device_fingerprint"`
+	Nonce             string `json:"nonce"`
+	Signature         string `json:"signature"` // Over auth.MasterRecoveryMessage, with the recovery key
+}
+
+// MasterRecoveryCompleteRequest finishes a recovery after its waiting period
+type MasterRecoveryCompleteRequest struct {
+	Signature string `json:"signature"` // Over auth.MasterRecoveryCompleteMessage, with the recovery key
+}
+
+// MasterRecoveryResponse describes a master recovery
+type MasterRecoveryResponse struct {
+	RecoveryID  string     `json:"recovery_id"`
+	DeviceID    string     `json:"device_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	EffectiveAt time.Time  `json:"effective_at"` // Earliest time the recovery can be completed
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	CancelledAt *time.Time `json:"cancelled_at,omitempty"`
+	// Returned once the recovery completes, for the new master to decrypt with the recovery secret
+	RecoveryWrappedVaultKey string `json:"recovery_wrapped_vault_key,omitempty"`
+}
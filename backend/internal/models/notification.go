@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// AccountNotification records a security-relevant account event (master transfer,
+// master recovery) for the user's devices to display
+type AccountNotification struct {
+	NotificationID string    `json:"notification_id" db:"notification_id"`
+	UserID         string    `json:"user_id" db:"user_id"`
+	Kind           string    `json:"kind" db:"kind"`
+	DeviceID       *string   `json:"device_id,omitempty" db:"device_id"`   // Device the event is about
+	RelatedID      *string   `json:"related_id,omitempty" db:"related_id"` // Transfer or recovery ID
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
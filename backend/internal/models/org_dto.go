@@ -0,0 +1,91 @@
+package models
+
+import "time"
+
+// CreateOrgRequest creates an organization. The client generates the org key and seals
+// it to the creator's pk_encrypt.
+type CreateOrgRequest struct {
+	Name          string `json:"name"`
+	WrappedOrgKey string `json:"wrapped_org_key"`
+}
+
+// UpdateOrgRequest renames an organization
+type UpdateOrgRequest struct {
+	Name string `json:"name"`
+}
+
+// OrgResponse describes an organization from the current user's point of view
+type OrgResponse struct {
+	OrgID         string    `json:"org_id"`
+	Name          string    `json:"name"`
+	Role          string    `json:"role"`
+	WrappedOrgKey string    `json:"wrapped_org_key"` // The org key sealed to the user's pk_encrypt
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// AddOrgMemberRequest adds a user to an organization with the org key sealed to their pk_encrypt
+type AddOrgMemberRequest struct {
+	Username      string `json:"username"`
+	Role          string `json:"role"` // owner, admin or member
+	WrappedOrgKey string `json:"wrapped_org_key"`
+}
+
+// UpdateOrgMemberRequest changes a member's organization role
+type UpdateOrgMemberRequest struct {
+	Role string `json:"role"`
+}
+
+// OrgMemberResponse describes a member of an organization
+type OrgMemberResponse struct {
+	UserID    string    `json:"user_id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TeamRequest creates a team
+type TeamRequest struct {
+	Name string `json:"name"`
+}
+
+// TeamResponse describes a team
+type TeamResponse struct {
+	TeamID      string    `json:"team_id"`
+	Name        string    `json:"name"`
+	MemberCount int       `json:"member_count"`
+	VaultCount  int       `json:"vault_count"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// TeamMemberResponse describes a member of a team
+type TeamMemberResponse struct {
+	UserID    string    `json:"user_id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AssignTeamVaultRequest gives a team access to a vault. WrappedKey is the vault key
+// encrypted with the team's org key.
+type AssignTeamVaultRequest struct {
+	TeamID     string `json:"team_id"`
+	Role       string `json:"role"` // read or write
+	WrappedKey string `json:"wrapped_key"`
+	KeyVersion int    `json:"key_version"`
+}
+
+// TeamVaultResponse describes a team's access to a vault
+type TeamVaultResponse struct {
+	TeamID    string    `json:"team_id"`
+	TeamName  string    `json:"team_name"`
+	OrgID     string    `json:"org_id"`
+	VaultID   string    `json:"vault_id"`
+	VaultName string    `json:"vault_name"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TeamKeyEnvelope is a vault key encrypted with a team's org key during a rotation
+type TeamKeyEnvelope struct {
+	TeamID     string `json:"team_id"`
+	WrappedKey string `json:"wrapped_key"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Organization groups users into teams that vaults can be assigned to. Each organization
+// has a symmetric org key that only its members can unwrap.
+type Organization struct {
+	OrgID     string    `json:"org_id" db:"org_id"`
+	Name      string    `json:"name" db:"name"`
+	CreatedBy string    `json:"created_by" db:"created_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// OrgMember is a user's membership in an organization
+type OrgMember struct {
+	OrgID         string    `json:"org_id" db:"org_id"`
+	UserID        string    `json:"user_id" db:"user_id"`
+	Role          string    `json:"role" db:"role"`         // owner, admin or member
+	WrappedOrgKey string    `json:"-" db:"wrapped_org_key"` // Org key sealed to the member's pk_encrypt
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+// Team is a group of organization members
+type Team struct {
+	TeamID    string    `json:"team_id" db:"team_id"`
+	OrgID     string    `json:"org_id" db:"org_id"`
+	Name      string    `json:"name" db:"name"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// TeamMember puts an organization member in a team
+type TeamMember struct {
+	TeamID    string    `json:"team_id" db:"team_id"`
+	UserID    string    `json:"user_id" db:"user_id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// TeamVault gives a team's members access to a vault. The vault key is encrypted
+// with the org key, so any member of the team can unwrap it.
+type TeamVault struct {
+	TeamID     string    `json:"team_id" db:"team_id"`
+	VaultID    string    `json:"vault_id" db:"vault_id"`
+	Role       string    `json:"role" db:"role"` // read or write
+	WrappedKey string    `json:"-" db:"wrapped_key"`
+	KeyVersion int       `json:"key_version" db:"key_version"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// PairingSessionResponse is returned to the device that opens a pairing session
+type PairingSessionResponse struct {
+	PairingID    string    `json:"pairing_id"`
+	Code         string    `json:"code"`          // Short code to type or scan on the new device
+	PairingToken string    `json:"pairing_token"` // Authenticates this side of the relay (X-Pairing-Token)
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// PairingJoinRequest is sent by the new device with the short code
+type PairingJoinRequest struct {
+	Code              string `json:"code"`
+	DeviceName        string `json:"device_name"`
+	DeviceFingerprint string `json:"device_fingerprint"`
+	PkDevice          string `json:"pk_device"`
+	PkDeviceSign      string `json:"pk_device_sign,omitempty"`
+}
+
+// PairingJoinResponse is returned to the new device after it joins
+type PairingJoinResponse struct {
+	PairingID    string    `json:"pairing_id"`
+	DeviceID     string    `json:"device_id"`
+	PairingToken string    `json:"pairing_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// PairingMessageRequest relays an opaque message to the other device
+type PairingMessageRequest struct {
+	Payload string `json:"payload"` // Key-exchange message or ciphertext; never interpreted by the server
+}
+
+// PairingMessage is a relayed message
+type PairingMessage struct {
+	MessageID int64     `json:"message_id"`
+	Sender    string    `json:"sender"` // "initiator" or "joiner"
+	Payload   string    `json:"payload"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PairingMessagesResponse carries the session status and the other device's new messages
+type PairingMessagesResponse struct {
+	Status   string           `json:"status"` // open, joined, completed or cancelled
+	Messages []PairingMessage `json:"messages"`
+}
+
+// PairingCompleteRequest activates the new device, optionally storing its vault key
+// envelope so later logins on that device return it like an approved enrollment does
+type PairingCompleteRequest struct {
+	WrappedVaultKey string `json:"wrapped_vault_key,omitempty"`
+}
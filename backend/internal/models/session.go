@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Session represents a server-side login session backing an issued token
+type Session struct {
+	SessionID  string     `json:"session_id" db:"session_id"`
+	UserID     string     `json:"user_id" db:"user_id"`
+	DeviceID   string     `json:"device_id" db:"device_id"`
+	UserAgent  string     `json:"user_agent" db:"user_agent"`
+	IPAddress  string     `json:"ip_address" db:"ip_address"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at" db:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
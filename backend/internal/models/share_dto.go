@@ -0,0 +1,58 @@
+package models
+
+import "time"
+
+// UserKeysResponse carries the public keys needed to share a vault with a user
+type UserKeysResponse struct {
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	PkEncrypt string `json:"pk_encrypt"`
+	PkSign    string `json:"pk_sign"`
+}
+
+// ShareVaultRequest invites a user to a vault. WrappedKey is the vault key sealed to
+// their pk_encrypt; Signature is the owner's pk_sign signature over auth.VaultShareMessage.
+type ShareVaultRequest struct {
+	Username   string `json:"username"`
+	Role       string `json:"role"` // read or write
+	WrappedKey string `json:"wrapped_key"`
+	KeyVersion int    `json:"key_version"`
+	Signature  string `json:"signature"`
+}
+
+// UpdateVaultMemberRequest changes a member's role
+type UpdateVaultMemberRequest struct {
+	Role string `json:"role"`
+}
+
+// VaultMemberResponse describes a user with access to a vault
+type VaultMemberResponse struct {
+	UserID     string     `json:"user_id"`
+	Username   string     `json:"username"`
+	Role       string     `json:"role"`   // owner, read or write
+	Status     string     `json:"status"` // pending or accepted; always accepted for the owner
+	CreatedAt  time.Time  `json:"created_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+}
+
+// VaultInviteResponse is a pending invite shown to its recipient, with everything
+// needed to check the owner's signature before accepting
+type VaultInviteResponse struct {
+	VaultID       string    `json:"vault_id"`
+	VaultName     string    `json:"vault_name"`
+	OwnerID       string    `json:"owner_id"`
+	OwnerUsername string    `json:"owner_username"`
+	OwnerPkSign   string    `json:"owner_pk_sign"`
+	Role          string    `json:"role"`
+	WrappedKey    string    `json:"wrapped_key"`
+	KeyVersion    int       `json:"key_version"`
+	Signature     string    `json:"signature"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// MemberKeyEnvelope is a vault key sealed to a member's pk_encrypt during a rotation
+type MemberKeyEnvelope struct {
+	UserID     string `json:"user_id"`
+	WrappedKey string `json:"wrapped_key"`
+	Signature  string `json:"signature"`
+}
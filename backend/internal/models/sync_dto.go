@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// SyncResponse lists what changed in the user's vaults since the request's cursor. Pass
+// Cursor as since on the next call.
+type SyncResponse struct {
+	Cursor        string               `json:"cursor"`
+	Entries       []VaultEntryResponse `json:"entries"`        // Created or updated, trashed ones included
+	Tombstones    []EntryTombstone     `json:"tombstones"`     // Deleted for good
+	RemovedVaults []string             `json:"removed_vaults"` // Deleted, or no longer shared with the user
+}
+
+// EntryTombstone records an entry deleted for good, so clients can drop their copy
+type EntryTombstone struct {
+	EntryID   string    `json:"entry_id"`
+	VaultID   string    `json:"vault_id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
@@ -10,6 +10,10 @@ type User struct {
 	PkEncrypt                string    `json:"pk_encrypt" db:"pk_encrypt"`
 	PkSign                   string    `json:"pk_sign" db:"pk_sign"`
 	PasswordHash             string    `json:"-" db:"password_hash"`
+	SRPSalt                  string    `json:"-" db:"srp_salt"`
+	SRPVerifier              string    `json:"-" db:"srp_verifier"`
+	PkRecovery               string    `json:"pk_recovery,omitempty" db:"pk_recovery"`
+	EncryptedPrivateKeys     string    `json:"encrypted_private_keys,omitempty" db:"encrypted_private_keys"`
 	IsMasterDeviceRegistered bool      `json:"is_master_device_registered" db:"is_master_device_registered"`
 	CreatedAt                time.Time `json:"created_at" db:"created_at"`
 }
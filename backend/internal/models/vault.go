@@ -4,11 +4,25 @@ import "time"
 
 // VaultEntry represents an individual vault entry (password, note, etc.)
 type VaultEntry struct {
-	EntryID       string    `json:"entry_id" db:"entry_id"`
-	UserID        string    `json:"user_id" db:"user_id"`
-	Title         string    `json:"title" db:"title"`
-	EncryptedData []byte    `json:"encrypted_data" db:"encrypted_data"`
-	EntryType     string    `json:"entry_type" db:"entry_type"` // "password", "note", "card", etc.
-	CreatedAt     time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+	EntryID       string     `json:"entry_id" db:"entry_id"`
+	VaultID       string     `json:"vault_id" db:"vault_id"`
+	UserID        string     `json:"user_id" db:"user_id"`
+	Title         string     `json:"title" db:"title"`
+	EncryptedData []byte     `json:"encrypted_data" db:"encrypted_data"`
+	EntryType     string     `json:"entry_type" db:"entry_type"` // "password", "note", "card", etc.
+	Revision      int        `json:"revision" db:"revision"`     // Bumped on every change, for If-Match
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
+	DeletedAt     *time.Time `json:"deleted_at" db:"deleted_at"` // Set while the entry is in the trash
+}
+
+// Vault groups entries under one symmetric vault key. Each user has a default vault,
+// created at registration, and can add more (e.g. "Work").
+type Vault struct {
+	VaultID    string    `json:"vault_id" db:"vault_id"`
+	UserID     string    `json:"user_id" db:"user_id"`
+	Name       string    `json:"name" db:"name"`
+	IsDefault  bool      `json:"is_default" db:"is_default"`   // Used by the /api/vault routes; can't be deleted
+	KeyVersion int       `json:"key_version" db:"key_version"` // Bumped each time the vault key is rotated
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
 }
@@ -11,10 +11,100 @@ type VaultEntryRequest struct {
 
 // VaultEntryResponse contains the vault entry data returned to the client
 type VaultEntryResponse struct {
+	EntryID       string     `json:"entry_id"`
+	VaultID       string     `json:"vault_id"`
+	Title         string     `json:"title"`
+	EncryptedData string     `json:"encrypted_data"` // Base64 encoded
+	EntryType     string     `json:"entry_type"`
+	Revision      int        `json:"revision"` // Bumped on every change; sent back in If-Match
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"` // Set while the entry is in the trash
+}
+
+// CreateVaultRequest creates a vault. WrappedKey is the new vault's key sealed to the
+// creating device's pk_device, which becomes its first envelope.
+type CreateVaultRequest struct {
+	Name       string `json:"name"`
+	WrappedKey string `json:"wrapped_key"`
+}
+
+// UpdateVaultRequest renames a vault
+type UpdateVaultRequest struct {
+	Name string `json:"name"`
+}
+
+// VaultResponse describes a vault
+type VaultResponse struct {
+	VaultID    string    `json:"vault_id"`
+	Name       string    `json:"name"`
+	IsDefault  bool      `json:"is_default"` // The user's own default vault
+	Role       string    `json:"role"`       // owner, or read or write for shared vaults
+	KeyVersion int       `json:"key_version"`
+	EntryCount int       `json:"entry_count"`
+	HasKey     bool      `json:"has_key"` // The requesting device or, for shared vaults, the user holds an envelope
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// EntryRevisionResponse is a previous version of a vault entry, encrypted with the vault
+// key of KeyVersion
+type EntryRevisionResponse struct {
+	RevisionID    string    `json:"revision_id"`
 	EntryID       string    `json:"entry_id"`
 	Title         string    `json:"title"`
 	EncryptedData string    `json:"encrypted_data"` // Base64 encoded
 	EntryType     string    `json:"entry_type"`
+	KeyVersion    int       `json:"key_version"`
+	ReplacedBy    *string   `json:"replaced_by,omitempty"` // Device whose change replaced this version
 	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// RevisionConflictResponse is the 412 body when If-Match doesn't match the entry's
+// current revision
+type RevisionConflictResponse struct {
+	Error           string `json:"error"`
+	CurrentRevision int    `json:"current_revision"`
+}
+
+// VaultEntryPage is one page of an entry listing. Pass NextCursor as cursor, with the
+// same sort and order, to get the next page; it is empty on the last one.
+type VaultEntryPage struct {
+	Entries    []VaultEntryResponse `json:"entries"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+// BatchRequest is a list of entry operations applied together: all of them or none
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchOperation creates, updates or deletes (moves to the trash) one entry. Updates and
+// deletes name the entry and the revision they are based on, like If-Match.
+type BatchOperation struct {
+	Op            string `json:"op"` // create, update or delete
+	EntryID       string `json:"entry_id,omitempty"`
+	Revision      int    `json:"revision,omitempty"`
+	Title         string `json:"title,omitempty"`
+	EncryptedData string `json:"encrypted_data,omitempty"` // Base64 encoded
+	EntryType     string `json:"entry_type,omitempty"`
+}
+
+// BatchResponse reports each operation's entry and its new revision, in request order
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// BatchResult is the outcome of one batch operation
+type BatchResult struct {
+	Op       string `json:"op"`
+	EntryID  string `json:"entry_id"`
+	Revision int    `json:"revision"`
+}
+
+// BatchErrorResponse says which operation made a batch fail; nothing was applied
+type BatchErrorResponse struct {
+	Error           string `json:"error"`
+	Index           int    `json:"index"`
+	EntryID         string `json:"entry_id,omitempty"`
+	CurrentRevision int    `json:"current_revision,omitempty"` // Set when the revision didn't match
 }
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// VaultKey is the vault key sealed to one device's pk_device (an envelope). The server
+// stores envelopes but can't open them.
+type VaultKey struct {
+	VaultID    string    `json:"vault_id" db:"vault_id"`
+	DeviceID   string    `json:"device_id" db:"device_id"`
+	WrappedKey string    `json:"wrapped_key" db:"wrapped_key"` // X25519 sealed box, base64
+	KeyVersion int       `json:"key_version" db:"key_version"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,88 @@
+package models
+
+import "time"
+
+// VaultKeyResponse is the requesting device's own vault key envelope or, for a shared
+// vault, the member's envelope sealed to their pk_encrypt and signed by the owner, or
+// the team envelope encrypted with the org key
+type VaultKeyResponse struct {
+	VaultID    string    `json:"vault_id"`
+	DeviceID   string    `json:"device_id,omitempty"`
+	OrgID      string    `json:"org_id,omitempty"` // Whose org key opens an org_key envelope
+	SealedTo   string    `json:"sealed_to"`        // pk_device, pk_encrypt or org_key
+	WrappedKey string    `json:"wrapped_key"`
+	KeyVersion int       `json:"key_version"`
+	Signature  string    `json:"signature,omitempty"` // Owner's signature, for pk_encrypt envelopes
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// VaultKeyDeviceResponse shows whether an active device holds an envelope for the
+// current vault key, with the pk_device needed to seal one for it
+type VaultKeyDeviceResponse struct {
+	DeviceID    string `json:"device_id"`
+	DeviceName  string `json:"device_name"`
+	PkDevice    string `json:"pk_device"`
+	IsMaster    bool   `json:"is_master"`
+	HasEnvelope bool   `json:"has_envelope"`
+	KeyVersion  int    `json:"key_version,omitempty"` // Version of the key in its envelope
+}
+
+// VaultKeysResponse lists the envelopes of a vault
+type VaultKeysResponse struct {
+	VaultID    string                   `json:"vault_id"`
+	KeyVersion int                      `json:"key_version"` // Current vault key version
+	Devices    []VaultKeyDeviceResponse `json:"devices"`
+}
+
+// PutVaultKeyRequest adds or replaces a device's envelope. KeyVersion must be the
+// vault's current version, so an envelope for a rotated-out key is refused.
+type PutVaultKeyRequest struct {
+	WrappedKey string `json:"wrapped_key"`
+	KeyVersion int    `json:"key_version"`
+}
+
+// VaultKeyEnvelope is the new vault key sealed to one device during a rotation
+type VaultKeyEnvelope struct {
+	DeviceID   string `json:"device_id"`
+	WrappedKey string `json:"wrapped_key"`
+}
+
+// RotatedVaultEntry is an entry re-encrypted under the new vault key
+type RotatedVaultEntry struct {
+	EntryID       string `json:"entry_id"`
+	Revision      int    `json:"revision"`       // The revision that was re-encrypted, like If-Match
+	EncryptedData string `json:"encrypted_data"` // Base64 encoded
+}
+
+// RotatedEntryRevision is a previous version of an entry re-encrypted under a new key
+type RotatedEntryRevision struct {
+	RevisionID    string `json:"revision_id"`
+	EncryptedData string `json:"encrypted_data"` // Base64 encoded
+}
+
+// RekeyedVault is a vault whose key changes with the password: its next key version and
+// a new envelope for every member and team, as in a rotation
+type RekeyedVault struct {
+	VaultID    string              `json:"vault_id"`
+	KeyVersion int                 `json:"key_version"` // Must be the current version + 1
+	Members    []MemberKeyEnvelope `json:"members"`
+	Teams      []TeamKeyEnvelope   `json:"teams"`
+}
+
+// RotateVaultKeyRequest replaces the vault key. Every entry must be re-encrypted and every
+// member and team given a new envelope; only the listed devices get the new key.
+type RotateVaultKeyRequest struct {
+	KeyVersion int                 `json:"key_version"` // Must be the current version + 1
+	Envelopes  []VaultKeyEnvelope  `json:"envelopes"`
+	Entries    []RotatedVaultEntry `json:"entries"`
+	Members    []MemberKeyEnvelope `json:"members"` // One per member of a shared vault
+	Teams      []TeamKeyEnvelope   `json:"teams"`   // One per team the vault is assigned to
+	// The new default vault key encrypted with the recovery secret; the old one is dropped either way
+	RecoveryWrappedVaultKey string `json:"recovery_wrapped_vault_key,omitempty"`
+}
+
+// RotateVaultKeyResponse confirms a rotation
+type RotateVaultKeyResponse struct {
+	VaultID    string `json:"vault_id"`
+	KeyVersion int    `json:"key_version"`
+}
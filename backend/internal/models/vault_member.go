@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// VaultMember gives another user access to a vault. The vault key is sealed to the
+// member's pk_encrypt and signed by the owner's pk_sign (see auth.VaultShareMessage).
+type VaultMember struct {
+	VaultID    string     `json:"vault_id" db:"vault_id"`
+	UserID     string     `json:"user_id" db:"user_id"`
+	Role       string     `json:"role" db:"role"`     // read or write
+	Status     string     `json:"status" db:"status"` // pending, accepted or declined
+	InvitedBy  string     `json:"invited_by" db:"invited_by"`
+	WrappedKey string     `json:"-" db:"wrapped_key"`
+	KeyVersion int        `json:"key_version" db:"key_version"`
+	Signature  string     `json:"-" db:"signature"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty" db:"accepted_at"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// WebAuthnCredential represents a passkey or security key registered from one of the user's devices
+type WebAuthnCredential struct {
+	CredentialID    string     `json:"credential_id" db:"credential_id"` // base64url
+	UserID          string     `json:"user_id" db:"user_id"`
+	DeviceID        string     `json:"device_id" db:"device_id"`
+	Name            string     `json:"name" db:"name"`
+	PublicKey       string     `json:"-" db:"public_key"` // COSE_Key, base64url
+	Alg             int        `json:"alg" db:"alg"`
+	SignCount       int64      `json:"-" db:"sign_count"`
+	PRFSalt         string     `json:"prf_salt" db:"prf_salt"`
+	PRFEnabled      bool       `json:"prf_enabled" db:"prf_enabled"`
+	WrappedVaultKey string     `json:"-" db:"wrapped_vault_key"` // Vault key wrapped with the PRF output
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+}
@@ -0,0 +1,169 @@
+package models
+
+import "time"
+
+// The publicKey options and credentials below follow the WebAuthn JSON serialization
+// (camelCase, binary values as base64url) so browsers can pass them to
+// navigator.credentials.create/get after decoding, and send PublicKeyCredential.toJSON() back.
+
+// PublicKeyCredentialJSON is a credential returned by the browser
+type PublicKeyCredentialJSON struct {
+	ID                     string                     `json:"id"`
+	RawID                  string                     `json:"rawId"`
+	Type                   string                     `json:"type"`
+	Response               AuthenticatorResponseJSON  `json:"response"`
+	ClientExtensionResults ClientExtensionResultsJSON `json:"clientExtensionResults"`
+}
+
+// AuthenticatorResponseJSON holds an attestation (registration) or assertion (login) response
+type AuthenticatorResponseJSON struct {
+	ClientDataJSON    string   `json:"clientDataJSON"`
+	AttestationObject string   `json:"attestationObject,omitempty"`
+	AuthenticatorData string   `json:"authenticatorData,omitempty"`
+	Signature         string   `json:"signature,omitempty"`
+	UserHandle        string   `json:"userHandle,omitempty"`
+	Transports        []string `json:"transports,omitempty"`
+}
+
+// ClientExtensionResultsJSON reports which requested extensions the authenticator supports
+type ClientExtensionResultsJSON struct {
+	PRF *PRFExtensionOutput `json:"prf,omitempty"`
+}
+
+// PRFExtensionOutput tells whether the credential supports the PRF extension.
+// PRF results never leave the client.
+type PRFExtensionOutput struct {
+	Enabled bool `json:"enabled"`
+}
+
+// PublicKeyCredentialCreationOptions are the options for navigator.credentials.create()
+type PublicKeyCredentialCreationOptions struct {
+	Challenge              string                         `json:"challenge"`
+	RP                     RelyingParty                   `json:"rp"`
+	User                   WebAuthnUserEntity             `json:"user"`
+	PubKeyCredParams       []PublicKeyCredentialParameter `json:"pubKeyCredParams"`
+	Timeout                int64                          `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor         `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection         `json:"authenticatorSelection"`
+	Attestation            string                         `json:"attestation"`
+	Extensions             WebAuthnExtensions             `json:"extensions"`
+}
+
+// PublicKeyCredentialRequestOptions are the options for navigator.credentials.get()
+type PublicKeyCredentialRequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+	Extensions       WebAuthnExtensions     `json:"extensions"`
+}
+
+// RelyingParty identifies this server to authenticators
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// WebAuthnUserEntity is the account a new credential is created for
+type WebAuthnUserEntity struct {
+	ID          string `json:"id"` // base64url user handle
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// PublicKeyCredentialParameter names an accepted credential algorithm
+type PublicKeyCredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// CredentialDescriptor references an existing credential
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// AuthenticatorSelection states what kind of authenticator we want
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// WebAuthnExtensions are the client extension inputs we request
+type WebAuthnExtensions struct {
+	PRF *PRFExtensionInput `json:"prf,omitempty"`
+}
+
+// PRFExtensionInput carries the salts the authenticator's PRF is evaluated on
+type PRFExtensionInput struct {
+	Eval             *PRFValues           `json:"eval,omitempty"`
+	EvalByCredential map[string]PRFValues `json:"evalByCredential,omitempty"`
+}
+
+// PRFValues is a base64url PRF salt
+type PRFValues struct {
+	First string `json:"first"`
+}
+
+// WebAuthnRegisterBeginResponse starts registering a credential for the current device
+type WebAuthnRegisterBeginResponse struct {
+	ChallengeID string                             `json:"challenge_id"`
+	PublicKey   PublicKeyCredentialCreationOptions `json:"publicKey"`
+}
+
+// WebAuthnRegisterFinishRequest completes a credential registration
+type WebAuthnRegisterFinishRequest struct {
+	ChallengeID     string                  `json:"challenge_id"`
+	Name            string                  `json:"name"`
+	Credential      PublicKeyCredentialJSON `json:"credential"`
+	WrappedVaultKey string                  `json:"wrapped_vault_key,omitempty"` // Vault key wrapped with the PRF output, if already available
+	ReauthRequest
+}
+
+// WebAuthnRegisterFinishResponse returns the new credential, plus recovery codes
+// when it is the user's first second factor
+type WebAuthnRegisterFinishResponse struct {
+	Credential    WebAuthnCredentialResponse `json:"credential"`
+	RecoveryCodes []string                   `json:"recovery_codes,omitempty"`
+}
+
+// WebAuthnCredentialResponse describes a registered credential
+type WebAuthnCredentialResponse struct {
+	CredentialID       string     `json:"credential_id"`
+	DeviceID           string     `json:"device_id"`
+	Name               string     `json:"name"`
+	PRFSalt            string     `json:"prf_salt"`
+	PRFEnabled         bool       `json:"prf_enabled"`
+	HasWrappedVaultKey bool       `json:"has_wrapped_vault_key"`
+	CreatedAt          time.Time  `json:"created_at"`
+	LastUsedAt         *time.Time `json:"last_used_at,omitempty"`
+}
+
+// WebAuthnLoginBeginRequest starts a passwordless login from an enrolled device
+type WebAuthnLoginBeginRequest struct {
+	Username          string `json:"username"`
+	DeviceFingerprint string `json:"device_fingerprint"`
+}
+
+// WebAuthnMFABeginRequest starts a WebAuthn second-factor check for a parked login
+type WebAuthnMFABeginRequest struct {
+	MFAToken string `json:"mfa_token"`
+}
+
+// WebAuthnAssertionBeginResponse carries the options for navigator.credentials.get()
+type WebAuthnAssertionBeginResponse struct {
+	ChallengeID string                            `json:"challenge_id"`
+	PublicKey   PublicKeyCredentialRequestOptions `json:"publicKey"`
+}
+
+// WebAuthnAssertionRequest answers a WebAuthn challenge
+type WebAuthnAssertionRequest struct {
+	ChallengeID string                  `json:"challenge_id"`
+	Credential  PublicKeyCredentialJSON `json:"credential"`
+}
+
+// WebAuthnPRFKeyRequest stores the vault key wrapped with a credential's PRF output
+type WebAuthnPRFKeyRequest struct {
+	WrappedVaultKey string `json:"wrapped_vault_key"`
+}
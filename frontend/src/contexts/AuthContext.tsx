@@ -1,6 +1,11 @@
 import React, { createContext, useContext, useState, useEffect } from "react";
 import type { ReactNode } from "react";
-import { API_BASE_URL, logoutUser } from "../helpers/api";
+import {
+  API_BASE_URL,
+  getUserInfo,
+  logoutUser,
+  setSessionExpiredHandler,
+} from "../helpers/api";
 
 export interface User {
   id: string;
@@ -42,25 +47,14 @@ export const AuthProvider: React.FC<AuthProviderProps> = ({ children }) => {
 
   const checkAuth = async () => {
     try {
-      // Try to get user info - cookie will be sent automatically
-      const response = await fetch(`${API_BASE_URL}/user/me`, {
-        method: "GET",
-        headers: {
-          "Content-Type": "application/json",
-        },
-        credentials: "include",
+      // Try to get user info - cookie will be sent automatically, and an expired
+      // access token is refreshed
+      const userData = await getUserInfo();
+      setUser({
+        id: userData.user_id,
+        email: userData.email,
+        username: userData.username,
       });
-
-      if (response.ok) {
-        const userData = await response.json();
-        setUser({
-          id: userData.user_id,
-          email: userData.email,
-          username: userData.username,
-        });
-      } else {
-        setUser(null);
-      }
     } catch (error) {
       console.error("Auth check failed:", error);
       setUser(null);
@@ -145,7 +139,10 @@ export const AuthProvider: React.FC<AuthProviderProps> = ({ children }) => {
   };
 
   useEffect(() => {
+    // The refresh token was rejected too, so the session is gone on the server
+    setSessionExpiredHandler(() => setUser(null));
     checkAuth();
+    return () => setSessionExpiredHandler(null);
   }, []);
 
   const value: AuthContextType = {
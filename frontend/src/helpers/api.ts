@@ -30,6 +30,7 @@ export interface VaultEntry {
   title: string;
   encrypted_data: string;
   entry_type: string;
+  revision: number;
   created_at: string;
   updated_at: string;
 }
@@ -51,6 +52,51 @@ function getAuthHeaders(): Record<string, string> {
   };
 }
 
+// The refresh in progress, shared by every request that got a 401 meanwhile. The server
+// treats a second use of the same refresh token as theft and ends the session, so
+// concurrent requests must not each refresh on their own.
+let refreshInFlight: Promise<boolean> | null = null;
+
+let onSessionExpired: (() => void) | null = null;
+
+// Registers what to do when the session can't be refreshed (e.g. sign the user out)
+export function setSessionExpiredHandler(handler: (() => void) | null) {
+  onSessionExpired = handler;
+}
+
+function refreshSession(): Promise<boolean> {
+  if (!refreshInFlight) {
+    refreshInFlight = fetch(`${API_BASE_URL}/auth/refresh`, {
+      method: "POST",
+      headers: getAuthHeaders(),
+      credentials: "include", // The refresh token is an HTTP-only cookie
+    })
+      .then((response) => response.ok)
+      .catch(() => false)
+      .finally(() => {
+        refreshInFlight = null;
+      });
+  }
+  return refreshInFlight;
+}
+
+// fetch for authenticated endpoints: on a 401 it refreshes the access token once and
+// retries the request; if that fails too, the session is over
+async function authFetch(path: string, init: RequestInit): Promise<Response> {
+  const request = () => fetch(`${API_BASE_URL}${path}`, { ...init, credentials: "include" });
+
+  const response = await request();
+  if (response.status !== 401) {
+    return response;
+  }
+
+  if (!(await refreshSession())) {
+    onSessionExpired?.();
+    return response;
+  }
+  return request();
+}
+
 export async function registerUser(payload: RegisterPayload) {
   const response = await fetch(`${API_BASE_URL}/auth/register`, {
     method: "POST",
@@ -99,10 +145,9 @@ export async function logoutUser() {
 }
 
 export async function getUserInfo(): Promise<User> {
-  const response = await fetch(`${API_BASE_URL}/user/me`, {
+  const response = await authFetch("/user/me", {
     method: "GET",
     headers: getAuthHeaders(),
-    credentials: "include", // Send cookies
   });
 
   if (!response.ok) {
@@ -113,10 +158,9 @@ export async function getUserInfo(): Promise<User> {
 }
 
 export async function createVaultEntry(payload: VaultEntryPayload) {
-  const response = await fetch(`${API_BASE_URL}/vault/entries`, {
+  const response = await authFetch("/vault/entries", {
     method: "POST",
     headers: getAuthHeaders(),
-    credentials: "include", // Send cookies
     body: JSON.stringify(payload),
   });
 
@@ -129,24 +173,35 @@ export async function createVaultEntry(payload: VaultEntryPayload) {
 }
 
 export async function getVaultEntries(): Promise<VaultEntry[]> {
-  const response = await fetch(`${API_BASE_URL}/vault/entries`, {
-    method: "GET",
-    headers: getAuthHeaders(),
-    credentials: "include", // Send cookies
-  });
+  const entries: VaultEntry[] = [];
+  let cursor: string | undefined;
 
-  if (!response.ok) {
-    throw new Error("Failed to fetch vault entries");
-  }
+  // The server returns entries a page at a time; follow next_cursor to the end
+  do {
+    const params = new URLSearchParams({ limit: "500" });
+    if (cursor) params.set("cursor", cursor);
 
-  return response.json();
+    const response = await authFetch(`/vault/entries?${params}`, {
+      method: "GET",
+      headers: getAuthHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error("Failed to fetch vault entries");
+    }
+
+    const page: { entries: VaultEntry[]; next_cursor?: string } = await response.json();
+    entries.push(...page.entries);
+    cursor = page.next_cursor;
+  } while (cursor);
+
+  return entries;
 }
 
-export async function updateVaultEntry(entryId: string, payload: VaultEntryPayload) {
-  const response = await fetch(`${API_BASE_URL}/vault/entries/${entryId}`, {
+export async function updateVaultEntry(entryId: string, revision: number, payload: VaultEntryPayload) {
+  const response = await authFetch(`/vault/entries/${entryId}`, {
     method: "PUT",
-    headers: getAuthHeaders(),
-    credentials: "include", // Send cookies
+    headers: { ...getAuthHeaders(), "If-Match": `"${revision}"` },
     body: JSON.stringify(payload),
   });
 
@@ -158,11 +213,10 @@ export async function updateVaultEntry(entryId: string, payload: VaultEntryPaylo
   return response.ok;
 }
 
-export async function deleteVaultEntry(entryId: string) {
-  const response = await fetch(`${API_BASE_URL}/vault/entries/${entryId}`, {
+export async function deleteVaultEntry(entryId: string, revision: number) {
+  const response = await authFetch(`/vault/entries/${entryId}`, {
     method: "DELETE",
-    headers: getAuthHeaders(),
-    credentials: "include", // Send cookies
+    headers: { ...getAuthHeaders(), "If-Match": `"${revision}"` },
   });
 
   if (!response.ok) {
@@ -214,7 +214,7 @@ export const VaultManager: React.FC = () => {
 
       if (editingEntry) {
         // Update existing entry
-        await updateVaultEntry(editingEntry.entry_id, {
+        await updateVaultEntry(editingEntry.entry_id, editingEntry.revision, {
           title: formData.title,
           encrypted_data: encryptedData,
           entry_type: entryType,
@@ -240,11 +240,11 @@ export const VaultManager: React.FC = () => {
     }
   };
 
-  const handleDeleteEntry = async (entryId: string) => {
+  const handleDeleteEntry = async (entryId: string, revision: number) => {
     if (!confirm("Are you sure you want to delete this entry?")) return;
 
     try {
-      await deleteVaultEntry(entryId);
+      await deleteVaultEntry(entryId, revision);
       setSnackbar({ open: true, message: "Entry deleted successfully" });
       loadEntries();
     } catch (err) {
@@ -297,7 +297,7 @@ export const VaultManager: React.FC = () => {
 
   const handleDelete = () => {
     if (selectedEntry) {
-      handleDeleteEntry(selectedEntry.entry_id);
+      handleDeleteEntry(selectedEntry.entry_id, selectedEntry.revision);
     }
   };
 